@@ -0,0 +1,204 @@
+package apifunc
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/goscript"
+	"github.com/suifengpiao14/goscript/yaegi"
+)
+
+// ScriptCompileError api脚本编译错误，Line 为脚本内的行号(从1开始，0表示无法定位)
+type ScriptCompileError struct {
+	ApiId string
+	File  string
+	Line  int
+	Err   error
+}
+
+func (e *ScriptCompileError) Error() string {
+	return fmt.Sprintf("api:%s,file:%s,line:%d: %s", e.ApiId, e.File, e.Line, e.Err.Error())
+}
+
+func (e *ScriptCompileError) Unwrap() error {
+	return e.Err
+}
+
+// ScriptCompileErrors 多个api脚本编译错误
+type ScriptCompileErrors []*ScriptCompileError
+
+func (es ScriptCompileErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (es ScriptCompileErrors) Unwrap() []error {
+	errs := make([]error, 0, len(es))
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// apiFuncSymbols 脚本中可引用的本包符号(import "github.com/suifengpiao14/apifunc")，每次返回新的map，避免引擎修改共享变量
+func apiFuncSymbols() (symbols map[string]map[string]reflect.Value) {
+	return map[string]map[string]reflect.Value{
+		"github.com/suifengpiao14/apifunc/apifunc": {
+			"ContextApiFunc": reflect.ValueOf((*ContextApiFunc)(nil)),
+			"BusinessFlowFn": reflect.ValueOf((*BusinessFlowFn)(nil)),
+			"RunApiFunc":     reflect.ValueOf(RunApiFunc),
+		},
+	}
+}
+
+func init() {
+	for path, symbols := range apiFuncSymbols() {
+		yaegi.Symbols[path] = symbols // yaegi 引擎编译时统一注册，无需修改引擎自身的符号(引擎副本与注册的引擎共用符号表)
+	}
+}
+
+// useApiFuncSymbols 引擎支持注册符号时，注册本包符号；yaegi 引擎已在 init 中注册
+func useApiFuncSymbols(engine goscript.ScriptI) {
+	if _, ok := engine.(*yaegi.ScriptGo); ok {
+		return
+	}
+	if user, ok := engine.(interface {
+		Use(symbols map[string]map[string]reflect.Value)
+	}); ok {
+		user.Use(apiFuncSymbols())
+	}
+}
+
+// apiScript 待编译的api脚本
+type apiScript struct {
+	apiId      string
+	file       string
+	funcName   string
+	code       string
+	lineOffset int // 自动补充的行数，定位错误时扣除
+}
+
+const apiScriptPackageClause = "package script"
+
+var packageClauseRegexp = regexp.MustCompile(`(?m)^\s*package\s+\w+`)
+
+// newApiScripts 收集api脚本，未声明包名时补充 package script
+func newApiScripts(apis Apis) (scripts []apiScript) {
+	scripts = make([]apiScript, 0)
+	for _, api := range apis {
+		if strings.TrimSpace(api.Script) == "" {
+			continue
+		}
+		funcName := fmt.Sprintf("%s%s", ApiLogicFuncNamePrefix, api.ApiName)
+		script := apiScript{
+			apiId:    api.ApiName,
+			file:     strings.ReplaceAll(funcName, ".", "/") + ".go",
+			funcName: funcName,
+			code:     api.Script,
+		}
+		if !packageClauseRegexp.MatchString(script.code) {
+			script.code = apiScriptPackageClause + "\n" + script.code
+			script.lineOffset = 1
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts
+}
+
+var scriptErrorPositionRegexp = regexp.MustCompile(`(\d+):\d+: `)
+
+func (script apiScript) compileError(err error) (compileErr *ScriptCompileError) {
+	compileErr = &ScriptCompileError{ApiId: script.apiId, File: script.file, Err: err}
+	if m := scriptErrorPositionRegexp.FindStringSubmatch(err.Error()); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		if line > script.lineOffset {
+			compileErr.Line = line - script.lineOffset
+		}
+	}
+	return compileErr
+}
+
+// compileApiScripts 将api脚本写入项目当前语言脚本引擎的副本并编译，项目本身的脚本一并编译，成功后替换项目的引擎；
+// 编译失败时逐个api定位错误，项目的引擎不受影响
+func (pro *Project) compileApiScripts(apis Apis) (err error) {
+	scripts := newApiScripts(apis)
+	if pro.CurrentLanguage == "" {
+		if len(scripts) == 0 {
+			return nil
+		}
+		pro.CurrentLanguage = goscript.SCRIPT_LANGUAGE_GO
+	}
+	base, err := pro._ScriptEngines.GetByLanguage(pro.CurrentLanguage)
+	if errors.Is(err, goscript.ERROR_NOT_FOUND_SCRIPTI_BY_LANGUAGE) {
+		if len(scripts) == 0 {
+			return nil
+		}
+		base, err = goscript.NewScriptEngine(pro.CurrentLanguage)
+	}
+	if err != nil {
+		return err
+	}
+	compile := func(codes ...string) (engine goscript.ScriptI, err error) {
+		engine, err = cloneScriptEngine(base)
+		if err != nil {
+			return nil, err
+		}
+		useApiFuncSymbols(engine)
+		engine.WriteCode(codes...)
+		err = engine.Compile()
+		if err != nil {
+			return nil, err
+		}
+		return engine, nil
+	}
+	codes := make([]string, 0, len(scripts))
+	for _, script := range scripts {
+		codes = append(codes, script.code)
+	}
+	engine, err := compile(codes...)
+	if err != nil {
+		return diagnoseApiScripts(scripts, compile, err)
+	}
+	compileErrs := make(ScriptCompileErrors, 0)
+	dstType := reflect.TypeOf((BusinessFlowFn)(nil))
+	for _, script := range scripts {
+		_, err = engine.GetSymbolFromScript(script.funcName, dstType)
+		if err != nil {
+			err = errors.WithMessagef(err, "script must define func %s", script.funcName[strings.LastIndex(script.funcName, ".")+1:])
+			compileErrs = append(compileErrs, script.compileError(err))
+		}
+	}
+	if len(compileErrs) > 0 {
+		return compileErrs
+	}
+	pro._ScriptEngines.AddReplace(engine)
+	return nil
+}
+
+// diagnoseApiScripts 使用引擎副本逐个编译api脚本，找出出错的api；项目脚本本身有误时返回原错误
+func diagnoseApiScripts(scripts []apiScript, compile func(codes ...string) (engine goscript.ScriptI, err error), compileErr error) (err error) {
+	if _, err = compile(); err != nil {
+		return compileErr
+	}
+	accepted := make([]string, 0, len(scripts))
+	compileErrs := make(ScriptCompileErrors, 0)
+	for _, script := range scripts {
+		_, err = compile(append(accepted, script.code)...)
+		if err != nil {
+			compileErrs = append(compileErrs, script.compileError(err))
+			continue
+		}
+		accepted = append(accepted, script.code)
+	}
+	if len(compileErrs) == 0 {
+		return compileErr
+	}
+	return compileErrs
+}
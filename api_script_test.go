@@ -0,0 +1,73 @@
+package apifunc_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/goscript"
+	"github.com/suifengpiao14/goscript/yaegi"
+)
+
+// newScriptApiModel 创建由 script 实现逻辑的接口
+func newScriptApiModel(apiId string, script string) (apiModel apifunc.ApiModel) {
+	apiModel = newApiModel(apiId, "/api/"+apiId, nil, "fullname=name,required")
+	apiModel.Script = script
+	apiModel.OutputSchema = outputSchema("fullname=greeting")
+	return apiModel
+}
+
+func TestApiModelScript(t *testing.T) {
+	hello := newScriptApiModel("hello", `
+import (
+	"github.com/suifengpiao14/apifunc"
+	"github.com/tidwall/gjson"
+)
+
+func ApiLogichello(ctx *apifunc.ContextApiFunc, input []byte) ([]byte, error) {
+	return []byte(`+"`"+`{"greeting":"hello `+"`"+` + gjson.GetBytes(input, "name").String() + `+"`"+`"}`+"`"+`), nil
+}
+`)
+	t.Run("run script", func(t *testing.T) {
+		container := newContainer()
+		container.RegisterAPIByModel(nil, hello)
+		require.NoError(t, container.Compile())
+		require.JSONEq(t, `{"greeting":"hello apifunc"}`, runApi(t, container, "/api/hello", `{"name":"apifunc"}`))
+	})
+
+	t.Run("compile error per api", func(t *testing.T) {
+		broken := newScriptApiModel("broken", `
+func ApiLogicbroken(ctx *apifunc.ContextApiFunc, input []byte) ([]byte, error) {
+	return undefinedFn(input), nil
+}
+`)
+		container := newContainer()
+		container.RegisterAPIByModel(nil, hello, broken)
+		err := container.Compile()
+		var compileErrs apifunc.ScriptCompileErrors
+		require.True(t, errors.As(err, &compileErrs))
+		require.Len(t, compileErrs, 1)
+		require.Equal(t, "broken", compileErrs[0].ApiId)
+		require.Equal(t, "script/ApiLogicbroken.go", compileErrs[0].File)
+		require.Equal(t, 3, compileErrs[0].Line)
+	})
+
+	t.Run("missing logic func", func(t *testing.T) {
+		other := newScriptApiModel("other", `func Helper() int { return 1 }`)
+		container := newContainer()
+		container.RegisterAPIByModel(nil, other)
+		err := container.Compile()
+		var compileErr *apifunc.ScriptCompileError
+		require.True(t, errors.As(err, &compileErr))
+		require.Equal(t, "other", compileErr.ApiId)
+	})
+
+	t.Run("registered engine without project scripts", func(t *testing.T) {
+		container := newContainer()
+		container.RegisterProject(goscript.SCRIPT_LANGUAGE_GO, goscript.ScriptIs{yaegi.NewScriptGo()}, nil)
+		container.RegisterAPIByModel(nil, hello)
+		require.NoError(t, container.Compile())
+		require.JSONEq(t, `{"greeting":"hello apifunc"}`, runApi(t, container, "/api/hello", `{"name":"apifunc"}`))
+	})
+}
@@ -0,0 +1,362 @@
+package apifunc
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/torm"
+)
+
+// CacheConfig 响应缓存配置，Api、torm 的 Policy 中配置后生效
+type CacheConfig struct {
+	TTL     time.Duration `json:"ttl"`     // 过期时间，0 表示只受容量及失效事件限制
+	MaxSize int           `json:"maxSize"` // 最大条目数，超出后淘汰最近最少使用的条目，默认1000
+}
+
+const CACHE_DEFAULT_MAX_SIZE = 1000
+
+type cacheEntry struct {
+	scope    string
+	key      string
+	value    []byte
+	expireAt time.Time
+	tags     []string
+}
+
+type cacheScope struct {
+	maxSize int
+	ll      *list.List // 最近使用的在前
+	items   map[string]*list.Element
+}
+
+// ResponseCache 响应缓存，按 api/torm 名称分区，各分区独立LRU；
+// 条目按读取的表打标签，同一资源的表发生增删改时失效
+type ResponseCache struct {
+	mu       sync.Mutex
+	scopes   map[string]*cacheScope
+	tagIndex map[string]map[*cacheEntry]struct{}
+	seq      uint64
+	tagSeq   map[string]uint64 // 标签最近一次失效的序号，避免写入失效前开始计算的结果
+	now      func() time.Time
+}
+
+func NewResponseCache() (cache *ResponseCache) {
+	cache = &ResponseCache{now: time.Now}
+	cache.Purge()
+	return cache
+}
+
+// Purge 清空缓存，容器发布新版本时调用
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scopes = make(map[string]*cacheScope)
+	c.tagIndex = make(map[string]map[*cacheEntry]struct{})
+	c.tagSeq = make(map[string]uint64)
+}
+
+// Seq 当前失效序号，计算前获取，写入时传入
+func (c *ResponseCache) Seq() (seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+func (c *ResponseCache) Get(scope string, key string) (value []byte, ok bool) {
+	value, _, ok = c.get(scope, key)
+	return value, ok
+}
+
+// get 同时返回条目的标签，torm缓存命中时标签并入api缓存
+func (c *ResponseCache) get(scope string, key string) (value []byte, tags []string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.scopes[scope]
+	if !ok {
+		return nil, nil, false
+	}
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !entry.expireAt.IsZero() && !c.now().Before(entry.expireAt) {
+		c.remove(s, el)
+		return nil, nil, false
+	}
+	s.ll.MoveToFront(el)
+	return append([]byte(nil), entry.value...), entry.tags, true
+}
+
+// Set 写入缓存，startSeq 之后标签已失效时不写入
+func (c *ResponseCache) Set(scope string, key string, value []byte, config CacheConfig, tags []string, startSeq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		if c.tagSeq[tag] > startSeq {
+			return
+		}
+	}
+	s, ok := c.scopes[scope]
+	if !ok {
+		s = &cacheScope{ll: list.New(), items: make(map[string]*list.Element)}
+		c.scopes[scope] = s
+	}
+	s.maxSize = config.MaxSize
+	if s.maxSize <= 0 {
+		s.maxSize = CACHE_DEFAULT_MAX_SIZE
+	}
+	if el, ok := s.items[key]; ok {
+		c.remove(s, el)
+	}
+	entry := &cacheEntry{scope: scope, key: key, value: append([]byte(nil), value...), tags: tags}
+	if config.TTL > 0 {
+		entry.expireAt = c.now().Add(config.TTL)
+	}
+	s.items[key] = s.ll.PushFront(entry)
+	for _, tag := range tags {
+		if c.tagIndex[tag] == nil {
+			c.tagIndex[tag] = make(map[*cacheEntry]struct{})
+		}
+		c.tagIndex[tag][entry] = struct{}{}
+	}
+	for s.ll.Len() > s.maxSize {
+		c.remove(s, s.ll.Back())
+	}
+}
+
+func (c *ResponseCache) remove(s *cacheScope, el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	s.ll.Remove(el)
+	delete(s.items, entry.key)
+	for _, tag := range entry.tags {
+		delete(c.tagIndex[tag], entry)
+		if len(c.tagIndex[tag]) == 0 {
+			delete(c.tagIndex, tag)
+		}
+	}
+}
+
+// InvalidateTables 资源中的表发生增删改时，使读取过这些表的缓存失效；可供外部(如 cudevent 订阅方)调用
+func (c *ResponseCache) InvalidateTables(sourceId string, tables ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	for _, table := range tables {
+		tag := cacheTag(sourceId, table)
+		c.tagSeq[tag] = c.seq
+		for entry := range c.tagIndex[tag] {
+			c.remove(c.scopes[entry.scope], c.scopes[entry.scope].items[entry.key])
+		}
+	}
+}
+
+// Len 缓存条目数
+func (c *ResponseCache) Len() (n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.scopes {
+		n += s.ll.Len()
+	}
+	return n
+}
+
+func cacheTag(sourceId string, table string) (tag string) {
+	return strings.ToLower(sourceId + "." + table)
+}
+
+// canonicalCacheKey 规范化入参json(对象键排序、去除空白)，相同语义的入参得到相同的key
+func canonicalCacheKey(input []byte) (key string, err error) {
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return "", nil
+	}
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+	err = decoder.Decode(&v)
+	if err != nil {
+		err = errors.WithMessagef(err, "cache key require json input,got:%s", string(input))
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+var (
+	writeTableRegexp = regexp.MustCompile("(?i)\\b(?:insert\\s+(?:ignore\\s+)?into|replace\\s+into|update|delete\\s+from|truncate(?:\\s+table)?)\\s+([`\\w.]+)")
+	readTableRegexp  = regexp.MustCompile("(?i)\\b(?:from|join)\\s+([`\\w.]+)")
+)
+
+// appendTables 从语句中提取表(去除库名前缀)，忽略大小写去重
+func appendTables(tables []string, re *regexp.Regexp, stmt string) []string {
+	for _, m := range re.FindAllStringSubmatch(stmt, -1) {
+		table := strings.Trim(m[1], "`")
+		if i := strings.LastIndex(table, "."); i >= 0 {
+			table = strings.Trim(table[i+1:], "`")
+		}
+		if table == "" || containsFold(tables, table) {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+func containsFold(items []string, s string) (ok bool) {
+	for _, item := range items {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+type sqlTablesKey struct{}
+
+// sqlTables 一次torm执行中实际执行的SQL读、写的表
+type sqlTables struct {
+	mu          sync.Mutex
+	executed    bool // 未经过SQL执行处理器(如 CURL、REDIS 资源)时无法判断是否有写操作
+	write       bool
+	readTables  []string
+	writeTables []string
+}
+
+// recordSQLTables 执行SQL的处理器调用，按渲染后的SQL记录读、写的表，供缓存打标签及失效
+func recordSQLTables(ctx context.Context, sqls string) {
+	tables, _ := ctx.Value(sqlTablesKey{}).(*sqlTables)
+	if tables == nil {
+		return
+	}
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+	tables.executed = true
+	for _, stmt := range SplitSQLStatements(sqls) {
+		if readStatementRegexp.MatchString(stmt) {
+			tables.readTables = appendTables(tables.readTables, readTableRegexp, stmt)
+			continue
+		}
+		tables.write = true
+		tables.writeTables = appendTables(tables.writeTables, writeTableRegexp, stmt)
+	}
+}
+
+// readOnly 只执行了读语句
+func (tables *sqlTables) readOnly() (ok bool) {
+	return tables.executed && !tables.write
+}
+
+func (tables *sqlTables) tags(sourceId string) (tags []string) {
+	tags = make([]string, 0, len(tables.readTables))
+	for _, table := range tables.readTables {
+		tags = append(tags, cacheTag(sourceId, table))
+	}
+	return tags
+}
+
+type cacheTagsKey struct{}
+
+// cacheTagCollector 收集api执行过程中torm读取的表，作为api缓存的标签
+type cacheTagCollector struct {
+	mu    sync.Mutex
+	tags  []string
+	write bool // 执行过写操作或无法判断(非SQL资源)的api不缓存
+}
+
+func (collector *cacheTagCollector) add(readOnly bool, tags ...string) {
+	if collector == nil {
+		return
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	collector.tags = append(collector.tags, tags...)
+	if !readOnly {
+		collector.write = true
+	}
+}
+
+func cacheTagCollectorFromContext(ctx context.Context) (collector *cacheTagCollector) {
+	collector, _ = ctx.Value(cacheTagsKey{}).(*cacheTagCollector)
+	return collector
+}
+
+// runApiWithCache 配置了缓存的api，按 api名称+规范化入参 读写缓存
+func (ctxApiFunc *ContextApiFunc) runApiWithCache(input []byte, fn func(ctxApiFunc *ContextApiFunc, input []byte) (out []byte, err error)) (out []byte, err error) {
+	api := ctxApiFunc._Api
+	cache := ctxApiFunc._Cache
+	if api.Policy.Cache == nil || cache == nil || explainFromContext(ctxApiFunc) != nil {
+		return fn(ctxApiFunc, input)
+	}
+	key, err := canonicalCacheKey(input)
+	if err != nil {
+		return nil, err
+	}
+	scope := "api:" + strings.ToLower(api.ApiName)
+	if out, ok := cache.Get(scope, key); ok {
+		return out, nil
+	}
+	startSeq := cache.Seq()
+	collector := &cacheTagCollector{}
+	out, err = fn(ctxApiFunc.WithContext(context.WithValue(ctxApiFunc.Context(), cacheTagsKey{}, collector)), input)
+	if err != nil {
+		return nil, err
+	}
+	if !collector.write {
+		cache.Set(scope, key, out, *api.Policy.Cache, collector.tags, startSeq)
+	}
+	return out, nil
+}
+
+// runTormWithCache 读语句按torm缓存配置读写缓存，执行后按实际执行的SQL使写过的表的缓存失效
+func (ctxApiFunc *ContextApiFunc) runTormWithCache(ctx context.Context, tor torm.Torm, policy Policy, input []byte, fn func(ctx context.Context) (out []byte, err error)) (out []byte, err error) {
+	cache := ctxApiFunc._Cache
+	if cache == nil {
+		return fn(ctx)
+	}
+	collector := cacheTagCollectorFromContext(ctxApiFunc)
+	t := transactionFromContext(ctxApiFunc) // 事务内不读写缓存，提交后再使写过的表失效
+	cacheable := policy.Cache != nil && t == nil && isReadTemplate(tor.TplText)
+	var key, scope string
+	var startSeq uint64
+	if cacheable {
+		key, err = canonicalCacheKey(input)
+		if err != nil {
+			return nil, err
+		}
+		scope = "torm:" + strings.ToLower(tor.TplName)
+		if out, tags, ok := cache.get(scope, key); ok {
+			collector.add(true, tags...)
+			return out, nil
+		}
+		startSeq = cache.Seq()
+	}
+	tables := &sqlTables{}
+	out, err = fn(context.WithValue(ctx, sqlTablesKey{}, tables))
+	if err != nil {
+		return nil, err
+	}
+	tags := tables.tags(tor.Source.Identifer)
+	collector.add(tables.readOnly(), tags...)
+	if t != nil {
+		t.addWriteTables(tables.writeTables...)
+		return out, nil
+	}
+	if len(tables.writeTables) > 0 {
+		cache.InvalidateTables(tor.Source.Identifer, tables.writeTables...)
+	}
+	if cacheable && tables.readOnly() && len(tags) > 0 {
+		cache.Set(scope, key, out, *policy.Cache, tags, startSeq)
+	}
+	return out, nil
+}
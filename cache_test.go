@@ -0,0 +1,109 @@
+package apifunc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestResponseCache(t *testing.T) {
+	container := newContainer()
+	apiModel := func(id string, dependent string, policy string) (model apifunc.ApiModel) {
+		model = newApiModel(id, "/api/"+id, []string{dependent})
+		model.Policy = apifunc.PolicyJson(policy)
+		return model
+	}
+	_, err := container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{
+				SourceID:   "db",
+				SourceType: apifunc.SOURCE_TYPE_FIXTURE,
+				Config: `{"fixtures":[
+					{"sql":"select * from t_user where page=1","result":[{"id":"1"}]},
+					{"sql":"select * from t_user where page=2","result":[{"id":"2"}]},
+					{"sql":"select * from t_user where page=3","result":[{"id":"3"}]},
+					{"sql":"select count(*) as total from t_user","result":[{"total":"3"}]},
+					{"sql":"insert into t_user (name) values ('a')","result":["4"]}
+				]}`,
+			},
+			{SourceID: "kv", SourceType: "REDIS", Config: `{"embedded":true}`},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "ListUser", SourceID: "db", Tpl: `{{define "ListUser"}}select * from t_user where page={{.page}}{{end}}`},
+			{TemplateID: "CountUser", SourceID: "db", Tpl: `{{define "CountUser"}}select count(*) as total from t_user{{end}}`, Policy: `{"cache":{"ttl":"50ms"}}`},
+			{TemplateID: "InsertUser", SourceID: "db", Tpl: `{{define "InsertUser"}}insert into t_user (name) values ('a'){{end}}`},
+			{TemplateID: "InsertTo", SourceID: "db", Tpl: `{{define "InsertTo"}}insert into {{.table}} (name) values ('a'){{end}}`},
+			{TemplateID: "Incr", SourceID: "kv", Tpl: `{{define "Incr"}}INCR counter{{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{
+			apiModel("listUser", "ListUser", `{"cache":{"ttl":"1m","maxSize":2}}`),
+			apiModel("countUser", "CountUser", ""),
+			apiModel("insertUser", "InsertUser", ""),
+			apiModel("insertTo", "InsertTo", ""),
+			apiModel("incr", "Incr", `{"cache":{"ttl":"1m"}}`),
+		},
+	})
+	require.NoError(t, err)
+	source, err := container.GetSource("db")
+	require.NoError(t, err)
+	provider := source.Provider.(*apifunc.FixtureProvider)
+	run := func(route string, input string) (out string) {
+		return runApi(t, container, route, input)
+	}
+	executed := func() (n int) {
+		n = len(provider.Statements())
+		provider.Reset()
+		return n
+	}
+
+	t.Run("canonical input", func(t *testing.T) {
+		run("/api/listUser", `{"page":1,"extra":{"b":1,"a":2}}`)
+		run("/api/listUser", `{ "extra":{"a":2,"b":1}, "page":1 }`)
+		require.Equal(t, 1, executed())
+	})
+
+	t.Run("invalidate on write", func(t *testing.T) {
+		run("/api/insertUser", `{}`)
+		require.Equal(t, 1, executed())
+		run("/api/listUser", `{"page":1,"extra":{"b":1,"a":2}}`)
+		require.Equal(t, 1, executed())
+	})
+
+	t.Run("invalidate by rendered sql", func(t *testing.T) {
+		run("/api/listUser", `{"page":1}`)
+		require.Equal(t, 1, executed())
+		run("/api/insertTo", `{"table":"t_user"}`) // 表名由入参决定
+		require.Equal(t, 1, executed())
+		run("/api/listUser", `{"page":1}`)
+		require.Equal(t, 1, executed())
+	})
+
+	t.Run("non sql torm not cached", func(t *testing.T) {
+		require.Equal(t, `1`, run("/api/incr", `{}`))
+		require.Equal(t, `2`, run("/api/incr", `{}`))
+	})
+
+	t.Run("lru", func(t *testing.T) {
+		container.ResponseCache().Purge()
+		run("/api/listUser", `{"page":1}`)
+		run("/api/listUser", `{"page":2}`)
+		run("/api/listUser", `{"page":1}`) // page 1 最近使用，page 2 被淘汰
+		run("/api/listUser", `{"page":3}`)
+		require.Equal(t, 3, executed())
+		run("/api/listUser", `{"page":1}`)
+		require.Equal(t, 0, executed())
+		run("/api/listUser", `{"page":2}`)
+		require.Equal(t, 1, executed())
+	})
+
+	t.Run("torm ttl", func(t *testing.T) {
+		run("/api/countUser", `{}`)
+		run("/api/countUser", `{}`)
+		require.Equal(t, 1, executed())
+		time.Sleep(60 * time.Millisecond)
+		run("/api/countUser", `{}`)
+		require.Equal(t, 1, executed())
+	})
+}
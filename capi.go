@@ -8,6 +8,7 @@ import (
 	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/pkg/errors"
 	"github.com/suifengpiao14/goscript"
+	"github.com/suifengpiao14/goscript/yaegi"
 	"github.com/suifengpiao14/packethandler"
 	"github.com/suifengpiao14/pathtransfer"
 	"github.com/suifengpiao14/stream"
@@ -68,6 +69,39 @@ func (pro *Project) ScriptEngines() (scriptEngines goscript.ScriptIs) {
 	return pro._ScriptEngines
 }
 
+// ScriptEngineClonerI 自定义脚本引擎实现复制，容器每次编译使用注册引擎的副本，注册的引擎本身不写入代码
+type ScriptEngineClonerI interface {
+	Clone() (engine goscript.ScriptI)
+}
+
+// cloneScriptEngine 复制注册的脚本引擎：yaegi 引擎复制注册的符号、源码文件系统及已写入的代码，编译结果在副本中重新生成
+func cloneScriptEngine(engine goscript.ScriptI) (clone goscript.ScriptI, err error) {
+	switch e := engine.(type) {
+	case ScriptEngineClonerI:
+		clone = e.Clone()
+	case *yaegi.ScriptGo:
+		cp := *e
+		clone = &cp
+	default:
+		err = errors.Errorf("script engine %T(language:%s) can not be cloned,implement ScriptEngineClonerI", engine, engine.Language())
+		return nil, err
+	}
+	return clone, nil
+}
+
+// cloneScriptEngines 复制脚本引擎集合，快照编译、运行只使用副本
+func cloneScriptEngines(engines goscript.ScriptIs) (clones goscript.ScriptIs, err error) {
+	clones = make(goscript.ScriptIs, 0, len(engines))
+	for _, engine := range engines {
+		clone, err := cloneScriptEngine(engine)
+		if err != nil {
+			return nil, err
+		}
+		clones = append(clones, clone)
+	}
+	return clones, nil
+}
+
 func (pro *Project) Init() (err error) {
 	for language, scripts := range pro.Scripts.GroupByLanguage() {
 		engine, err := pro._ScriptEngines.GetByLanguage(language) // 优先使用项目配置
@@ -77,21 +111,48 @@ func (pro *Project) Init() (err error) {
 				return err
 			}
 		}
-		for _, script := range scripts {
-			engine.WriteCode(script.Code)
+		useApiFuncSymbols(engine)
+		codes, err := pro.scriptCodes(language, scripts)
+		if err != nil {
+			return err
 		}
-		callScript, err := pro.FuncTransfers.GetCallFnScript(language)
+		engine.WriteCode(codes...)
+		err = engine.Compile() // 引擎副本可能带有注册引擎的编译结果，写入代码后重新编译
 		if err != nil {
 			return err
 		}
-		engine.WriteCode(callScript)
 		pro._ScriptEngines.AddReplace(engine)
 	}
 	return nil
 }
 
+// scriptCodes 脚本代码及转换函数调用代码
+func (pro Project) scriptCodes(language string, scripts goscript.Scripts) (codes []string, err error) {
+	codes = make([]string, 0, len(scripts)+1)
+	for _, script := range scripts {
+		codes = append(codes, script.Code)
+	}
+	callScript, err := pro.FuncTransfers.GetCallFnScript(language)
+	if err != nil {
+		return nil, err
+	}
+	codes = append(codes, callScript)
+	return codes, nil
+}
+
+// hasScriptLogic 脚本中是否实现了api逻辑函数(ApiLogicFuncNamePrefix+apiName)
+func (pro Project) hasScriptLogic(apiName string) (ok bool) {
+	engine, err := pro._ScriptEngines.GetByLanguage(pro.CurrentLanguage)
+	if err != nil {
+		return false
+	}
+	_, err = engine.GetSymbolFromScript(fmt.Sprintf("%s%s", ApiLogicFuncNamePrefix, apiName), nil)
+	return err == nil
+}
+
 type Api struct {
 	ApiName             string                 `json:"apiName"`
+	Title               string                 `json:"title"` // 接口标题，生成文档时作为摘要
 	Route               string                 `json:"route"`
 	Method              string                 `json:"method"`
 	Flow                packethandler.Flow     `json:"flow"` // 按顺序组装执行流程
@@ -100,8 +161,12 @@ type Api struct {
 	ResponseLineschema  string                 `json:"responseLineschema"`
 	ResponseDefaultJson string                 `json:"responseDefaultJson"` // 返回数据默认值,一般填充协议字段如: code,message
 	PathTransfers       pathtransfer.Transfers `json:"pathTransfers"`
+	Policy              Policy                 `json:"policy"`     // 超时、重试策略(重试只对 GET、HEAD 等只读方法生效)
+	Dependents          Dependents             `json:"dependents"` // 依赖的torm、转换函数，编译时校验；无业务函数、脚本时作为默认逻辑
+	Script              string                 `json:"script"`     // api逻辑脚本，需实现 ApiLogic<ApiName> 函数，编译时写入项目脚本引擎
 	ErrorHandler        stream.ErrorHandler
 	PacketHandlers      packethandler.PacketHandlers
+	modelErr            error // 模型转换时的错误，延迟到 Init 时报告
 }
 
 func (api Api) Key() string {
@@ -131,6 +196,9 @@ func (api *Api) Merge(mergedApi Api) (err error) {
 	if api.ApiName == "" {
 		api.ApiName = mergedApi.ApiName
 	}
+	if api.Title == "" {
+		api.Title = mergedApi.Title
+	}
 	if api.Route == "" {
 		api.Route = mergedApi.Route
 	}
@@ -149,6 +217,18 @@ func (api *Api) Merge(mergedApi Api) (err error) {
 	if len(api.PathTransfers) == 0 {
 		api.PathTransfers = mergedApi.PathTransfers
 	}
+	if api.Policy.IsEmpty() {
+		api.Policy = mergedApi.Policy
+	}
+	if len(api.Dependents) == 0 {
+		api.Dependents = mergedApi.Dependents
+	}
+	if api.Script == "" {
+		api.Script = mergedApi.Script
+	}
+	if api.modelErr == nil {
+		api.modelErr = mergedApi.modelErr
+	}
 
 	if api.ErrorHandler == nil {
 		api.ErrorHandler = mergedApi.ErrorHandler
@@ -184,6 +264,25 @@ func (aps *Apis) AddMerge(apis ...Api) {
 	}
 }
 
+// replaceModel 替换模型生成的api：以代码注册的同名(或同路由)api为基础合并新模型，不保留旧模型的属性及模型错误
+func (aps *Apis) replaceModel(modelApi Api, codeApis Apis) {
+	api := modelApi
+	for _, codeApi := range codeApis {
+		if codeApi.EqualFold(modelApi) {
+			api = codeApi
+			api.Merge(modelApi) //忽略错误，已经判定相同
+			break
+		}
+	}
+	for i, ap0 := range *aps {
+		if ap0.EqualFold(api) {
+			(*aps)[i] = api
+			return
+		}
+	}
+	*aps = append(*aps, api)
+}
+
 func (aps Apis) GetByName(name string) (api *Api, err error) {
 	for _, api := range aps {
 		if strings.EqualFold(api.ApiName, name) {
@@ -193,15 +292,46 @@ func (aps Apis) GetByName(name string) (api *Api, err error) {
 	return nil, ERROR_NOT_FOUND_API
 }
 
+// GetByRoute 按路由(完整匹配，不含路径参数)及方法查找，方法规则与 Router.Match 一致：支持逗号分隔的多个方法，未声明方法时匹配任意方法
 func (aps Apis) GetByRoute(route string, method string) (api *Api, err error) {
-	for _, api := range aps {
-		if strings.EqualFold(api.Key(), apiKey(route, method)) {
-			return &api, nil
+	method = strings.ToUpper(strings.TrimSpace(method))
+	anyIndex := -1
+	for i, a := range aps {
+		if !strings.EqualFold(a.Route, route) {
+			continue
 		}
+		for _, m := range a.Methods() {
+			if m == method {
+				return &a, nil
+			}
+			if m == ROUTE_METHOD_ANY && anyIndex < 0 {
+				anyIndex = i
+			}
+		}
+	}
+	if anyIndex >= 0 {
+		anyApi := aps[anyIndex]
+		return &anyApi, nil
 	}
 	return nil, ERROR_NOT_FOUND_API
 }
 
+// Methods 拆分逗号分隔的请求方法(如 "post,get")，为空时表示任意方法
+func (api Api) Methods() (methods []string) {
+	methods = make([]string, 0)
+	for _, method := range strings.Split(api.Method, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			continue
+		}
+		methods = append(methods, method)
+	}
+	if len(methods) == 0 {
+		methods = append(methods, ROUTE_METHOD_ANY)
+	}
+	return methods
+}
+
 func (api Api) GetRoute() (mehtod string, path string) {
 	return api.Method, api.Route
 }
@@ -213,6 +343,10 @@ func (api Api) PackSchema() (lineschema string) {
 }
 
 func (api *Api) Init() (err error) {
+	if api.modelErr != nil {
+		err = errors.WithMessagef(api.modelErr, "api:%s", api.ApiName)
+		return err
+	}
 	if api.ApiName == "" {
 		err = errors.Errorf("api name required,api key:%s", api.Key())
 		return err
@@ -263,12 +397,19 @@ func (api *Api) InitPacketHandler() (err error) {
 }
 
 func (api Api) Run(ctx context.Context, input []byte) (out []byte, err error) {
+	if err = ctx.Err(); err != nil { // 调用方已取消或超时
+		return nil, err
+	}
 	packetHandlers, err := api.PacketHandlers.GetByName(api.Flow...)
 	if err != nil {
 		return nil, err
 	}
-	s := stream.NewStream(api.ApiName, nil, packetHandlers...)
-	out, err = s.Run(ctx, input)
+	retryable := isIdempotentMethods(api.Methods())
+	err = api.Policy.Do(ctx, retryable, func(ctx context.Context) (err error) {
+		s := stream.NewStream(api.ApiName, nil, packetHandlers...)
+		out, err = s.Run(ctx, input)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
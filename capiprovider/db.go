@@ -0,0 +1,393 @@
+package capiprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/glob"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置文件格式，四张表(dictionary、api、source、template)字段一致，yaml、json 文件内容为记录数组
+const (
+	FORMAT_XML  = "xml"
+	FORMAT_YAML = "yaml"
+	FORMAT_JSON = "json"
+)
+
+// formatPatterns 各格式在表目录下匹配的文件
+var formatPatterns = map[string][]string{
+	FORMAT_XML:  {"**/*.xml"},
+	FORMAT_YAML: {"**/*.yaml", "**/*.yml"},
+	FORMAT_JSON: {"**/*.json"},
+}
+
+// RecordError 记录解析错误，Index 为出错记录在文件中的序号(从0开始)，文件整体结构错误时为 -1
+type RecordError struct {
+	File  string
+	Index int
+	Err   error
+}
+
+func (e *RecordError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("file:%s: %s", e.File, e.Err.Error())
+	}
+	return fmt.Sprintf("file:%s record:%d: %s", e.File, e.Index, e.Err.Error())
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// LoadYamlDB 从yaml文件加载数据，目录结构、匹配规则与 LoadXmlDB 一致
+func LoadYamlDB(env string, dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) (transferFuncModels apifunc.TransferFuncModels, apiModels apifunc.ApiModels, sourceModels apifunc.SourceModels, tormModels apifunc.TormModels, err error) {
+	return LoadDB(FORMAT_YAML, env, dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+}
+
+// LoadJsonDB 从json文件加载数据，目录结构、匹配规则与 LoadXmlDB 一致
+func LoadJsonDB(env string, dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) (transferFuncModels apifunc.TransferFuncModels, apiModels apifunc.ApiModels, sourceModels apifunc.SourceModels, tormModels apifunc.TormModels, err error) {
+	return LoadDB(FORMAT_JSON, env, dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+}
+
+// LoadDB 按格式加载四张表，资源按环境继承链合并后转为模型
+func LoadDB(format string, env string, dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) (transferFuncModels apifunc.TransferFuncModels, apiModels apifunc.ApiModels, sourceModels apifunc.SourceModels, tormModels apifunc.TormModels, err error) {
+	transferFuncRecords, err := loadTable[TransferFuncRecord](format, dictFileDir)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	apiRecords, err := loadTable[ApiRecord](format, apiFileDir)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	sourceRecords, err := loadTable[SourceRecord](format, sourceFileDir)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	templateRecords, err := loadTable[TemplateRecord](format, tormFileDir)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	models, err := toModelSet(env, transferFuncRecords, apiRecords, sourceRecords, templateRecords)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return models.TransferFuncModels, models.ApiModels, models.SourceModels, models.TormModels, nil
+}
+
+// toModelSet 资源按环境继承链合并后转为模型
+func toModelSet(env string, transferFuncRecords TransferFuncRecords, apiRecords ApiRecords, sourceRecords SourceRecords, templateRecords TemplateRecords) (models apifunc.ModelSet, err error) {
+	sourceRecords, err = sourceRecords.ResolveEnv(env)
+	if err != nil {
+		return models, err
+	}
+	transferFuncModels, apiModels, sourceModels, tormModels := convertToModel(transferFuncRecords, apiRecords, sourceRecords, templateRecords)
+	models = apifunc.ModelSet{
+		TransferFuncModels: transferFuncModels,
+		ApiModels:          apiModels,
+		SourceModels:       sourceModels,
+		TormModels:         tormModels,
+	}
+	return models, nil
+}
+
+// globFiles 目录下匹配格式的文件
+func globFiles(format string, dir string) (filenames []string, err error) {
+	patterns, ok := formatPatterns[format]
+	if !ok {
+		err = errors.Errorf("unsupported format:%s", format)
+		return nil, err
+	}
+	for _, pattern := range patterns {
+		matches, err := glob.GlobDirectory(filepath.Join(dir, pattern))
+		if err != nil {
+			err = errors.WithStack(err)
+			return nil, err
+		}
+		filenames = append(filenames, matches...)
+	}
+	return filenames, nil
+}
+
+func loadTable[T any](format string, dir string) (records []T, err error) {
+	filenames, err := globFiles(format, dir)
+	if err != nil {
+		return nil, err
+	}
+	records = make([]T, 0)
+	for _, filename := range filenames {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		fileRecords, err := decodeRecords[T](format, b)
+		if err != nil {
+			return nil, withFile(err, filename)
+		}
+		records = append(records, fileRecords...)
+	}
+	return records, nil
+}
+
+// withFile 解析错误补充文件名，非 RecordError 按文件结构错误处理
+func withFile(err error, filename string) error {
+	var recordErr *RecordError
+	if errors.As(err, &recordErr) {
+		recordErr.File = filename
+		return recordErr
+	}
+	return &RecordError{File: filename, Index: -1, Err: err}
+}
+
+// decodeRecords 逐条解析记录，出错时返回 RecordError 指明记录序号
+func decodeRecords[T any](format string, b []byte) (records []T, err error) {
+	records = make([]T, 0)
+	switch format {
+	case FORMAT_XML:
+		return decodeXmlRecords[T](b)
+	case FORMAT_YAML:
+		var nodes []yaml.Node
+		err = yaml.Unmarshal(b, &nodes)
+		if err != nil {
+			return nil, err
+		}
+		for i, node := range nodes {
+			var record T
+			if err = node.Decode(&record); err != nil {
+				return nil, &RecordError{Index: i, Err: err}
+			}
+			records = append(records, record)
+		}
+	case FORMAT_JSON:
+		var raws []json.RawMessage
+		err = json.Unmarshal(b, &raws)
+		if err != nil {
+			return nil, err
+		}
+		for i, raw := range raws {
+			var record T
+			if err = json.Unmarshal(raw, &record); err != nil {
+				return nil, &RecordError{Index: i, Err: err}
+			}
+			records = append(records, record)
+		}
+	default:
+		return nil, errors.Errorf("unsupported format:%s", format)
+	}
+	return records, nil
+}
+
+// decodeXmlRecords 解析 Navicat 导出的 <RECORDS><RECORD> 结构
+func decodeXmlRecords[T any](b []byte) (records []T, err error) {
+	records = make([]T, 0)
+	decodeXML := xml.NewDecoder(bytes.NewReader(b))
+	decodeXML.Strict = false
+	root := false
+	for index := 0; ; {
+		token, err := decodeXML.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if !root {
+				return nil, err
+			}
+			return nil, &RecordError{Index: index, Err: err}
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case !root && start.Name.Local == "RECORDS":
+			root = true
+		case !root:
+			return nil, errors.Errorf("expected element type <RECORDS> but have <%s>", start.Name.Local)
+		case start.Name.Local == "RECORD":
+			var record T
+			if err = decodeXML.DecodeElement(&record, &start); err != nil {
+				return nil, &RecordError{Index: index, Err: err}
+			}
+			records = append(records, record)
+			index++
+		default:
+			if err = decodeXML.Skip(); err != nil {
+				return nil, &RecordError{Index: index, Err: err}
+			}
+		}
+	}
+	if !root {
+		return nil, errors.New("expected element type <RECORDS>")
+	}
+	return records, nil
+}
+
+// encodeRecords yaml 中多行文本(模板、脚本)输出为块文本，json 不转义html字符，便于阅读、比较差异
+func encodeRecords[T any](format string, records []T) (b []byte, err error) {
+	if records == nil {
+		records = make([]T, 0)
+	}
+	var w bytes.Buffer
+	switch format {
+	case FORMAT_YAML:
+		jsonByte, err := json.Marshal(records)
+		if err != nil {
+			return nil, err
+		}
+		var node yaml.Node
+		err = yaml.Unmarshal(jsonByte, &node) // 经json保留字段顺序
+		if err != nil {
+			return nil, err
+		}
+		setYamlStyle(&node)
+		encoder := yaml.NewEncoder(&w)
+		encoder.SetIndent(2)
+		err = encoder.Encode(&node)
+		if err == nil {
+			err = encoder.Close()
+		}
+	case FORMAT_JSON:
+		encoder := json.NewEncoder(&w)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(records)
+	default:
+		err = errors.Errorf("unsupported format:%s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// setYamlStyle 输出块风格yaml，多行文本使用块文本；以空白开头的多行文本使用块文本时首行空白会丢失，使用双引号
+func setYamlStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" && strings.ContainsRune(node.Value, '\n') {
+		node.Style = yaml.LiteralStyle
+		if strings.TrimLeft(node.Value, " \t\n") != node.Value {
+			node.Style = yaml.DoubleQuotedStyle
+		}
+	}
+	for _, child := range node.Content {
+		setYamlStyle(child)
+	}
+}
+
+// ConvertXmlDB 将 xmldb 四张表转换为 yaml 或 json 格式，分别写入 dstDir 下的 dictionary、api、source、template 目录，
+// 文件相对路径不变、扩展名替换。xml 记录中存在未定义的字段时报错；写入后回读比对，保证转换无损
+func ConvertXmlDB(format string, dstDir string, dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) (files []string, err error) {
+	if format != FORMAT_YAML && format != FORMAT_JSON {
+		err = errors.Errorf("unsupported target format:%s", format)
+		return nil, err
+	}
+	tables := []struct {
+		name    string
+		srcDir  string
+		convert func(format string, srcDir string, dstDir string) (files []string, err error)
+	}{
+		{"dictionary", dictFileDir, convertTable[TransferFuncRecord]},
+		{"api", apiFileDir, convertTable[ApiRecord]},
+		{"source", sourceFileDir, convertTable[SourceRecord]},
+		{"template", tormFileDir, convertTable[TemplateRecord]},
+	}
+	for _, table := range tables {
+		tableFiles, err := table.convert(format, table.srcDir, filepath.Join(dstDir, table.name))
+		if err != nil {
+			err = errors.WithMessagef(err, "table:%s", table.name)
+			return nil, err
+		}
+		files = append(files, tableFiles...)
+	}
+	return files, nil
+}
+
+func convertTable[T any](format string, srcDir string, dstDir string) (files []string, err error) {
+	filenames, err := globFiles(FORMAT_XML, srcDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range filenames {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		err = checkXmlFields[T](b)
+		if err != nil {
+			err = errors.WithMessagef(err, "file:%s", filename)
+			return nil, err
+		}
+		records, err := decodeRecords[T](FORMAT_XML, b)
+		if err != nil {
+			return nil, withFile(err, filename)
+		}
+		out, err := encodeRecords(format, records)
+		if err != nil {
+			return nil, err
+		}
+		back, err := decodeRecords[T](format, out)
+		if err != nil || !(len(back) == 0 && len(records) == 0 || reflect.DeepEqual(back, records)) {
+			err = errors.Errorf("file:%s convert to %s is not lossless", filename, format)
+			return nil, err
+		}
+		rel, err := filepath.Rel(srcDir, filename)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(dstDir, strings.TrimSuffix(rel, filepath.Ext(rel))+"."+format)
+		err = os.MkdirAll(filepath.Dir(dst), 0o755)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(dst, out, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dst)
+	}
+	return files, nil
+}
+
+// checkXmlFields 检查 RECORD 下的字段均在记录结构中定义，避免转换时丢弃数据
+func checkXmlFields[T any](b []byte) (err error) {
+	known := make(map[string]bool)
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("xml"), ",")
+		known[name] = true
+	}
+	decodeXML := xml.NewDecoder(bytes.NewReader(b))
+	decodeXML.Strict = false
+	depth, index := 0, -1
+	for {
+		token, err := decodeXML.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 {
+				index++
+			}
+			if depth == 3 && !known[t.Name.Local] {
+				err = errors.Errorf("record %d: unknown field <%s>", index, t.Name.Local)
+				return err
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package capiprovider_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc/capiprovider"
+)
+
+func TestConvertXmlDB(t *testing.T) {
+	const root = "./example/xmldb"
+	dirs := func(root string) (dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) {
+		return filepath.Join(root, "dictionary"), filepath.Join(root, "api"), filepath.Join(root, "source"), filepath.Join(root, "template")
+	}
+	xmlTransferFuncModels, xmlApiModels, xmlSourceModels, xmlTormModels, err := capiprovider.LoadXmlDB("local", root+"/dictionary", root+"/api", root+"/source", root+"/template")
+	require.NoError(t, err)
+
+	for _, format := range []string{capiprovider.FORMAT_YAML, capiprovider.FORMAT_JSON} {
+		t.Run(format, func(t *testing.T) {
+			dst := t.TempDir()
+			dictFileDir, apiFileDir, sourceFileDir, tormFileDir := dirs(root)
+			files, err := capiprovider.ConvertXmlDB(format, dst, dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+			require.NoError(t, err)
+			require.Contains(t, files, filepath.Join(dst, "source", "source_local."+format))
+
+			transferFuncModels, apiModels, sourceModels, tormModels, err := capiprovider.LoadDB(format, "local", dst+"/dictionary", dst+"/api", dst+"/source", dst+"/template")
+			require.NoError(t, err)
+			require.Equal(t, xmlTransferFuncModels, transferFuncModels)
+			require.Equal(t, xmlApiModels, apiModels)
+			require.Equal(t, xmlSourceModels, sourceModels)
+			require.Equal(t, xmlTormModels, tormModels)
+		})
+	}
+
+	t.Run("yaml block text", func(t *testing.T) {
+		dst := t.TempDir()
+		dictFileDir, apiFileDir, sourceFileDir, tormFileDir := dirs(root)
+		_, err := capiprovider.ConvertXmlDB(capiprovider.FORMAT_YAML, dst, dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+		require.NoError(t, err)
+		b, err := os.ReadFile(filepath.Join(dst, "source", "source_local.yaml"))
+		require.NoError(t, err)
+		require.Contains(t, string(b), "ddl: |")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		src := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(src, "source"), 0o755))
+		xmlData := `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<source_id>db</source_id>
+<env>dev</env>
+</RECORD>
+<RECORD>
+<source_id>db2</source_id>
+<created_at>2024-01-01</created_at>
+</RECORD>
+</RECORDS>`
+		require.NoError(t, os.WriteFile(filepath.Join(src, "source", "source.xml"), []byte(xmlData), 0o644))
+		dictFileDir, apiFileDir, sourceFileDir, tormFileDir := dirs(src)
+		_, err := capiprovider.ConvertXmlDB(capiprovider.FORMAT_YAML, t.TempDir(), dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+		require.ErrorContains(t, err, "record 1: unknown field <created_at>")
+	})
+}
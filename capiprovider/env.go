@@ -0,0 +1,132 @@
+package capiprovider
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// EnvChain 环境继承链，从 env 开始依次为父环境，如 prod、test、dev、base
+func (ss SourceRecords) EnvChain(env string) (chain []string, err error) {
+	parents := make(map[string]string)
+	for _, s := range ss {
+		if s.ParentEnv == "" {
+			continue
+		}
+		if parent, ok := parents[s.ENV]; ok && parent != s.ParentEnv {
+			err = errors.Errorf("env:%s has conflicting parent_env:%s,%s", s.ENV, parent, s.ParentEnv)
+			return nil, err
+		}
+		parents[s.ENV] = s.ParentEnv
+	}
+	visited := make(map[string]bool)
+	for current := env; current != ""; current = parents[current] {
+		if visited[current] {
+			err = errors.Errorf("env:%s parent_env cycle:%s", env, strings.Join(append(chain, current), "<-"))
+			return nil, err
+		}
+		visited[current] = true
+		chain = append(chain, current)
+	}
+	return chain, nil
+}
+
+// ResolveEnv 按继承链合并资源记录：从根环境开始，子环境记录覆盖同 source_id 的父环境记录，
+// config、ssh_config 均为json对象时按 key 合并(值为 null 删除该 key)，其余非空字段直接覆盖；结果记录的 env 为 env
+func (ss SourceRecords) ResolveEnv(env string) (out SourceRecords, err error) {
+	chain, err := ss.EnvChain(env)
+	if err != nil {
+		return nil, err
+	}
+	out = make(SourceRecords, 0)
+	index := make(map[string]int)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for _, s := range ss.FilterByEnv(chain[i]) {
+			if s.SourceID == "" {
+				continue
+			}
+			key := strings.ToLower(s.SourceID)
+			j, ok := index[key]
+			if !ok {
+				s.ENV, s.ParentEnv = env, ""
+				index[key] = len(out)
+				out = append(out, s)
+				continue
+			}
+			merged, err := out[j].override(s)
+			if err != nil {
+				err = errors.WithMessagef(err, "source:%s,env:%s", s.SourceID, s.ENV)
+				return nil, err
+			}
+			out[j] = merged
+		}
+	}
+	return out, nil
+}
+
+func (s SourceRecord) override(child SourceRecord) (merged SourceRecord, err error) {
+	merged = s
+	if child.SourceType != "" {
+		merged.SourceType = child.SourceType
+	}
+	if child.DDL != "" {
+		merged.DDL = child.DDL
+	}
+	merged.Config, err = mergeJsonConfig(s.Config, child.Config)
+	if err != nil {
+		return merged, errors.WithMessage(err, "config")
+	}
+	merged.SSHConfig, err = mergeJsonConfig(s.SSHConfig, child.SSHConfig)
+	if err != nil {
+		return merged, errors.WithMessage(err, "ssh_config")
+	}
+	return merged, nil
+}
+
+// mergeJsonConfig 两者均为json对象时递归合并，否则非空的 override 整体替换
+func mergeJsonConfig(base string, override string) (merged string, err error) {
+	if strings.TrimSpace(override) == "" {
+		return base, nil
+	}
+	baseObj, baseOk := decodeJsonObject(base)
+	overrideObj, overrideOk := decodeJsonObject(override)
+	if !baseOk || !overrideOk {
+		return override, nil
+	}
+	mergeJsonObject(baseObj, overrideObj)
+	var w bytes.Buffer
+	encoder := json.NewEncoder(&w)
+	encoder.SetEscapeHTML(false) // dsn 中的 & 保持原样
+	err = encoder.Encode(baseObj)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(w.String()), nil
+}
+
+func decodeJsonObject(s string) (obj map[string]any, ok bool) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil || obj == nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+func mergeJsonObject(base map[string]any, override map[string]any) {
+	for key, value := range override {
+		if value == nil {
+			delete(base, key)
+			continue
+		}
+		baseSub, baseOk := base[key].(map[string]any)
+		overrideSub, overrideOk := value.(map[string]any)
+		if baseOk && overrideOk {
+			mergeJsonObject(baseSub, overrideSub)
+			continue
+		}
+		base[key] = value
+	}
+}
@@ -0,0 +1,39 @@
+package capiprovider_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc/capiprovider"
+)
+
+func TestSourceRecordsResolveEnv(t *testing.T) {
+	records := capiprovider.SourceRecords{
+		{SourceID: "db", ENV: "base", SourceType: "SQL", Config: `{"logLevel":"debug","dsn":"u:p@tcp(dev:3306)/db?charset=utf8&timeout=1s","timeout":30}`},
+		{SourceID: "cache", ENV: "base", SourceType: "REDIS", Config: `{"addr":"dev:6379"}`},
+		{SourceID: "db", ENV: "dev", ParentEnv: "base", DDL: "create table t(id int);"},
+		{ENV: "test", ParentEnv: "dev"}, // 仅声明继承关系
+		{SourceID: "db", ENV: "prod", ParentEnv: "test", Config: `{"logLevel":null,"dsn":"u:${secret:db.password}@tcp(prod:3306)/db?charset=utf8&timeout=1s"}`},
+		{SourceID: "search", ENV: "prod", SourceType: "CURL", Config: `{"url":"http://search"}`},
+	}
+	chain, err := records.EnvChain("prod")
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod", "test", "dev", "base"}, chain)
+
+	out, err := records.ResolveEnv("prod")
+	require.NoError(t, err)
+	require.Equal(t, capiprovider.SourceRecords{
+		{SourceID: "db", ENV: "prod", SourceType: "SQL", Config: `{"dsn":"u:${secret:db.password}@tcp(prod:3306)/db?charset=utf8&timeout=1s","timeout":30}`, DDL: "create table t(id int);"},
+		{SourceID: "cache", ENV: "prod", SourceType: "REDIS", Config: `{"addr":"dev:6379"}`},
+		{SourceID: "search", ENV: "prod", SourceType: "CURL", Config: `{"url":"http://search"}`},
+	}, out)
+
+	out, err = records.ResolveEnv("dev")
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+	require.Equal(t, "dev", out[0].ENV)
+
+	records = append(records, capiprovider.SourceRecord{ENV: "base", ParentEnv: "prod"})
+	_, err = records.ResolveEnv("prod")
+	require.ErrorContains(t, err, "cycle")
+}
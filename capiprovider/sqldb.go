@@ -0,0 +1,446 @@
+package capiprovider
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/apifunc"
+)
+
+const (
+	SQLDB_DIALECT_MYSQL  = "mysql"
+	SQLDB_DIALECT_SQLITE = "sqlite"
+)
+
+// SQLDBConfig 数据库配置源的表名配置，各表字段名与xml字段名一致，另有自增主键 id 及更新时间列
+type SQLDBConfig struct {
+	Dialect         string        // mysql(默认)、sqlite，决定迁移使用的建表语句
+	DictionaryTable string        // 默认 apifunc_dictionary
+	ApiTable        string        // 默认 apifunc_api
+	SourceTable     string        // 默认 apifunc_source
+	TemplateTable   string        // 默认 apifunc_template
+	UpdatedAtColumn string        // 默认 updated_at；SQLite 不支持 on update，更新记录时需同时更新该列
+	PollOverlap     time.Duration // 增量拉取时从已读取的最大更新时间回溯的窗口，覆盖晚提交但更新时间较早的记录，默认5秒
+}
+
+func (c SQLDBConfig) withDefault() SQLDBConfig {
+	if c.Dialect == "" {
+		c.Dialect = SQLDB_DIALECT_MYSQL
+	}
+	if c.DictionaryTable == "" {
+		c.DictionaryTable = "apifunc_dictionary"
+	}
+	if c.ApiTable == "" {
+		c.ApiTable = "apifunc_api"
+	}
+	if c.SourceTable == "" {
+		c.SourceTable = "apifunc_source"
+	}
+	if c.TemplateTable == "" {
+		c.TemplateTable = "apifunc_template"
+	}
+	if c.UpdatedAtColumn == "" {
+		c.UpdatedAtColumn = "updated_at"
+	}
+	if c.PollOverlap <= 0 {
+		c.PollOverlap = SQLDB_DEFAULT_POLL_OVERLAP
+	}
+	return c
+}
+
+const SQLDB_DEFAULT_POLL_OVERLAP = 5 * time.Second
+
+// SQLDB_MIGRATION_TABLE 记录已执行的迁移版本
+const SQLDB_MIGRATION_TABLE = "apifunc_schema_migrations"
+
+// sqlDBMigrations 按版本顺序执行的迁移(MySQL语法，SQLite 通过 apifunc.MysqlDDLToSQLite 转换)，只能追加
+var sqlDBMigrations = []func(c SQLDBConfig) (ddl string){
+	func(c SQLDBConfig) (ddl string) {
+		tables := []struct {
+			name    string
+			columns []string
+		}{
+			{c.DictionaryTable, []string{"`language` varchar(32) NOT NULL DEFAULT ''", "`script` text", "`transfer_line` text"}},
+			{c.ApiTable, []string{"`api_id` varchar(128) NOT NULL DEFAULT ''", "`title` varchar(255) NOT NULL DEFAULT ''", "`method` varchar(32) NOT NULL DEFAULT ''", "`route` varchar(255) NOT NULL DEFAULT ''",
+				"`script` text", "`dependents` text", "`input_schema` text", "`output_schema` text", "`transfer_line` text", "`flow` text", "`policy` text"}},
+			{c.SourceTable, []string{"`source_id` varchar(128) NOT NULL DEFAULT ''", "`env` varchar(64) NOT NULL DEFAULT ''", "`source_type` varchar(32) NOT NULL DEFAULT ''",
+				"`config` text", "`ssh_config` text", "`ddl` text", "`parent_env` varchar(64) NOT NULL DEFAULT ''"}},
+			{c.TemplateTable, []string{"`template_id` varchar(128) NOT NULL DEFAULT ''", "`sub_template_id` varchar(128) NOT NULL DEFAULT ''", "`title` varchar(255) NOT NULL DEFAULT ''", "`source_id` varchar(128) NOT NULL DEFAULT ''",
+				"`tpl` text", "`type` varchar(32) NOT NULL DEFAULT ''", "`transfer_line` text", "`flow` text", "`policy` text"}},
+		}
+		var w strings.Builder
+		for _, table := range tables {
+			columns := append([]string{"`id` bigint(20) unsigned NOT NULL AUTO_INCREMENT"}, table.columns...)
+			columns = append(columns,
+				fmt.Sprintf("`%s` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3)", c.UpdatedAtColumn),
+				"PRIMARY KEY (`id`)",
+				fmt.Sprintf("KEY `idx_%s` (`%s`)", c.UpdatedAtColumn, c.UpdatedAtColumn),
+			)
+			fmt.Fprintf(&w, "CREATE TABLE IF NOT EXISTS `%s` (\n  %s\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n", table.name, strings.Join(columns, ",\n  "))
+		}
+		return w.String()
+	},
+}
+
+// SQLDBProvider 从数据库表读取配置，按更新时间增量拉取变更，可驱动容器重载
+type SQLDBProvider struct {
+	db           *sql.DB
+	config       SQLDBConfig
+	mu           sync.Mutex // 串行化拉取
+	dictionaries *sqlTable[TransferFuncRecord]
+	apis         *sqlTable[ApiRecord]
+	sources      *sqlTable[SourceRecord]
+	templates    *sqlTable[TemplateRecord]
+}
+
+func NewSQLDBProvider(db *sql.DB, config SQLDBConfig) (provider *SQLDBProvider) {
+	config = config.withDefault()
+	provider = &SQLDBProvider{
+		db:           db,
+		config:       config,
+		dictionaries: newSQLTable[TransferFuncRecord](config.DictionaryTable, config),
+		apis:         newSQLTable[ApiRecord](config.ApiTable, config),
+		sources:      newSQLTable[SourceRecord](config.SourceTable, config),
+		templates:    newSQLTable[TemplateRecord](config.TemplateTable, config),
+	}
+	return provider
+}
+
+// Migrate 创建迁移记录表，执行未执行的迁移，返回迁移后的版本
+func (p *SQLDBProvider) Migrate(ctx context.Context) (version int, err error) {
+	createMigrationTable := fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (\n  `version` int(11) NOT NULL,\n  `applied_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n  PRIMARY KEY (`version`)\n) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;", SQLDB_MIGRATION_TABLE)
+	err = p.exec(ctx, p.db, createMigrationTable)
+	if err != nil {
+		return 0, err
+	}
+	err = p.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(MAX(`version`),0) FROM `%s`", SQLDB_MIGRATION_TABLE)).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	for ; version < len(sqlDBMigrations); version++ {
+		err = p.migrate(ctx, version+1, sqlDBMigrations[version](p.config))
+		if err != nil {
+			err = errors.WithMessagef(err, "migration version:%d", version+1)
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+func (p *SQLDBProvider) migrate(ctx context.Context, version int, ddl string) (err error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // MySQL DDL 会隐式提交，迁移语句均为 if not exists，可重复执行
+	err = p.exec(ctx, tx, ddl)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO `%s` (`version`) VALUES (?)", SQLDB_MIGRATION_TABLE), version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// exec 执行建表语句，SQLite 转换为对应语法
+func (p *SQLDBProvider) exec(ctx context.Context, execer sqlExecer, ddl string) (err error) {
+	stmts := []string{ddl}
+	if p.config.Dialect == SQLDB_DIALECT_SQLITE {
+		stmts = apifunc.MysqlDDLToSQLite(ddl)
+	}
+	for _, stmt := range stmts {
+		_, err = execer.ExecContext(ctx, stmt)
+		if err != nil {
+			err = errors.WithMessagef(err, "sql:%s", stmt)
+			return err
+		}
+	}
+	return nil
+}
+
+// Load 全量读取各表，资源按环境继承链合并后转为模型
+func (p *SQLDBProvider) Load(ctx context.Context, env string) (models apifunc.ModelSet, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	commits := make([]func(), 0, len(p.tables()))
+	for _, table := range p.tables() {
+		commit, err := table.reload(ctx, p.db)
+		if err != nil {
+			return models, err
+		}
+		commits = append(commits, commit)
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	return p.models(env)
+}
+
+// Poll 增量拉取：读取更新时间不早于上次最大更新时间(回溯 PollOverlap)的记录，并比对各表 id 集合发现删除及晚提交的记录；
+// 首次调用等同 Load。所有表拉取成功后才更新缓存，任一表失败时缓存不变，下次拉取仍能发现本次的变化。任一表内容变化时 changed 为 true；
+// 每次拉取会读取各表全部 id(仅主键)，开销与配置表行数成正比，拉取间隔不宜过短
+func (p *SQLDBProvider) Poll(ctx context.Context, env string) (models apifunc.ModelSet, changed bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	commits := make([]func(), 0, len(p.tables()))
+	for _, table := range p.tables() {
+		commit, tableChanged, err := table.poll(ctx, p.db)
+		if err != nil {
+			return models, false, err
+		}
+		commits = append(commits, commit)
+		changed = changed || tableChanged
+	}
+	for _, commit := range commits {
+		commit()
+	}
+	if !changed {
+		return models, false, nil
+	}
+	models, err = p.models(env)
+	if err != nil {
+		return models, false, err
+	}
+	return models, true, nil
+}
+
+// Watch 按间隔拉取变更并重载容器，直到 ctx 结束；拉取、重载失败时调用 onError(可为空)，容器保持原版本
+func (p *SQLDBProvider) Watch(ctx context.Context, env string, interval time.Duration, container *apifunc.Container, onError func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		models, changed, err := p.Poll(ctx, env)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && changed {
+			_, err = container.Reload(models)
+		}
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *SQLDBProvider) tables() (tables []sqlTableI) {
+	return []sqlTableI{p.dictionaries, p.apis, p.sources, p.templates}
+}
+
+func (p *SQLDBProvider) models(env string) (models apifunc.ModelSet, err error) {
+	return toModelSet(env, p.dictionaries.list(), p.apis.list(), p.sources.list(), p.templates.list())
+}
+
+// sqlTableI 读取结果通过 commit 写入缓存，多表读取全部成功后再统一提交
+type sqlTableI interface {
+	reload(ctx context.Context, db *sql.DB) (commit func(), err error)
+	poll(ctx context.Context, db *sql.DB) (commit func(), changed bool, err error)
+}
+
+// sqlTable 单表缓存，key 为 id，watermark 为已读取记录的最大更新时间(数据库返回的原始文本)
+type sqlTable[T any] struct {
+	name            string
+	updatedAtColumn string
+	overlap         time.Duration
+	columns         []string // 记录字段对应的列，与xml字段名一致
+	records         map[int64]T
+	watermark       string
+	loaded          bool
+}
+
+func newSQLTable[T any](name string, config SQLDBConfig) (table *sqlTable[T]) {
+	table = &sqlTable[T]{name: name, updatedAtColumn: config.UpdatedAtColumn, overlap: config.PollOverlap, records: make(map[int64]T)}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		column, _, _ := strings.Cut(typ.Field(i).Tag.Get("xml"), ",")
+		table.columns = append(table.columns, column)
+	}
+	return table
+}
+
+func (t *sqlTable[T]) query(ctx context.Context, db *sql.DB, since string) (records map[int64]T, watermark string, err error) {
+	selects := make([]string, 0, len(t.columns)+2)
+	selects = append(selects, "`id`")
+	for _, column := range t.columns {
+		selects = append(selects, fmt.Sprintf("COALESCE(`%s`,'')", column))
+	}
+	selects = append(selects, fmt.Sprintf("`%s`", t.updatedAtColumn))
+	query := fmt.Sprintf("SELECT %s FROM `%s`", strings.Join(selects, ","), t.name)
+	args := make([]any, 0)
+	if since != "" {
+		query += fmt.Sprintf(" WHERE `%s` >= ?", t.updatedAtColumn) // 包含边界，同一时间点后写入的记录不会遗漏
+		args = append(args, since)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		err = errors.WithMessagef(err, "table:%s", t.name)
+		return nil, "", err
+	}
+	defer rows.Close()
+	records = make(map[int64]T)
+	watermark = since
+	for rows.Next() {
+		var id int64
+		var record T
+		var updatedAt any
+		value := reflect.ValueOf(&record).Elem()
+		dest := make([]any, 0, len(t.columns)+2)
+		dest = append(dest, &id)
+		for i := range t.columns {
+			dest = append(dest, value.Field(i).Addr().Interface())
+		}
+		dest = append(dest, &updatedAt)
+		err = rows.Scan(dest...)
+		if err != nil {
+			err = errors.WithMessagef(err, "table:%s", t.name)
+			return nil, "", err
+		}
+		records[id] = record
+		if s := formatUpdatedAt(updatedAt); s > watermark {
+			watermark = s
+		}
+	}
+	if err = rows.Err(); err != nil {
+		err = errors.WithMessagef(err, "table:%s", t.name)
+		return nil, "", err
+	}
+	return records, watermark, nil
+}
+
+// formatUpdatedAt 更新时间转为文本，与数据库中的格式一致以便回传比较(MySQL 按时间解析，SQLite 按文本比较)
+func formatUpdatedAt(updatedAt any) (s string) {
+	switch v := updatedAt.(type) {
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(updatedAt)
+}
+
+func (t *sqlTable[T]) reload(ctx context.Context, db *sql.DB) (commit func(), err error) {
+	records, watermark, err := t.query(ctx, db, "")
+	if err != nil {
+		return nil, err
+	}
+	commit = func() {
+		t.records, t.watermark, t.loaded = records, watermark, true
+	}
+	return commit, nil
+}
+
+// poll 按回溯窗口增量拉取变更，再比对 id 集合：缓存中多出的 id 已被删除，数据库中多出的 id 为更新时间早于窗口的新记录，全量重新加载；
+// 变更写入缓存的副本，commit 时替换
+func (t *sqlTable[T]) poll(ctx context.Context, db *sql.DB) (commit func(), changed bool, err error) {
+	if !t.loaded {
+		commit, err = t.reload(ctx, db)
+		return commit, true, err
+	}
+	records, watermark, err := t.query(ctx, db, overlapSince(t.watermark, t.overlap))
+	if err != nil {
+		return nil, false, err
+	}
+	ids, err := t.ids(ctx, db)
+	if err != nil {
+		return nil, false, err
+	}
+	for id := range ids {
+		if _, ok := t.records[id]; !ok {
+			if _, ok = records[id]; !ok {
+				commit, err = t.reload(ctx, db)
+				return commit, true, err
+			}
+		}
+	}
+	staged := make(map[int64]T, len(ids))
+	for id := range ids {
+		record, ok := records[id]
+		if !ok {
+			record = t.records[id]
+		} else if old, ok := t.records[id]; !ok || !reflect.DeepEqual(old, record) {
+			changed = true
+		}
+		staged[id] = record
+	}
+	if len(staged) != len(t.records) {
+		changed = true // 有删除
+	}
+	if watermark < t.watermark {
+		watermark = t.watermark
+	}
+	commit = func() {
+		t.records, t.watermark = staged, watermark
+	}
+	return commit, changed, nil
+}
+
+// ids 读取表中全部 id(仅主键列)，用于发现删除及增量窗口外的新记录
+func (t *sqlTable[T]) ids(ctx context.Context, db *sql.DB) (ids map[int64]bool, err error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT `id` FROM `%s`", t.name))
+	if err != nil {
+		err = errors.WithMessagef(err, "table:%s", t.name)
+		return nil, err
+	}
+	defer rows.Close()
+	ids = make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			err = errors.WithMessagef(err, "table:%s", t.name)
+			return nil, err
+		}
+		ids[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		err = errors.WithMessagef(err, "table:%s", t.name)
+		return nil, err
+	}
+	return ids, nil
+}
+
+var updatedAtLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano}
+
+// overlapSince 水位回溯 overlap，按数据库文本格式输出；无法解析时使用原水位
+func overlapSince(watermark string, overlap time.Duration) (since string) {
+	for _, layout := range updatedAtLayouts {
+		t, err := time.ParseInLocation(layout, watermark, time.UTC)
+		if err == nil {
+			return t.Add(-overlap).Format("2006-01-02 15:04:05.999999")
+		}
+	}
+	return watermark
+}
+
+// list 按 id 排序的记录
+func (t *sqlTable[T]) list() (records []T) {
+	ids := make([]int64, 0, len(t.records))
+	for id := range t.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	records = make([]T, 0, len(ids))
+	for _, id := range ids {
+		records = append(records, t.records[id])
+	}
+	return records
+}
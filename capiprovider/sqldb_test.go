@@ -0,0 +1,149 @@
+package capiprovider_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/apifunc/capiprovider"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+func TestSQLDBProvider(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "apifunc.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	provider := capiprovider.NewSQLDBProvider(db, capiprovider.SQLDBConfig{Dialect: capiprovider.SQLDB_DIALECT_SQLITE})
+	version, err := provider.Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	version, err = provider.Migrate(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+
+	exec := func(query string, args ...any) {
+		_, err := db.ExecContext(ctx, query, args...)
+		require.NoError(t, err)
+	}
+	exec("insert into apifunc_source (source_id,env,source_type,config) values (?,?,?,?)", "db", "base", "FIXTURE", `{"fixtures":[{"sql":"select * from t_user where name='a'","result":[{"id":"1","name":"a"}]}]}`)
+	exec("insert into apifunc_source (env,parent_env) values (?,?)", "test", "base")
+	exec("insert into apifunc_template (template_id,source_id,tpl) values (?,?,?)", "GetUser", "db", `{{define "GetUser"}}select * from t_user where name='{{.name}}'{{end}}`)
+	exec("insert into apifunc_api (api_id,title,method,route,dependents,input_schema,output_schema) values (?,?,?,?,?,?,?)",
+		"getUser", "用户详情", "POST", "/api/user", `[{"fullname":"GetUser","type":"torm"}]`,
+		"version=http://json-schema.org/draft-07/schema#,direction=in,id=input\nfullname=name,required",
+		"version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+	)
+
+	models, changed, err := provider.Poll(ctx, "test")
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Len(t, models.ApiModels, 1)
+	require.Len(t, models.SourceModels, 1)
+	require.Equal(t, "test", models.SourceModels[0].ENV)
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	_, err = container.Reload(models)
+	require.NoError(t, err)
+	ctxApiFunc, err := container.GetContextApiFunc("/api/user", "POST")
+	require.NoError(t, err)
+	out, err := apifunc.RunApiFunc(ctxApiFunc, []byte(`{"name":"a"}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"id":"1","name":"a"}]`, string(out))
+
+	_, changed, err = provider.Poll(ctx, "test")
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	t.Run("update", func(t *testing.T) {
+		exec("update apifunc_api set title=?,updated_at=CURRENT_TIMESTAMP where api_id=?", "用户", "getUser") // 与上次拉取同一秒内的更新也能拉取到
+		models, changed, err := provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, "用户", models.ApiModels[0].Title)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		exec("insert into apifunc_template (template_id,source_id,tpl) values (?,?,?)", "ListUser", "db", `{{define "ListUser"}}select * from t_user{{end}}`)
+		models, changed, err := provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Len(t, models.TormModels, 2)
+		exec("delete from apifunc_template where template_id=?", "ListUser")
+		models, changed, err = provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Len(t, models.TormModels, 1)
+	})
+
+	t.Run("delete and insert", func(t *testing.T) { // 记录数不变
+		exec("insert into apifunc_template (template_id,source_id,tpl) values (?,?,?)", "ListUser", "db", `{{define "ListUser"}}select * from t_user{{end}}`)
+		_, _, err := provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		exec("delete from apifunc_template where template_id=?", "ListUser")
+		exec("insert into apifunc_template (template_id,source_id,tpl) values (?,?,?)", "CountUser", "db", `{{define "CountUser"}}select count(*) from t_user{{end}}`)
+		models, changed, err := provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Len(t, models.TormModels, 2)
+		require.Equal(t, "CountUser", models.TormModels[1].TemplateID)
+	})
+
+	t.Run("late commit", func(t *testing.T) { // 事务提交晚于更新时间，水位已越过该记录的更新时间
+		exec("update apifunc_template set title=?,updated_at=datetime(CURRENT_TIMESTAMP,'-2 seconds') where template_id=?", "统计", "CountUser")
+		models, changed, err := provider.Poll(ctx, "test") // 回溯窗口内
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, "统计", models.TormModels[1].Title)
+
+		exec("insert into apifunc_template (template_id,source_id,tpl,updated_at) values (?,?,?,?)", "OldUser", "db", `{{define "OldUser"}}select * from t_user{{end}}`, "2000-01-01 00:00:00")
+		models, changed, err = provider.Poll(ctx, "test") // 回溯窗口外，按 id 集合发现
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Len(t, models.TormModels, 3)
+		titles := map[string]string{}
+		for _, m := range models.TormModels {
+			titles[m.TemplateID] = m.Title
+		}
+		require.Equal(t, map[string]string{"GetUser": "", "CountUser": "统计", "OldUser": ""}, titles)
+		_, changed, err = provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		require.False(t, changed)
+		exec("delete from apifunc_template where template_id=?", "OldUser")
+	})
+
+	t.Run("partial failure", func(t *testing.T) { // 后拉取的表失败时，先拉取成功的表不更新缓存
+		_, _, err := provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		exec("update apifunc_api set title=?,updated_at=CURRENT_TIMESTAMP where api_id=?", "详情", "getUser")
+		exec("alter table apifunc_template rename to apifunc_template_bak")
+		_, _, err = provider.Poll(ctx, "test")
+		require.Error(t, err)
+		exec("alter table apifunc_template_bak rename to apifunc_template")
+		models, changed, err := provider.Poll(ctx, "test")
+		require.NoError(t, err)
+		require.True(t, changed)
+		require.Equal(t, "详情", models.ApiModels[0].Title)
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			provider.Watch(watchCtx, "test", 10*time.Millisecond, container, func(err error) { t.Error(err) })
+		}()
+		exec("update apifunc_api set route=?,updated_at=CURRENT_TIMESTAMP where api_id=?", "/api/user/detail", "getUser")
+		require.Eventually(t, func() bool {
+			_, err := container.GetContextApiFunc("/api/user/detail", "POST")
+			return err == nil
+		}, 2*time.Second, 10*time.Millisecond)
+		cancel()
+		<-done
+	})
+}
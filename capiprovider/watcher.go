@@ -0,0 +1,200 @@
+package capiprovider
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/apifunc"
+)
+
+const (
+	WATCHER_TABLE_DICTIONARY = "dictionary"
+	WATCHER_TABLE_API        = "api"
+	WATCHER_TABLE_SOURCE     = "source"
+	WATCHER_TABLE_TEMPLATE   = "template"
+)
+
+// Watcher 监听 LoadXmlDB 使用的四个目录(按修改时间、大小轮询，不依赖系统文件通知)，
+// 变更停止 Debounce 时长后只重新解析变更的表，与其它表的缓存合并后交给容器 Reload(编译失败时容器保持原版本)
+type Watcher struct {
+	Format   string        // 文件格式，默认 xml
+	Interval time.Duration // 扫描间隔，默认 500ms
+	Debounce time.Duration // 最后一次变更后等待的时长，默认 300ms，避免编辑器多次写入触发多次重载
+	OnReload func(version int64, tables []string)
+	OnError  func(err error) // 解析错误为 *RecordError，包含文件及记录序号
+
+	tables []*watchTable
+	models func() (models apifunc.ModelSet, err error) // 由各表最近一次解析结果生成模型
+}
+
+// watchTable 单张表的目录、文件状态及解析结果
+type watchTable struct {
+	name   string
+	dir    string
+	stamps map[string]fileStamp
+	parse  func(format string, dir string) (err error)
+	err    error // 最近一次解析错误，修正前不重载
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+type watchRecords struct {
+	transferFuncRecords TransferFuncRecords
+	apiRecords          ApiRecords
+	sourceRecords       SourceRecords
+	templateRecords     TemplateRecords
+}
+
+// NewXmlDBWatcher 参数与 LoadXmlDB 一致
+func NewXmlDBWatcher(env string, dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) (watcher *Watcher) {
+	watcher = &Watcher{
+		Format:   FORMAT_XML,
+		Interval: 500 * time.Millisecond,
+		Debounce: 300 * time.Millisecond,
+	}
+	records := &watchRecords{}
+	watcher.tables = []*watchTable{
+		{name: WATCHER_TABLE_DICTIONARY, dir: dictFileDir, parse: func(format string, dir string) (err error) {
+			records.transferFuncRecords, err = loadTable[TransferFuncRecord](format, dir)
+			return err
+		}},
+		{name: WATCHER_TABLE_API, dir: apiFileDir, parse: func(format string, dir string) (err error) {
+			records.apiRecords, err = loadTable[ApiRecord](format, dir)
+			return err
+		}},
+		{name: WATCHER_TABLE_SOURCE, dir: sourceFileDir, parse: func(format string, dir string) (err error) {
+			records.sourceRecords, err = loadTable[SourceRecord](format, dir)
+			return err
+		}},
+		{name: WATCHER_TABLE_TEMPLATE, dir: tormFileDir, parse: func(format string, dir string) (err error) {
+			records.templateRecords, err = loadTable[TemplateRecord](format, dir)
+			return err
+		}},
+	}
+	watcher.models = func() (models apifunc.ModelSet, err error) {
+		return toModelSet(env, records.transferFuncRecords, records.apiRecords, records.sourceRecords, records.templateRecords)
+	}
+	return watcher
+}
+
+// Run 解析全部表作为基准(不重载，容器应已使用相同配置完成首次加载)，之后监听变更直到 ctx 结束；
+// 基准解析失败时返回错误，之后的错误通过 OnError 报告
+func (w *Watcher) Run(ctx context.Context, container *apifunc.Container) (err error) {
+	for _, table := range w.tables {
+		table.stamps, err = w.stamp(table)
+		if err != nil {
+			return err
+		}
+		err = table.parse(w.Format, table.dir)
+		if err != nil {
+			return err
+		}
+	}
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	pending := make(map[*watchTable]bool)
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		for _, table := range w.tables {
+			stamps, err := w.stamp(table)
+			if err != nil {
+				w.report(err)
+				continue
+			}
+			if !equalStamps(stamps, table.stamps) {
+				table.stamps = stamps
+				pending[table] = true
+				lastChange = time.Now()
+			}
+		}
+		if len(pending) == 0 || time.Since(lastChange) < w.Debounce {
+			continue
+		}
+		w.apply(container, pending)
+		pending = make(map[*watchTable]bool)
+	}
+}
+
+// apply 重新解析变更的表，所有表解析成功后重载容器
+func (w *Watcher) apply(container *apifunc.Container, pending map[*watchTable]bool) {
+	names := make([]string, 0, len(pending))
+	for _, table := range w.tables {
+		if !pending[table] {
+			continue
+		}
+		names = append(names, table.name)
+		table.err = table.parse(w.Format, table.dir)
+		if table.err != nil {
+			w.report(table.err)
+		}
+	}
+	for _, table := range w.tables {
+		if table.err != nil { // 其它表变更时，未修正的表仍阻止重载
+			if !pending[table] {
+				w.report(errors.WithMessagef(table.err, "table:%s not reloaded", table.name))
+			}
+			return
+		}
+	}
+	models, err := w.models()
+	if err != nil {
+		w.report(err)
+		return
+	}
+	version, err := container.Reload(models)
+	if err != nil {
+		w.report(errors.WithMessagef(err, "reload tables:%v", names))
+		return
+	}
+	if w.OnReload != nil {
+		w.OnReload(version, names)
+	}
+}
+
+func (w *Watcher) report(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+// stamp 表目录下匹配格式的文件状态，文件增删、修改均会改变结果
+func (w *Watcher) stamp(table *watchTable) (stamps map[string]fileStamp, err error) {
+	filenames, err := globFiles(w.Format, table.dir)
+	if err != nil {
+		return nil, err
+	}
+	stamps = make(map[string]fileStamp, len(filenames))
+	for _, filename := range filenames {
+		info, err := os.Stat(filename)
+		if err != nil {
+			if os.IsNotExist(err) { // 扫描期间被删除
+				continue
+			}
+			return nil, err
+		}
+		stamps[filename] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+func equalStamps(a map[string]fileStamp, b map[string]fileStamp) (ok bool) {
+	if len(a) != len(b) {
+		return false
+	}
+	for filename, stamp := range a {
+		if other, ok := b[filename]; !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
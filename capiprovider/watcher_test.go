@@ -0,0 +1,120 @@
+package capiprovider_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/apifunc/capiprovider"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+const watcherApiXml = `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<api_id>getUser</api_id>
+<title>用户详情</title>
+<method>POST</method>
+<route>%s</route>
+<dependents>[{"fullname":"GetUser","type":"torm"}]</dependents>
+<input_schema>version=http://json-schema.org/draft-07/schema#,direction=in,id=input
+fullname=name,required</input_schema>
+<output_schema>version=http://json-schema.org/draft-07/schema#,direction=out,id=out</output_schema>
+</RECORD>
+%s
+</RECORDS>`
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(name string, content string) {
+		filename := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+	}
+	writeApi := func(route string, extra string) {
+		writeFile("api/api.xml", strings.Replace(strings.Replace(watcherApiXml, "%s", route, 1), "%s", extra, 1))
+	}
+	writeFile("dictionary/transferfunc.xml", `<?xml version="1.0" standalone="yes"?><RECORDS></RECORDS>`)
+	writeFile("source/source_test.xml", `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<source_id>db</source_id>
+<env>test</env>
+<source_type>FIXTURE</source_type>
+<config>{"fixtures":[{"sql":"select * from t_user where name='a'","result":[{"id":"1","name":"a"}]}]}</config>
+</RECORD>
+</RECORDS>`)
+	writeFile("template/template.xml", `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<template_id>GetUser</template_id>
+<source_id>db</source_id>
+<tpl>{{define "GetUser"}}select * from t_user where name='{{.name}}'{{end}}</tpl>
+</RECORD>
+</RECORDS>`)
+	writeApi("/api/user", "")
+
+	dictFileDir, apiFileDir, sourceFileDir, tormFileDir := root+"/dictionary", root+"/api", root+"/source", root+"/template"
+	transferFuncModels, apiModels, sourceModels, tormModels, err := capiprovider.LoadXmlDB("test", dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+	require.NoError(t, err)
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	version, err := container.Reload(apifunc.ModelSet{TransferFuncModels: transferFuncModels, ApiModels: apiModels, SourceModels: sourceModels, TormModels: tormModels})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var reloaded []string
+	var errs []error
+	watcher := capiprovider.NewXmlDBWatcher("test", dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
+	watcher.Interval = 10 * time.Millisecond
+	watcher.Debounce = 30 * time.Millisecond
+	watcher.OnReload = func(version int64, tables []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reloaded = append(reloaded, tables...)
+	}
+	watcher.OnError = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- watcher.Run(ctx, container) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+	time.Sleep(50 * time.Millisecond) // 等待基准解析完成
+
+	writeApi("/api/user/detail", "")
+	require.Eventually(t, func() bool {
+		_, err := container.GetContextApiFunc("/api/user/detail", "POST")
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	require.Equal(t, []string{capiprovider.WATCHER_TABLE_API}, reloaded)
+	mu.Unlock()
+	version = container.Version()
+
+	writeApi("/api/user/broken", "<RECORD><api_id>broken</api_id><route><</route></RECORD>")
+	var recordErr *capiprovider.RecordError
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	mu.Lock()
+	require.ErrorAs(t, errs[0], &recordErr)
+	mu.Unlock()
+	require.Equal(t, filepath.Join(apiFileDir, "api.xml"), recordErr.File)
+	require.Equal(t, 1, recordErr.Index, recordErr.Error())
+	require.Equal(t, version, container.Version())
+	_, err = container.GetContextApiFunc("/api/user/detail", "POST")
+	require.NoError(t, err)
+}
@@ -1,54 +1,32 @@
 package capiprovider
 
 import (
-	"bytes"
-	"encoding/xml"
-	"os"
-	"path/filepath"
 	"strings"
 
-	"github.com/pkg/errors"
 	"github.com/suifengpiao14/apifunc"
-	"github.com/suifengpiao14/glob"
 	"github.com/suifengpiao14/pathtransfer"
 )
 
-type xmlDictionaryTable struct {
-	XMLName xml.Name             `xml:"RECORDS"`
-	Records []TransferFuncRecord `xml:"RECORD"`
-}
-type xmlApiTable struct {
-	XMLName xml.Name    `xml:"RECORDS"`
-	Records []ApiRecord `xml:"RECORD"`
-}
-type xmlSourceTable struct {
-	XMLName xml.Name       `xml:"RECORDS"`
-	Records []SourceRecord `xml:"RECORD"`
-}
-type xmlTemplateTable struct {
-	XMLName xml.Name         `xml:"RECORDS"`
-	Records []TemplateRecord `xml:"RECORD"`
-}
-
 type TransferFuncRecord struct {
-	Language     string `xml:"language"`
-	Script       string `xml:"script"`
-	TransferLine string `xml:"transfer_line"`
+	Language     string `xml:"language" json:"language,omitempty" yaml:"language,omitempty"`
+	Script       string `xml:"script" json:"script,omitempty" yaml:"script,omitempty"`
+	TransferLine string `xml:"transfer_line" json:"transfer_line,omitempty" yaml:"transfer_line,omitempty"`
 }
 
 type TransferFuncRecords []TransferFuncRecord
 
 type ApiRecord struct {
-	ApiID        string `xml:"api_id"`
-	Title        string `xml:"title"`
-	Method       string `xml:"method"`
-	Route        string `xml:"route"`
-	Script       string `xml:"script"`
-	Dependents   string `xml:"dependents"`
-	InputSchema  string `xml:"input_schema"`
-	OutputSchema string `xml:"output_schema"`
-	TransferLine string `xml:"transfer_line"`
-	Flow         string `xml:"flow"`
+	ApiID        string `xml:"api_id" json:"api_id,omitempty" yaml:"api_id,omitempty"`
+	Title        string `xml:"title" json:"title,omitempty" yaml:"title,omitempty"`
+	Method       string `xml:"method" json:"method,omitempty" yaml:"method,omitempty"`
+	Route        string `xml:"route" json:"route,omitempty" yaml:"route,omitempty"`
+	Script       string `xml:"script" json:"script,omitempty" yaml:"script,omitempty"`
+	Dependents   string `xml:"dependents" json:"dependents,omitempty" yaml:"dependents,omitempty"`
+	InputSchema  string `xml:"input_schema" json:"input_schema,omitempty" yaml:"input_schema,omitempty"`
+	OutputSchema string `xml:"output_schema" json:"output_schema,omitempty" yaml:"output_schema,omitempty"`
+	TransferLine string `xml:"transfer_line" json:"transfer_line,omitempty" yaml:"transfer_line,omitempty"`
+	Flow         string `xml:"flow" json:"flow,omitempty" yaml:"flow,omitempty"`
+	Policy       string `xml:"policy" json:"policy,omitempty" yaml:"policy,omitempty"`
 }
 type ApiRecords []ApiRecord
 
@@ -62,12 +40,13 @@ func (as ApiRecords) GetByRoute(route string, method string) (out ApiRecord, ok
 }
 
 type SourceRecord struct {
-	SourceID   string `xml:"source_id"`
-	ENV        string `xml:"env"`
-	SourceType string `xml:"source_type"`
-	Config     string `xml:"config"`
-	SSHConfig  string `xml:"ssh_config"`
-	DDL        string `xml:"ddl"` //SQL 类型，需要使用cudevent 库时需要配置DDL
+	SourceID   string `xml:"source_id" json:"source_id,omitempty" yaml:"source_id,omitempty"`
+	ENV        string `xml:"env" json:"env,omitempty" yaml:"env,omitempty"`
+	SourceType string `xml:"source_type" json:"source_type,omitempty" yaml:"source_type,omitempty"`
+	Config     string `xml:"config" json:"config,omitempty" yaml:"config,omitempty"`
+	SSHConfig  string `xml:"ssh_config" json:"ssh_config,omitempty" yaml:"ssh_config,omitempty"`
+	DDL        string `xml:"ddl" json:"ddl,omitempty" yaml:"ddl,omitempty"` //SQL 类型，需要使用cudevent 库时需要配置DDL
+	ParentEnv  string `xml:"parent_env" json:"parent_env,omitempty" yaml:"parent_env,omitempty"` // 所属环境继承的父环境，同一环境的记录需一致；source_id 为空的记录仅声明环境继承关系
 }
 
 type SourceRecords []SourceRecord
@@ -83,14 +62,15 @@ func (ss SourceRecords) FilterByEnv(env string) (out SourceRecords) {
 }
 
 type TemplateRecord struct {
-	TemplateID    string `xml:"template_id"`
-	SubTemplateID string `xml:"sub_template_id"`
-	Title         string `xml:"title"`
-	SourceID      string `xml:"source_id"`
-	Tpl           string `xml:"tpl"`
-	Type          string `xml:"type"`
-	TransferLine  string `xml:"transfer_line"`
-	Flow          string `xml:"flow"`
+	TemplateID    string `xml:"template_id" json:"template_id,omitempty" yaml:"template_id,omitempty"`
+	SubTemplateID string `xml:"sub_template_id" json:"sub_template_id,omitempty" yaml:"sub_template_id,omitempty"`
+	Title         string `xml:"title" json:"title,omitempty" yaml:"title,omitempty"`
+	SourceID      string `xml:"source_id" json:"source_id,omitempty" yaml:"source_id,omitempty"`
+	Tpl           string `xml:"tpl" json:"tpl,omitempty" yaml:"tpl,omitempty"`
+	Type          string `xml:"type" json:"type,omitempty" yaml:"type,omitempty"`
+	TransferLine  string `xml:"transfer_line" json:"transfer_line,omitempty" yaml:"transfer_line,omitempty"`
+	Flow          string `xml:"flow" json:"flow,omitempty" yaml:"flow,omitempty"`
+	Policy        string `xml:"policy" json:"policy,omitempty" yaml:"policy,omitempty"`
 }
 
 type TemplateRecords []TemplateRecord
@@ -107,96 +87,9 @@ func (ts TemplateRecords) GetAllByIds(ids ...string) (out TemplateRecords) {
 	return out
 }
 
-func loadDataFromFile(rootDir string, patten string) (out [][]byte, err error) {
-	patten = filepath.Join(rootDir, patten)
-	allFileList, err := glob.GlobDirectory(patten)
-	if err != nil {
-		err = errors.WithStack(err)
-		return nil, err
-	}
-	out = make([][]byte, 0)
-	for _, filename := range allFileList {
-		b, err := os.ReadFile(filename)
-		if err != nil {
-			return nil, err
-		}
-		out = append(out, b)
-	}
-	return out, nil
-}
-
 //LoadXmlDB 从XML中加载数据
 func LoadXmlDB(env string, dictFileDir string, apiFileDir string, sourceFileDir string, tormFileDir string) (transferFuncModels apifunc.TransferFuncModels, apiModels apifunc.ApiModels, sourceModels apifunc.SourceModels, tormModels apifunc.TormModels, err error) {
-	transferFuncRecords, apiRecords, sourceRecords, templateRecords := make(TransferFuncRecords, 0), make(ApiRecords, 0), make(SourceRecords, 0), make(TemplateRecords, 0)
-
-	dictRecordAllFile, err := loadDataFromFile(dictFileDir, "**/*.xml")
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	for _, dicReordOneFile := range dictRecordAllFile {
-		reader := bytes.NewReader(dicReordOneFile)
-		decodeXML := xml.NewDecoder(reader)
-		decodeXML.Strict = false
-		table := xmlDictionaryTable{}
-		err = decodeXML.Decode(&table)
-		if err != nil {
-			return nil, nil, nil, nil, err
-		}
-		transferFuncRecords = append(transferFuncRecords, table.Records...)
-	}
-
-	apiRecordAllFile, err := loadDataFromFile(apiFileDir, "**/*.xml")
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	for _, apiReordOneFile := range apiRecordAllFile {
-		reader := bytes.NewReader(apiReordOneFile)
-		decodeXML := xml.NewDecoder(reader)
-		decodeXML.Strict = false
-		table := xmlApiTable{}
-		err = decodeXML.Decode(&table)
-		if err != nil {
-			return nil, nil, nil, nil, err
-		}
-		apiRecords = append(apiRecords, table.Records...)
-
-	}
-
-	sourceAllFile, err := loadDataFromFile(sourceFileDir, "**/*.xml")
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	for _, sourceOneFile := range sourceAllFile {
-		reader := bytes.NewReader(sourceOneFile)
-		decodeXML := xml.NewDecoder(reader)
-		decodeXML.Strict = false
-		table := xmlSourceTable{}
-		err = decodeXML.Decode(&table)
-		if err != nil {
-			return nil, nil, nil, nil, err
-		}
-		sourceRecords = append(sourceRecords, table.Records...)
-	}
-
-	sourceRecords = sourceRecords.FilterByEnv(env)
-	templateAllFile, err := loadDataFromFile(tormFileDir, "**/*.xml")
-	if err != nil {
-		return nil, nil, nil, nil, err
-	}
-	for _, templateOneFile := range templateAllFile {
-		reader := bytes.NewReader(templateOneFile)
-		decodeXML := xml.NewDecoder(reader)
-		decodeXML.Strict = false
-		table := xmlTemplateTable{}
-		err = decodeXML.Decode(&table)
-		if err != nil {
-			return nil, nil, nil, nil, err
-		}
-		templateRecords = append(templateRecords, table.Records...)
-	}
-
-	transferFuncModels, apiModels, sourceModels, tormModels = convertToModel(transferFuncRecords, apiRecords, sourceRecords, templateRecords)
-	return transferFuncModels, apiModels, sourceModels, tormModels, nil
+	return LoadDB(FORMAT_XML, env, dictFileDir, apiFileDir, sourceFileDir, tormFileDir)
 }
 
 func convertToModel(transferFuncRecords TransferFuncRecords, dbApiRecords ApiRecords, dbSourceRecords SourceRecords, dbTemplateRecords TemplateRecords) (transferFuncModels apifunc.TransferFuncModels, apiModels apifunc.ApiModels, sourceModels apifunc.SourceModels, tormModels apifunc.TormModels) {
@@ -224,6 +117,7 @@ func convertToModel(transferFuncRecords TransferFuncRecords, dbApiRecords ApiRec
 			OutputSchema:     apiRecord.OutputSchema,
 			PathTransferLine: pathtransfer.TransferLine(apiRecord.TransferLine),
 			Flow:             apiRecord.Flow,
+			Policy:           apifunc.PolicyJson(apiRecord.Policy),
 		}
 		apiModels = append(apiModels, apiModel)
 	}
@@ -247,6 +141,7 @@ func convertToModel(transferFuncRecords TransferFuncRecords, dbApiRecords ApiRec
 			Type:         templateRecord.Type,
 			TransferLine: pathtransfer.TransferLine(templateRecord.TransferLine),
 			Flow:         templateRecord.Flow,
+			Policy:       apifunc.PolicyJson(templateRecord.Policy),
 		}
 		tormModels = append(tormModels, tormModel)
 	}
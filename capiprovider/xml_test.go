@@ -5,7 +5,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
 	"github.com/suifengpiao14/apifunc/capiprovider"
+	"github.com/suifengpiao14/apifunc/sqlitesource"
+	"github.com/suifengpiao14/logchan/v2"
 )
 
 func TestLoadFromXmlDB(t *testing.T) {
@@ -23,3 +26,24 @@ func TestLoadFromXmlDB(t *testing.T) {
 	require.NoError(t, err)
 	fmt.Println(sourceModels)
 }
+
+func TestLoadFromXmlDBLocal(t *testing.T) {
+	transferFuncModels, apiModels, sourceModels, tormModels, err := capiprovider.LoadXmlDB("local", `./example/xmldb/dictionary`, `./example/xmldb/api`, `./example/xmldb/source`, `./example/xmldb/template`)
+	require.NoError(t, err)
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	_, err = container.Reload(apifunc.ModelSet{
+		TransferFuncModels: transferFuncModels,
+		ApiModels:          apiModels,
+		SourceModels:       sourceModels,
+		TormModels:         tormModels,
+	})
+	require.NoError(t, err)
+	source, err := container.GetSource("xyxz_manage_db")
+	require.NoError(t, err)
+	provider, ok := source.Provider.(*sqlitesource.SQLiteProvider)
+	require.True(t, ok)
+	var remark string
+	err = provider.GetDB().QueryRow("select Fxy_remark from t_xyxz_xy_cancel_remark_map where Fhsb_remark='remark'").Scan(&remark)
+	require.NoError(t, err)
+	require.Equal(t, "闲鱼备注", remark)
+}
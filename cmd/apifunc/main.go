@@ -0,0 +1,388 @@
+// apifunc 命令行工具：加载 xmldb 目录(xml、yaml 或 json 格式)，校验、列出、执行api、启动http服务或转换格式
+//
+//	apifunc validate -dir ./xmldb -env dev
+//	apifunc list     -dir ./xmldb -env dev
+//	echo '{"id":1}' | apifunc run -dir ./xmldb -env dev -route /api/user -method POST
+//	apifunc serve    -dir ./xmldb -env dev -addr :8080 -openapi /openapi.json -watch
+//	apifunc convert  -dir ./xmldb -to yaml -out ./yamldb
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/apifunc/capiprovider"
+	_ "github.com/suifengpiao14/apifunc/sqlitesource" // 本地开发使用 SQLITE 资源
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+const usage = `usage: apifunc <command> [flags]
+
+commands:
+  validate  load the config directory for an env, report problems and compile
+  list      print apis (route, method, dependents) and sources
+  run       execute one api with json input from stdin
+  serve     start an http server
+  convert   rewrite an xml directory as yaml or json files
+
+run "apifunc <command> -h" for command flags
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run 执行子命令，返回进程退出码，便于测试
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (exitCode int) {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "validate":
+		err = validateCmd(args[1:], stdout, stderr)
+	case "list":
+		err = listCmd(args[1:], stdout, stderr)
+	case "run":
+		err = runCmd(args[1:], stdin, stdout, stderr)
+	case "serve":
+		err = serveCmd(args[1:], stdout, stderr)
+	case "convert":
+		err = convertCmd(args[1:], stdout, stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, err.Error())
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err.Error())
+		return 1
+	}
+	return 0
+}
+
+type usageError struct {
+	error
+}
+
+// xmldbFlags 各子命令共用的加载参数
+type xmldbFlags struct {
+	dir         string
+	format      string
+	env         string
+	dictDir     string
+	apiDir      string
+	sourceDir   string
+	templateDir string
+	secretEnv   string
+	secretFile  string
+}
+
+func (f *xmldbFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dir, "dir", ".", "xmldb root directory, containing dictionary, api, source and template")
+	fs.StringVar(&f.format, "format", capiprovider.FORMAT_XML, "config file format: xml, yaml or json")
+	fs.StringVar(&f.env, "env", "dev", "source env")
+	fs.StringVar(&f.dictDir, "dict-dir", "", "dictionary directory (default <dir>/dictionary)")
+	fs.StringVar(&f.apiDir, "api-dir", "", "api directory (default <dir>/api)")
+	fs.StringVar(&f.sourceDir, "source-dir", "", "source directory (default <dir>/source)")
+	fs.StringVar(&f.templateDir, "template-dir", "", "template directory (default <dir>/template)")
+	fs.StringVar(&f.secretEnv, "secret-env-prefix", "APIFUNC_SECRET_", "env prefix for ${secret:name} placeholders in source config")
+	fs.StringVar(&f.secretFile, "secret-file", "", "encrypted secret file, passphrase read from env "+secretPassphraseEnv)
+}
+
+const secretPassphraseEnv = "APIFUNC_SECRET_PASSPHRASE"
+
+// secretResolver 先查加密文件，再查环境变量
+func (f *xmldbFlags) secretResolver() (resolver apifunc.SecretResolverI, err error) {
+	resolvers := make(apifunc.SecretResolvers, 0, 2)
+	if f.secretFile != "" {
+		fileResolver, err := apifunc.NewFileSecretResolver(f.secretFile, []byte(os.Getenv(secretPassphraseEnv)))
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, fileResolver)
+	}
+	resolvers = append(resolvers, apifunc.NewEnvSecretResolver(f.secretEnv))
+	return resolvers, nil
+}
+
+func (f *xmldbFlags) subDir(dir string, name string) string {
+	if dir != "" {
+		return dir
+	}
+	return filepath.Join(f.dir, name)
+}
+
+func (f *xmldbFlags) load() (models apifunc.ModelSet, err error) {
+	transferFuncModels, apiModels, sourceModels, tormModels, err := capiprovider.LoadDB(
+		f.format,
+		f.env,
+		f.subDir(f.dictDir, "dictionary"),
+		f.subDir(f.apiDir, "api"),
+		f.subDir(f.sourceDir, "source"),
+		f.subDir(f.templateDir, "template"),
+	)
+	if err != nil {
+		return models, errors.WithMessagef(err, "load %s %s", f.format, f.dir)
+	}
+	models = apifunc.ModelSet{
+		TransferFuncModels: transferFuncModels,
+		ApiModels:          apiModels,
+		SourceModels:       sourceModels,
+		TormModels:         tormModels,
+	}
+	return models, nil
+}
+
+// compile 校验后编译，校验错误时不编译
+func (f *xmldbFlags) compile(stderr io.Writer) (container *apifunc.Container, models apifunc.ModelSet, err error) {
+	models, err = f.load()
+	if err != nil {
+		return nil, models, err
+	}
+	resolver, err := f.secretResolver()
+	if err != nil {
+		return nil, models, err
+	}
+	container = apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {
+		if err != nil {
+			fmt.Fprintln(stderr, "log:", err.Error())
+		}
+	})
+	container.SetSecretResolver(resolver)
+	report := container.Validate(models)
+	if len(report.Issues) > 0 {
+		fmt.Fprintln(stderr, report.String())
+	}
+	if report.HasError() {
+		return nil, models, errors.Errorf("validate failed: %d error(s)", len(report.Errors()))
+	}
+	_, err = container.Reload(models)
+	if err != nil {
+		return nil, models, errors.WithMessage(err, "compile")
+	}
+	return container, models, nil
+}
+
+// watcher 监听配置目录，变更后重新加载，失败时保留当前版本
+func (f *xmldbFlags) watcher(stdout io.Writer, stderr io.Writer) (watcher *capiprovider.Watcher) {
+	watcher = capiprovider.NewXmlDBWatcher(
+		f.env,
+		f.subDir(f.dictDir, "dictionary"),
+		f.subDir(f.apiDir, "api"),
+		f.subDir(f.sourceDir, "source"),
+		f.subDir(f.templateDir, "template"),
+	)
+	watcher.Format = f.format
+	watcher.OnReload = func(version int64, tables []string) {
+		fmt.Fprintf(stdout, "reloaded %s: version %d\n", strings.Join(tables, ","), version)
+	}
+	watcher.OnError = func(err error) {
+		fmt.Fprintln(stderr, "watch:", err.Error())
+	}
+	return watcher
+}
+
+func newFlagSet(name string, stderr io.Writer) (fs *flag.FlagSet) {
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) (err error) {
+	err = fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return usageError{err}
+	}
+	if err == nil && fs.NArg() > 0 {
+		return usageError{errors.Errorf("%s: unexpected arguments %v", fs.Name(), fs.Args())}
+	}
+	return err
+}
+
+func validateCmd(args []string, stdout io.Writer, stderr io.Writer) (err error) {
+	var xf xmldbFlags
+	fs := newFlagSet("validate", stderr)
+	xf.register(fs)
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+	container, models, err := xf.compile(stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "ok: env %s, %d api(s), %d template(s), %d source(s), version %d\n", xf.env, len(models.ApiModels), len(models.TormModels), len(models.SourceModels), container.Version())
+	return nil
+}
+
+func listCmd(args []string, stdout io.Writer, stderr io.Writer) (err error) {
+	var xf xmldbFlags
+	fs := newFlagSet("list", stderr)
+	xf.register(fs)
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+	models, err := xf.load()
+	if err != nil {
+		return err
+	}
+	apiModels := append(apifunc.ApiModels(nil), models.ApiModels...)
+	sort.SliceStable(apiModels, func(i, j int) bool { return apiModels[i].Route < apiModels[j].Route })
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "API\tMETHOD\tROUTE\tTITLE\tDEPENDENTS")
+	for _, apiModel := range apiModels {
+		dependents := make([]string, 0)
+		deps, err := apiModel.Dependents.Dependents()
+		if err != nil {
+			dependents = append(dependents, "invalid: "+err.Error())
+		}
+		for _, dep := range deps {
+			dependents = append(dependents, dep.Fullname)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", apiModel.ApiId, strings.ToUpper(apiModel.Method), apiModel.Route, apiModel.Title, strings.Join(dependents, ","))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "SOURCE\tTYPE\tENV")
+	for _, sourceModel := range models.SourceModels {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", sourceModel.SourceID, sourceModel.SourceType, xf.env)
+	}
+	return w.Flush()
+}
+
+func runCmd(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (err error) {
+	var xf xmldbFlags
+	fs := newFlagSet("run", stderr)
+	xf.register(fs)
+	route := fs.String("route", "", "api route, required")
+	method := fs.String("method", http.MethodPost, "api method")
+	timeout := fs.Duration("timeout", 0, "execution timeout, 0 means no limit")
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *route == "" {
+		return usageError{errors.New("run: -route is required")}
+	}
+	container, _, err := xf.compile(stderr)
+	if err != nil {
+		return err
+	}
+	input, err := io.ReadAll(stdin)
+	if err != nil {
+		return errors.WithMessage(err, "read stdin")
+	}
+	if len(strings.TrimSpace(string(input))) == 0 {
+		input = []byte("{}")
+	}
+	ctxApiFunc, err := container.GetContextApiFunc(*route, *method)
+	if err != nil {
+		return err
+	}
+	if *timeout > 0 {
+		ctx, cancel := context.WithTimeout(ctxApiFunc.Context(), *timeout)
+		defer cancel()
+		ctxApiFunc = ctxApiFunc.WithContext(ctx)
+	}
+	out, err := apifunc.RunApiFunc(ctxApiFunc, input)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(out))
+	return nil
+}
+
+func serveCmd(args []string, stdout io.Writer, stderr io.Writer) (err error) {
+	var xf xmldbFlags
+	fs := newFlagSet("serve", stderr)
+	xf.register(fs)
+	addr := fs.String("addr", ":8080", "listen address")
+	openAPIRoute := fs.String("openapi", "", "serve the OpenAPI document at this route, e.g. /openapi.json")
+	watch := fs.Bool("watch", false, "reload on config file changes")
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+	container, _, err := xf.compile(stderr)
+	if err != nil {
+		return err
+	}
+	defer container.Close()
+	handler := apifunc.NewHttpHandler(container)
+	handler.OpenAPIRoute = *openAPIRoute
+	handler.OpenAPIInfo = apifunc.OpenAPIInfo{Title: "apifunc " + xf.env, Version: fmt.Sprintf("%d", container.Version())}
+	server := &http.Server{Addr: *addr, Handler: handler}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	if *watch {
+		watcher := xf.watcher(stdout, stderr)
+		go func() {
+			if err := watcher.Run(ctx, container); err != nil {
+				errCh <- errors.WithMessage(err, "watch")
+			}
+		}()
+	}
+	fmt.Fprintf(stdout, "listening on %s (env %s)\n", *addr, xf.env)
+	select {
+	case err = <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+func convertCmd(args []string, stdout io.Writer, stderr io.Writer) (err error) {
+	var xf xmldbFlags
+	fs := newFlagSet("convert", stderr)
+	xf.register(fs)
+	to := fs.String("to", capiprovider.FORMAT_YAML, "target format: yaml or json")
+	out := fs.String("out", "", "output root directory, required")
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return usageError{errors.New("convert: -out is required")}
+	}
+	files, err := capiprovider.ConvertXmlDB(
+		*to,
+		*out,
+		xf.subDir(xf.dictDir, "dictionary"),
+		xf.subDir(xf.apiDir, "api"),
+		xf.subDir(xf.sourceDir, "source"),
+		xf.subDir(xf.templateDir, "template"),
+	)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Fprintln(stdout, file)
+	}
+	return nil
+}
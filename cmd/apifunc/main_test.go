@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeXmlDB 生成使用预置结果资源的最小 xmldb 目录
+func writeXmlDB(t *testing.T) (dir string) {
+	dir = t.TempDir()
+	files := map[string]string{
+		"dictionary/transferfunc.xml": `<?xml version="1.0" standalone="yes"?><RECORDS></RECORDS>`,
+		"source/source_test.xml": `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<source_id>db</source_id>
+<env>test</env>
+<source_type>FIXTURE</source_type>
+<config>{"fixtures":[{"sql":"select * from t_user where name='a'","result":[{"id":"1","name":"a"}]}]}</config>
+</RECORD>
+</RECORDS>`,
+		"template/template.xml": `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<template_id>GetUser</template_id>
+<source_id>db</source_id>
+<tpl>{{define "GetUser"}}select * from t_user where name='{{.name}}'{{end}}</tpl>
+</RECORD>
+</RECORDS>`,
+		"api/api.xml": `<?xml version="1.0" standalone="yes"?>
+<RECORDS>
+<RECORD>
+<api_id>getUser</api_id>
+<title>用户详情</title>
+<method>POST</method>
+<route>/api/user</route>
+<dependents>[{"fullname":"GetUser","type":"torm"}]</dependents>
+<input_schema>version=http://json-schema.org/draft-07/schema#,direction=in,id=input
+fullname=name,required</input_schema>
+<output_schema>version=http://json-schema.org/draft-07/schema#,direction=out,id=out</output_schema>
+</RECORD>
+</RECORDS>`,
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(filename), 0o755))
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func runCli(stdin string, args ...string) (exitCode int, stdout string, stderr string) {
+	var out, errOut bytes.Buffer
+	exitCode = run(args, strings.NewReader(stdin), &out, &errOut)
+	return exitCode, out.String(), errOut.String()
+}
+
+func TestCli(t *testing.T) {
+	dir := writeXmlDB(t)
+
+	t.Run("validate", func(t *testing.T) {
+		code, out, stderr := runCli("", "validate", "-dir", dir, "-env", "test")
+		require.Equal(t, 0, code, stderr)
+		require.Contains(t, out, "ok: env test, 1 api(s), 1 template(s), 1 source(s)")
+
+		code, _, stderr = runCli("", "validate", "-dir", dir, "-env", "prod")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "source db not loaded for current env")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		code, out, stderr := runCli("", "list", "-dir", dir, "-env", "test")
+		require.Equal(t, 0, code, stderr)
+		require.Regexp(t, `getUser\s+POST\s+/api/user\s+用户详情\s+GetUser`, out)
+		require.Regexp(t, `db\s+FIXTURE\s+test`, out)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		code, out, stderr := runCli(`{"name":"a"}`, "run", "-dir", dir, "-env", "test", "-route", "/api/user")
+		require.Equal(t, 0, code, stderr)
+		require.JSONEq(t, `[{"id":"1","name":"a"}]`, out)
+
+		code, _, stderr = runCli(`{}`, "run", "-dir", dir, "-env", "test", "-route", "/api/user")
+		require.Equal(t, 1, code)
+		require.Contains(t, stderr, "name")
+	})
+
+	t.Run("convert", func(t *testing.T) {
+		out := t.TempDir()
+		code, stdout, stderr := runCli("", "convert", "-dir", dir, "-to", "yaml", "-out", out)
+		require.Equal(t, 0, code, stderr)
+		require.Contains(t, stdout, filepath.Join(out, "api"))
+		code, stdout, stderr = runCli("", "validate", "-dir", out, "-format", "yaml", "-env", "test")
+		require.Equal(t, 0, code, stderr)
+		require.Contains(t, stdout, "ok: env test, 1 api(s), 1 template(s), 1 source(s)")
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		code, _, stderr := runCli("", "deploy")
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, `unknown command "deploy"`)
+		code, _, stderr = runCli("", "run", "-dir", dir)
+		require.Equal(t, 2, code)
+		require.Contains(t, stderr, "-route is required")
+	})
+}
@@ -1,9 +1,9 @@
 package apifunc
 
 import (
-	"context"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/pkg/errors"
@@ -11,22 +11,37 @@ import (
 	"github.com/suifengpiao14/logchan/v2"
 	"github.com/suifengpiao14/packethandler"
 	"github.com/suifengpiao14/pathtransfer"
-	"github.com/suifengpiao14/stream/packet"
 	"github.com/suifengpiao14/torm"
 )
 
 // 容器，包含所有预备的资源、脚本等
 type Container struct {
-	apis     Apis
-	project  Project
-	torms    torm.Torms
-	compiled sync.Once
+	apis               Apis
+	codeApis           Apis // 代码中注册的api，重载时保留
+	project            Project
+	torms              torm.Torms
+	tormPolicies       map[string]Policy // key 为小写的torm名称
+	breakers           *BreakerGroup
+	cache              *ResponseCache
+	sources            *sourcePool       // 容器创建的资源，重载时复用配置未变更的资源
+	scriptEngines      goscript.ScriptIs // RegisterProject 注册的脚本引擎(原型，不写入代码)，重载时保留
+	secretResolver     SecretResolverI   // 解析资源配置中的密钥占位符
+	pathParamNamespace string
+	current            atomic.Pointer[snapshot] // 当前生效的编译结果
+	history            []*snapshot
+	version            int64
+	mu                 sync.Mutex // 串行化编译、重载、回滚
+	compileErr         error      // 最近一次编译错误，注册信息变更前重复编译直接返回
+	dirty              bool       // 编译后注册信息是否有变更
 }
 
 func NewContainer(logFn func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error)) (container *Container) {
 	container = &Container{
-		apis:  make(Apis, 0),
-		torms: make(torm.Torms, 0),
+		apis:     make(Apis, 0),
+		torms:    make(torm.Torms, 0),
+		breakers: NewBreakerGroup(),
+		cache:    NewResponseCache(),
+		sources:  newSourcePool(),
 	}
 	container.setLogger(logFn) // 外部注入日志处理组件
 	return container
@@ -34,40 +49,46 @@ func NewContainer(logFn func(logInfo logchan.LogInforInterface, typeName logchan
 
 func (c *Container) RegisterAPI(api Api) {
 	c.apis.AddMerge(api)
+	c.codeApis.AddMerge(api)
+	c.markDirty()
 }
 
-// Compile 只会执行一次
+// Compile 编译注册信息并发布，要么完整发布，要么保持原状态；
+// 失败后注册信息未变更时再次调用返回相同错误，修正注册信息后可重新编译。后续整体变更建议使用 Reload
 func (c *Container) Compile() (err error) {
-	c.compiled.Do(func() {
-		//初始化api
-		for i := range c.apis {
-			err = c.apis[i].Init()
-			if err != nil {
-				return
-			}
-		}
-		//初始化torm
-		for i, tor := range c.torms {
-			switch strings.ToUpper(tor.Source.Type) {
-			case torm.SOURCE_TYPE_SQL:
-				c.torms[i].PacketHandlers, err = packet.TormSQLPacketHandler(tor)
-				if err != nil {
-					return
-				}
-			}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		if c.compileErr != nil {
+			return c.compileErr
 		}
-		//初始化project
-		err = c.project.Init()
-		if err != nil {
-			return
+		if c.current.Load() != nil {
+			return nil
 		}
-	})
+	}
+	snap, err := c.build()
+	c.compileErr, c.dirty = err, false
 	if err != nil {
 		return err
 	}
+	c.publish(snap)
 	return nil
 }
 
+// markDirty 注册信息变更，允许重新编译
+func (c *Container) markDirty() {
+	c.dirty = true
+}
+
+// tormPacketHandlers 根据资源类型生成torm处理器，未注册驱动的资源类型使用torm自带处理器
+func tormPacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	driver, err := GetSourceDriver(tor.Source.Type)
+	if err != nil {
+		return tor.PacketHandlers, nil
+	}
+	return driver.PacketHandlers(tor)
+}
+
 func (c *Container) RegisterAPIFlow(method string, route string, flow packethandler.Flow, businessFlowFn BusinessFlowFn, packetHandlers ...packethandler.PacketHandlerI) {
 
 	if len(packetHandlers) == 0 {
@@ -82,36 +103,89 @@ func (c *Container) RegisterAPIFlow(method string, route string, flow packethand
 		PacketHandlers: packetHandlers,
 	}
 	c.apis.AddMerge(api)
+	c.codeApis.AddMerge(api)
+	c.markDirty()
 }
 
 var ERROR_NOT_FOUND_API = errors.New("not found api")
 
-// GetContextApiFunc  根据route，method获取特定api执行上下文
+// SetPathParamNamespace 设置路径参数注入到入参json中的命名空间，默认为空(注入到顶层)
+func (c *Container) SetPathParamNamespace(namespace string) {
+	c.pathParamNamespace = namespace
+}
+
+// GetContextApiFunc  根据route，method获取特定api执行上下文，Compile 后使用路由索引匹配(支持路径参数)
 func (c *Container) GetContextApiFunc(route string, method string) (contextApiFunc *ContextApiFunc, err error) {
-	api, err := c.apis.GetByRoute(route, method)
+	snap := c.current.Load()
+	if snap == nil { // 未编译
+		c.mu.Lock()
+		compileErr := c.compileErr
+		c.mu.Unlock()
+		if compileErr != nil { // 编译失败时不使用未初始化的注册信息提供服务
+			err = errors.WithMessage(compileErr, ERROR_COMPILED_API.Error())
+			return nil, err
+		}
+		snap = &snapshot{apis: c.apis, torms: c.torms, project: c.project, tormPolicies: c.tormPolicies}
+	}
+	var api *Api
+	var pathParams map[string]string
+	if snap.router != nil {
+		api, pathParams, err = snap.router.Match(route, method)
+	} else {
+		api, err = snap.apis.GetByRoute(route, method)
+	}
 	if err != nil {
 		return nil, err
 	}
 	contextApiFunc = &ContextApiFunc{
-		_Api:     *api,
-		_Torms:   c.torms,
-		_Project: c.project,
+		_Api:                *api,
+		_Torms:              snap.torms,
+		_Project:            snap.project,
+		_PathParams:         pathParams,
+		_PathParamNamespace: c.pathParamNamespace,
+		_TormPolicies:       snap.tormPolicies,
+		_Breakers:           c.breakers,
+		_Cache:              c.cache,
 	}
 	return contextApiFunc, nil
 }
 
+// GetSource 获取已注册torm使用的资源，可用于测试中检查资源提供者(如 FixtureProvider 的执行记录)
+func (c *Container) GetSource(sourceId string) (source torm.Source, err error) {
+	torms := c.torms
+	if snap := c.current.Load(); snap != nil {
+		torms = snap.torms
+	}
+	for _, tor := range torms {
+		if strings.EqualFold(tor.Source.Identifer, sourceId) {
+			return tor.Source, nil
+		}
+	}
+	err = errors.Errorf("not found source by sourceId:%s", sourceId)
+	return source, err
+}
+
 // SsetLogger 封装相关性——全局设置 功能
 func (c *Container) setLogger(fn func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error)) {
-	logchan.SetLoggerWriter(fn)
+	logchan.SetLoggerWriter(redactLogFn(fn))
+}
+
+// SetSecretResolver 设置密钥解析器，注册资源时替换 Config、SSHConfig 中的 ${secret:name} 占位符
+func (c *Container) SetSecretResolver(resolver SecretResolverI) {
+	c.secretResolver = resolver
+	c.markDirty()
 }
 
-// RegisterProject 注册项目
+// RegisterProject 注册项目，scriptEngines 为预先配置的脚本引擎(如注册了自定义符号)，未提供的语言编译时自动创建；
+// 编译时使用引擎的副本写入、编译代码，Reload 时沿用注册的引擎
 func (c *Container) RegisterProject(scriptLanguage string, scriptEngines goscript.ScriptIs, transferFuncModels TransferFuncModels) {
 	project := Project{
 		CurrentLanguage: scriptLanguage,
 		FuncTransfers:   make(pathtransfer.Transfers, 0),
 		Scripts:         make(goscript.Scripts, 0),
+		_ScriptEngines:  make(goscript.ScriptIs, 0, len(scriptEngines)),
 	}
+	project._ScriptEngines.AddReplace(scriptEngines...)
 	for _, transferFuncModel := range transferFuncModels {
 		if transferFuncModel.Script != "" {
 			script := goscript.Script{
@@ -123,6 +197,8 @@ func (c *Container) RegisterProject(scriptLanguage string, scriptEngines goscrip
 		project.FuncTransfers.AddReplace(transferFuncModel.TransferLine.Transfer()...)
 	}
 	c.project = project
+	c.scriptEngines = project._ScriptEngines
+	c.markDirty()
 }
 
 // RegisterTorms 注册torm
@@ -130,11 +206,19 @@ func (c *Container) RegisterTormByModels(tormModels TormModels, sourceModels Sou
 	if c.torms == nil {
 		c.torms = make(torm.Torms, 0)
 	}
+	err = tormModels.CheckSources(sourceModels, nil)
+	if err != nil {
+		return err
+	}
+	sourceModels, err = ResolveSourceSecrets(sourceModels, c.secretResolver)
+	if err != nil {
+		return err
+	}
 	sources := make(torm.Sources, 0)
 	for _, sourceModel := range sourceModels {
-		source, err := torm.MakeSource(sourceModel.SourceID, sourceModel.SourceType, sourceModel.Config, sourceModel.SSHConfig, sourceModel.DDL)
+		source, err := c.sources.get(sourceModel)
 		if err != nil {
-			return err
+			return redactError(err) // 错误信息可能包含配置
 		}
 		sources = append(sources, source)
 	}
@@ -142,11 +226,51 @@ func (c *Container) RegisterTormByModels(tormModels TormModels, sourceModels Sou
 	if err != nil {
 		return err
 	}
-	c.torms.AddReplace(torms...)
+	policies, err := tormModels.Policies()
+	if err != nil {
+		return err
+	}
+	tormPolicies := make(map[string]Policy, len(c.tormPolicies)+len(policies)) // 写时复制，已发布的快照可能持有旧map
+	for name, policy := range c.tormPolicies {
+		tormPolicies[name] = policy
+	}
+	for _, tormModel := range tormModels { // 重新注册的torm以本次策略为准
+		delete(tormPolicies, strings.ToLower(tormModel.TemplateID))
+	}
+	for name, policy := range policies {
+		tormPolicies[name] = policy
+	}
+	allTorms := make(torm.Torms, len(c.torms), len(c.torms)+len(torms))
+	copy(allTorms, c.torms)
+	allTorms.AddReplace(torms...)
+	_, err = tormBreakerConfigs(allTorms, tormPolicies) // 熔断器按资源共享，与已注册的torm比较
+	if err != nil {
+		return err
+	}
+	c.torms = allTorms
+	c.tormPolicies = tormPolicies
+	c.markDirty()
 	return nil
 }
 
-// RegisterAPIByModel 通过模型注册路由
+// ResponseCache 容器共享的响应缓存，可在外部增删改事件中调用 InvalidateTables 使缓存失效
+func (c *Container) ResponseCache() (cache *ResponseCache) {
+	return c.cache
+}
+
+// BreakerStates 各资源熔断器状态
+func (c *Container) BreakerStates() (states []BreakerState) {
+	return c.breakers.States()
+}
+
+// Close 关闭容器创建的全部资源(数据库连接池、redis连接等)，服务退出时调用
+func (c *Container) Close() (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sources.close()
+}
+
+// RegisterAPIByModel 通过模型注册路由，与代码注册的api合并，同一api再次注册时替换之前的模型
 func (c *Container) RegisterAPIByModel(responseDefaultJson []byte, apiModels ...ApiModel) {
 	if c.apis == nil {
 		c.apis = make(Apis, 0)
@@ -154,47 +278,63 @@ func (c *Container) RegisterAPIByModel(responseDefaultJson []byte, apiModels ...
 	for _, apiModel := range apiModels {
 		api := apiModel.Api()
 		api.ResponseDefaultJson = string(responseDefaultJson)
-		c.apis.AddMerge(api)
+		c.apis.replaceModel(api, c.codeApis) // 重复注册时替换，修正后的模型可以重新编译
 	}
+	c.markDirty()
 }
 
-// RegisterRouteFn 给router 注册路由
+// RegisterRouteFn 给router 注册路由，多个方法(如 "post,get")拆分后逐个注册
 func (c *Container) RegisterRouteFn(routeFn func(method string, path string)) {
 	for _, api := range c.apis {
-		routeFn(api.Method, api.Route)
+		for _, method := range api.Methods() {
+			routeFn(method, api.Route)
+		}
 	}
 }
 
-// ApiHandlerRunTormFn 内置运行单个Torm业务逻辑函数
+// ApiHandlerRunTormFn 内置运行单个Torm业务逻辑函数，多个torm顺序执行后按顺序合并输出
 func ApiHandlerRunTormFn(tors ...torm.Torm) (logicHandler BusinessFlowFn) {
 	logicHandler = func(ctx *ContextApiFunc, input []byte) (out []byte, err error) {
 		outputArr := make([][]byte, len(tors))
 		for i, tor := range tors {
-			switch strings.ToUpper(tor.Source.Type) {
-			case torm.SOURCE_TYPE_SQL:
-				ctx := context.Background()
-
-				outputArr[i], err = tor.Run(ctx, input)
-				if err != nil {
-					return nil, err
-				}
-			default:
-				err = errors.Errorf("not implement source type:%s", torm.SOURCE_TYPE_SQL)
-				return nil, err
-			}
-		}
-		for _, subOut := range outputArr {
-			out, err = jsonpatch.MergePatch(out, subOut)
+			outputArr[i], err = runTormBySourceType(ctx, tor, input)
 			if err != nil {
 				return nil, err
 			}
 		}
-		return out, nil
-
+		return mergeTormOutputs(outputArr)
 	}
 	return logicHandler
 }
 
+// runTormBySourceType 执行已注册资源驱动的torm
+func runTormBySourceType(ctx *ContextApiFunc, tor torm.Torm, input []byte) (out []byte, err error) {
+	_, err = GetSourceDriver(tor.Source.Type)
+	if err != nil {
+		err = errors.WithMessagef(err, "not implement source type:%s", tor.Source.Type)
+		return nil, err
+	}
+	return ctx.runTorm(tor, input)
+}
+
+// mergeTormOutputs 按torm声明顺序合并输出，后者覆盖前者
+func mergeTormOutputs(outputArr [][]byte) (out []byte, err error) {
+	for _, subOut := range outputArr {
+		if len(subOut) == 0 {
+			continue
+		}
+		if len(out) == 0 {
+			out = subOut
+			continue
+		}
+		out, err = jsonpatch.MergePatch(out, subOut)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
 // ApiHandlerEmptyFn 内置空业务逻辑函数，使用mock数据
 func ApiHandlerEmptyFn() (logicHandler BusinessFlowFn) {
 	logicHandler = func(ctx *ContextApiFunc, input []byte) (out []byte, err error) {
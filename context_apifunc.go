@@ -2,25 +2,42 @@ package apifunc
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/suifengpiao14/pathtransfer"
 	"github.com/suifengpiao14/torm"
+	"github.com/tidwall/sjson"
 )
 
 type ContextApiFunc struct {
-	_Api     Api
-	_Torms   torm.Torms
-	_Project Project
+	parent              context.Context // 调用方上下文，提供取消、超时及值传递
+	_Api                Api
+	_Torms              torm.Torms
+	_Project            Project
+	_PathParams         map[string]string
+	_PathParamNamespace string
+	_TormPolicies       map[string]Policy
+	_Breakers           *BreakerGroup
+	_Cache              *ResponseCache
 }
 
-// ConvertContext2ContextApiFunc 从ctx 中获取 ContextApiFunc上下文
+type contextApiFuncKey struct{}
+
+// ConvertContext2ContextApiFunc 从ctx 中获取 ContextApiFunc上下文(ctx 本身或者由其派生的上下文)
 func ConvertContext2ContextApiFunc(ctx context.Context) (contextApiFunc *ContextApiFunc, err error) {
 
 	contextApiFunc, ok := ctx.(*ContextApiFunc)
 	if ok {
 		return contextApiFunc, nil
 	}
+	if ctx != nil {
+		contextApiFunc, ok = ctx.Value(contextApiFuncKey{}).(*ContextApiFunc)
+		if ok {
+			return contextApiFunc, nil
+		}
+	}
 	err = errors.Errorf("ctx not impliment of ContextApiFunc")
 	return nil, err
 }
@@ -34,25 +51,54 @@ func NewContextApiFunc(api Api, torms torm.Torms, project Project) (contextApiFu
 	return contextApiFunc
 }
 
-func (*ContextApiFunc) Deadline() (deadline time.Time, ok bool) {
-	return
+// WithContext 返回使用新父级上下文的浅拷贝，用于传入请求上下文(取消、超时、trace 等值)
+func (ctxApiFunc *ContextApiFunc) WithContext(ctx context.Context) (newCtxApiFunc *ContextApiFunc) {
+	if ctx == nil {
+		panic("nil context")
+	}
+	newCtxApiFunc = new(ContextApiFunc)
+	*newCtxApiFunc = *ctxApiFunc
+	newCtxApiFunc.parent = ctx
+	return newCtxApiFunc
 }
 
-func (*ContextApiFunc) Done() <-chan struct{} {
-	return nil
+// Context 父级上下文，未设置时为 context.Background()
+func (ctxApiFunc *ContextApiFunc) Context() (ctx context.Context) {
+	if ctxApiFunc.parent == nil {
+		return context.Background()
+	}
+	return ctxApiFunc.parent
 }
 
-func (*ContextApiFunc) Err() error {
-	return nil
+func (ctxApiFunc *ContextApiFunc) Deadline() (deadline time.Time, ok bool) {
+	return ctxApiFunc.Context().Deadline()
 }
 
-func (*ContextApiFunc) Value(key any) any {
-	return nil
+func (ctxApiFunc *ContextApiFunc) Done() <-chan struct{} {
+	return ctxApiFunc.Context().Done()
+}
+
+func (ctxApiFunc *ContextApiFunc) Err() error {
+	return ctxApiFunc.Context().Err()
+}
+
+func (ctxApiFunc *ContextApiFunc) Value(key any) any {
+	if _, ok := key.(contextApiFuncKey); ok {
+		return ctxApiFunc
+	}
+	return ctxApiFunc.Context().Value(key)
 }
 
 // RunApiFunc 执行ApiFunc 之所有不写成  func  (cApiFunc ContextApiFunc)Run(input []byte) (out []byte, err error) 是因为ContextApiFunc 作为数据传入到脚本中，为脚本提供上下文资源，在脚本中不能调用Run方法
 func RunApiFunc(ctxApiFunc *ContextApiFunc, input []byte) (out []byte, err error) {
-	out, err = ctxApiFunc._Api.Run(ctxApiFunc, input)
+	input, err = ctxApiFunc.injectPathParams(input)
+	if err != nil {
+		return nil, err
+	}
+	out, err = ctxApiFunc.runApiWithCache(input, func(ctxApiFunc *ContextApiFunc, input []byte) (out []byte, err error) {
+		return ctxApiFunc._Api.Run(ctxApiFunc, input)
+	})
+	err = redactError(err) // 资源错误可能包含连接配置
 	if err != nil && ctxApiFunc._Api.ErrorHandler != nil {
 		out = ctxApiFunc._Api.ErrorHandler(ctxApiFunc, err)
 		return out, nil
@@ -63,6 +109,19 @@ func RunApiFunc(ctxApiFunc *ContextApiFunc, input []byte) (out []byte, err error
 	return out, nil
 }
 
+// injectPathParams 将路由匹配的路径参数写入入参
+func (ctxApiFunc *ContextApiFunc) injectPathParams(input []byte) (out []byte, err error) {
+	out = input
+	for key, value := range ctxApiFunc._PathParams {
+		path := pathtransfer.JoinPath(ctxApiFunc._PathParamNamespace, key).String()
+		out, err = sjson.SetBytes(out, path, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
 func (ctxApiFunc *ContextApiFunc) RunTransferByFunc(funcname string, input []byte) (out []byte, err error) {
 	project := ctxApiFunc._Project
 	scriptEngine, err := project._ScriptEngines.GetByLanguage(project.CurrentLanguage)
@@ -81,8 +140,38 @@ func (ctxApiFunc *ContextApiFunc) RunTorm(tormName string, input []byte) (out []
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.Background()
-	out, err = tor.Run(ctx, input)
+	return ctxApiFunc.runTorm(*tor, input)
+}
+
+// runTorm 按torm策略执行：超时、只读语句重试、按 SourceID 熔断
+func (ctxApiFunc *ContextApiFunc) runTorm(tor torm.Torm, input []byte) (out []byte, err error) {
+	err = checkTransactionSource(ctxApiFunc, tor)
+	if err != nil {
+		return nil, err
+	}
+	if explain := explainFromContext(ctxApiFunc); explain != nil {
+		return ctxApiFunc.dryRunTorm(tor, input, explain)
+	}
+	policy := ctxApiFunc._TormPolicies[strings.ToLower(tor.TplName)]
+	var breaker *Breaker
+	if policy.Breaker != nil && ctxApiFunc._Breakers != nil {
+		breaker = ctxApiFunc._Breakers.Get(tor.Source.Identifer, *policy.Breaker)
+	}
+	err = policy.Do(ctxApiFunc, isReadTemplate(tor.TplText), func(ctx context.Context) (err error) {
+		if breaker != nil {
+			err = breaker.Allow()
+			if err != nil {
+				return err
+			}
+			defer func() {
+				breaker.Report(err)
+			}()
+		}
+		out, err = ctxApiFunc.runTormWithCache(ctx, tor, policy, input, func(ctx context.Context) (out []byte, err error) {
+			return tor.Run(ctx, input)
+		})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +183,11 @@ func (ctxApiFunc *ContextApiFunc) Api() (api Api) {
 	return ctxApiFunc._Api
 }
 
+// PathParams 路由匹配得到的路径参数
+func (ctxApiFunc *ContextApiFunc) PathParams() (pathParams map[string]string) {
+	return ctxApiFunc._PathParams
+}
+
 func (ctxApiFunc *ContextApiFunc) Torms() (torms torm.Torms) {
 	return ctxApiFunc._Torms
 }
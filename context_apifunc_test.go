@@ -0,0 +1,48 @@
+package apifunc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+type traceIDKey struct{}
+
+func TestContextApiFuncPropagation(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	container.RegisterAPIFlow("POST", "/api/slow", nil, func(ctxApiFunc *apifunc.ContextApiFunc, input []byte) (out []byte, err error) {
+		traceID, _ := ctxApiFunc.Value(traceIDKey{}).(string)
+		select {
+		case <-ctxApiFunc.Done():
+			return nil, ctxApiFunc.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+		return []byte(`{"traceId":"` + traceID + `"}`), nil
+	})
+	container.RegisterAPI(apifunc.Api{
+		ApiName:            "slow",
+		Method:             "POST",
+		Route:              "/api/slow",
+		Flow:               apifunc.DefaultAPIFlows,
+		RequestLineschema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input",
+		ResponseLineschema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+	})
+	require.NoError(t, container.Compile())
+
+	ctxApiFunc, err := container.GetContextApiFunc("/api/slow", "POST")
+	require.NoError(t, err)
+	ctx := context.WithValue(context.Background(), traceIDKey{}, "trace-1")
+	out, err := apifunc.RunApiFunc(ctxApiFunc.WithContext(ctx), []byte(`{}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"traceId":"trace-1"}`, string(out))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = apifunc.RunApiFunc(ctxApiFunc.WithContext(ctx), []byte(`{}`))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+}
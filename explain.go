@@ -0,0 +1,175 @@
+package apifunc
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+)
+
+// ExplainStatement 试运行时torm渲染的语句；非SQL资源(CURL、REDIS等)为渲染后的请求、命令文本
+type ExplainStatement struct {
+	TormName       string          `json:"tormName"`
+	SourceID       string          `json:"sourceId"`
+	SourceType     string          `json:"sourceType"`
+	Input          json.RawMessage `json:"input"`          // 经过转换后进入模板的入参
+	Statement      string          `json:"statement"`      // 参数替换后的语句
+	NamedStatement string          `json:"namedStatement"` // 命名参数形式的语句，如 where id=:id
+	Args           map[string]any  `json:"args"`           // 命名参数及其值
+}
+
+// Explain 试运行结果，按执行顺序记录各torm渲染的语句
+type Explain struct {
+	mu         sync.Mutex
+	Statements []ExplainStatement `json:"statements"`
+}
+
+func (explain *Explain) add(statement ExplainStatement) {
+	explain.mu.Lock()
+	defer explain.mu.Unlock()
+	explain.Statements = append(explain.Statements, statement)
+}
+
+type dryRunKey struct{}
+
+func explainFromContext(ctx context.Context) (explain *Explain) {
+	explain, _ = ctx.Value(dryRunKey{}).(*Explain)
+	return explain
+}
+
+// WithDryRun 返回试运行上下文：通过该上下文执行的 RunTorm、RunApiFunc 只执行到资源调用前，
+// 渲染的语句记录在 explain 中，torm 返回空结果，不访问资源、不读写缓存
+func (ctxApiFunc *ContextApiFunc) WithDryRun() (dryRunCtx *ContextApiFunc, explain *Explain) {
+	explain = &Explain{Statements: make([]ExplainStatement, 0)}
+	dryRunCtx = ctxApiFunc.WithContext(context.WithValue(ctxApiFunc.Context(), dryRunKey{}, explain))
+	return dryRunCtx, explain
+}
+
+// DryRunApiFunc 试运行api，返回渲染的语句；api 依赖torm结果时按空结果继续执行，执行出错时仍返回已渲染的语句
+func DryRunApiFunc(ctxApiFunc *ContextApiFunc, input []byte) (explain *Explain, err error) {
+	dryRunCtx, explain := ctxApiFunc.WithDryRun()
+	_, err = RunApiFunc(dryRunCtx, input)
+	return explain, err
+}
+
+// TormRendererI 驱动流程中的渲染步骤(如SQL模板、CURL请求、键值命令)，试运行时在该步骤渲染后停止，不执行资源调用；
+// 渲染结果只需填写 Statement 及可选的 NamedStatement、Args
+type TormRendererI interface {
+	Render(ctx context.Context, input []byte) (statement ExplainStatement, err error)
+}
+
+// dryRunTorm 按torm流程执行到渲染步骤，记录渲染结果；渲染前只执行入参转换，其它处理器可能访问资源，遇到时报错
+func (ctxApiFunc *ContextApiFunc) dryRunTorm(tor torm.Torm, input []byte, explain *Explain) (out []byte, err error) {
+	packetHandlers, err := tor.PacketHandlers.GetByName(tor.Flow...)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Context(ctxApiFunc)
+	data := input
+	for _, packetHandler := range packetHandlers {
+		renderer, ok := packetHandler.(TormRendererI)
+		if !ok && packetHandler.Name() == packet.PACKETHANDLER_NAME_TormPackHandler {
+			renderer, ok = NewTormRenderPacketHandler(tor).(TormRendererI), true // 未替换的SQL渲染处理器，渲染规则一致
+		}
+		if ok {
+			statement, err := renderer.Render(ctx, data)
+			if err != nil {
+				err = errors.WithMessagef(err, "torm:%s,packet handler:%s", tor.TplName, packetHandler.Name())
+				return nil, err
+			}
+			statement.TormName, statement.SourceID, statement.SourceType = tor.TplName, tor.Source.Identifer, tor.Source.Type
+			statement.Input = json.RawMessage(data)
+			if len(statement.Input) == 0 {
+				statement.Input = json.RawMessage("null")
+			}
+			explain.add(statement)
+			return []byte(""), nil
+		}
+		if packetHandler.Name() != packet.PACKETHANDLER_NAME_TransferPacketHandler {
+			break
+		}
+		ctx, data, err = packetHandler.Before(ctx, data)
+		if errors.Is(err, packethandler.ERROR_EMPTY_FUNC) {
+			err = nil
+		}
+		if err != nil {
+			err = errors.WithMessagef(err, "torm:%s,packet handler:%s", tor.TplName, packetHandler.Name())
+			return nil, err
+		}
+	}
+	err = errors.Errorf("torm:%s flow has no render step(implement TormRendererI) before resource call,can not dry run", tor.TplName)
+	return nil, err
+}
+
+type _TormRenderPacketHandler struct {
+	tor torm.Torm
+}
+
+// NewTormRenderPacketHandler 渲染SQL模板，与 packet.NewTormPackHandler 名称、输出一致，另支持试运行渲染(命名参数及参数值)
+func NewTormRenderPacketHandler(tor torm.Torm) (packHandler packethandler.PacketHandlerI) {
+	return &_TormRenderPacketHandler{tor: tor}
+}
+
+func (h *_TormRenderPacketHandler) Name() string {
+	return packet.PACKETHANDLER_NAME_TormPackHandler
+}
+
+func (h *_TormRenderPacketHandler) Description() string {
+	return `使用go template 生成sql语句`
+}
+
+func (h *_TormRenderPacketHandler) String() string {
+	return ""
+}
+
+func (h *_TormRenderPacketHandler) Before(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	statement, err := h.Render(ctx, input)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return ctx, []byte(statement.Statement), nil
+}
+
+func (h *_TormRenderPacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	return ctx, input, packethandler.ERROR_EMPTY_FUNC
+}
+
+// Render 渲染模板得到SQL及命名参数形式的语句
+func (h *_TormRenderPacketHandler) Render(ctx context.Context, input []byte) (statement ExplainStatement, err error) {
+	var m map[string]any
+	if len(input) > 0 {
+		err = json.Unmarshal(input, &m)
+		if err != nil {
+			err = errors.WithMessagef(err, "torm:%s input", h.tor.TplName)
+			return statement, err
+		}
+	}
+	packet.ConvertFloatsToInt(m)
+	volume := torm.VolumeMap(m)
+	sqls, namedSQL, resetedVolume, err := torm.GetSQLFromTemplate(h.tor.GetRootTemplate(), h.tor.TplName, &volume)
+	if err != nil {
+		err = errors.WithMessagef(err, "torm:%s", h.tor.TplName)
+		return statement, err
+	}
+	namedData := map[string]any(volume)
+	if v, ok := resetedVolume.(*torm.VolumeMap); ok {
+		namedData = *v
+	}
+	args := make(map[string]any)
+	for _, m := range namedParamRegexp.FindAllStringSubmatch(namedSQL, -1) {
+		args[m[1]] = namedData[m[1]]
+	}
+	statement = ExplainStatement{
+		Statement:      sqls,
+		NamedStatement: namedSQL,
+		Args:           args,
+	}
+	return statement, nil
+}
+
+var namedParamRegexp = regexp.MustCompile(`(?:^|[^:\w]):([A-Za-z_]\w*)`)
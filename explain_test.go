@@ -0,0 +1,139 @@
+package apifunc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	_ "github.com/suifengpiao14/apifunc/sqlitesource"
+	"github.com/suifengpiao14/torm"
+)
+
+func TestDryRun(t *testing.T) {
+	container := newContainer()
+	_, err := container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{
+				SourceID:   "user",
+				SourceType: apifunc.SOURCE_TYPE_SQLITE,
+				DDL:        "CREATE TABLE `t_user` (`id` int(11) NOT NULL AUTO_INCREMENT, `name` varchar(64) NOT NULL DEFAULT '', `status` int(11) NOT NULL DEFAULT 0, PRIMARY KEY (`id`)) ENGINE=InnoDB;",
+			},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "GetUser", SourceID: "user", Tpl: `{{define "GetUser"}}select * from t_user where name=:name {{if .status}} and status=:status{{end}} limit 1;{{end}}`},
+			{TemplateID: "InsertUser", SourceID: "user", Tpl: `{{define "InsertUser"}}insert into t_user (name) values (:name);{{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{newApiModel("insertUser", "/api/user/insert", []string{"GetUser", "InsertUser"}, "fullname=name,required")},
+	})
+	require.NoError(t, err)
+	ctxApiFunc, err := container.GetContextApiFunc("/api/user/insert", "POST")
+	require.NoError(t, err)
+
+	t.Run("torm", func(t *testing.T) {
+		dryRunCtx, explain := ctxApiFunc.WithDryRun()
+		out, err := dryRunCtx.RunTorm("GetUser", []byte(`{"name":"a","status":1}`))
+		require.NoError(t, err)
+		require.Empty(t, out)
+		require.Len(t, explain.Statements, 1)
+		statement := explain.Statements[0]
+		require.Equal(t, "GetUser", statement.TormName)
+		require.Equal(t, "user", statement.SourceID)
+		require.Contains(t, statement.Statement, "name='a'")
+		require.Contains(t, statement.NamedStatement, "status=:status")
+		require.Equal(t, map[string]any{"name": "a", "status": 1}, statement.Args)
+	})
+
+	t.Run("api", func(t *testing.T) {
+		explain, err := apifunc.DryRunApiFunc(ctxApiFunc, []byte(`{"name":"b"}`))
+		require.NoError(t, err)
+		require.Len(t, explain.Statements, 2)
+		require.Equal(t, "GetUser", explain.Statements[0].TormName)
+		require.Equal(t, "InsertUser", explain.Statements[1].TormName)
+		require.Equal(t, "insert into t_user (name) values ('b');", explain.Statements[1].Statement)
+
+		out, err := ctxApiFunc.RunTorm("GetUser", []byte(`{"name":"b"}`))
+		require.NoError(t, err)
+		require.Empty(t, out) // 试运行未写入数据
+	})
+}
+
+// countingKV 记录收到的命令数
+type countingKV struct {
+	apifunc.KVI
+	commands int32
+}
+
+func (kv *countingKV) Do(ctx context.Context, args ...string) (reply any, err error) {
+	atomic.AddInt32(&kv.commands, 1)
+	return kv.KVI.Do(ctx, args...)
+}
+
+// countingTxProvider 记录开启的事务数
+type countingTxProvider struct {
+	begins *int32
+}
+
+func (p countingTxProvider) TypeName() string {
+	return "counting_tx_provider"
+}
+
+func (p countingTxProvider) BeginTx(ctx context.Context) (tx apifunc.TxI, err error) {
+	atomic.AddInt32(p.begins, 1)
+	return fakeTx{}, nil
+}
+
+func TestDryRunNoResourceCall(t *testing.T) {
+	t.Run("curl", func(t *testing.T) {
+		var requests int32
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+		}))
+		defer upstream.Close()
+		container := newContainer()
+		_, err := container.Reload(newCurlModelSet(upstream.URL, ""))
+		require.NoError(t, err)
+		ctxApiFunc, err := container.GetContextApiFunc("/api/user", "POST")
+		require.NoError(t, err)
+		explain, err := apifunc.DryRunApiFunc(ctxApiFunc, []byte(`{"id":"1"}`))
+		require.NoError(t, err)
+		require.Len(t, explain.Statements, 1)
+		require.Equal(t, "GET /user?id=1 HTTP/1.1\r\nHost: example.com\r\n\r\n", explain.Statements[0].Statement)
+		require.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	})
+
+	t.Run("kv", func(t *testing.T) {
+		kv := &countingKV{KVI: apifunc.NewMemoryKV()}
+		container := newContainer()
+		_, err := container.Reload(apifunc.ModelSet{
+			SourceModels: apifunc.SourceModels{
+				{SourceID: "cache", SourceType: "REDIS", Config: fmt.Sprintf(`{"addr":"%s"}`, serveRESP(t, kv))},
+			},
+			TormModels: apifunc.TormModels{
+				{TemplateID: "Visit", SourceID: "cache", Tpl: "{{define \"Visit\"}}\nINCR visit:{{.id}}\nHSET visitor:{{.id}} last {{.name}}\n{{end}}"},
+			},
+			ApiModels: apifunc.ApiModels{newApiModel("visit", "/api/visit", []string{"Visit"})},
+		})
+		require.NoError(t, err)
+		ctxApiFunc, err := container.GetContextApiFunc("/api/visit", "POST")
+		require.NoError(t, err)
+		explain, err := apifunc.DryRunApiFunc(ctxApiFunc, []byte(`{"id":1,"name":"x y"}`))
+		require.NoError(t, err)
+		require.Len(t, explain.Statements, 1)
+		require.Equal(t, "INCR visit:1\nHSET visitor:1 last \"x y\"", explain.Statements[0].Statement)
+		require.Equal(t, int32(0), atomic.LoadInt32(&kv.commands))
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		var begins int32
+		tor := torm.Torm{TplName: "a", Source: torm.Source{Identifer: "db", Provider: countingTxProvider{begins: &begins}}}
+		dryRunCtx, _ := apifunc.NewContextApiFunc(apifunc.Api{}, torm.Torms{tor}, apifunc.Project{}).WithDryRun()
+		err := dryRunCtx.Transaction("db", func(txCtx *apifunc.ContextApiFunc) (err error) { return nil })
+		require.NoError(t, err)
+		require.Equal(t, int32(0), atomic.LoadInt32(&begins))
+	})
+}
@@ -3,29 +3,35 @@ module github.com/suifengpiao14/apifunc
 go 1.21.0
 
 require (
+	github.com/ThreeDotsLabs/watermill v1.2.0
+	github.com/blastrain/vitess-sqlparser v0.0.0-20201030050434-a139afbb1aba
 	github.com/evanphx/json-patch/v5 v5.9.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/pkg/errors v0.9.1
 	github.com/spf13/cast v1.6.0
 	github.com/stretchr/testify v1.9.0
+	github.com/suifengpiao14/cudevent v0.0.18
 	github.com/suifengpiao14/glob v0.0.4
 	github.com/suifengpiao14/goscript v0.0.4
+	github.com/suifengpiao14/httpraw v0.0.7
+	github.com/suifengpiao14/lineschema v0.0.36
 	github.com/suifengpiao14/logchan/v2 v2.0.24
 	github.com/suifengpiao14/packethandler v0.0.6
 	github.com/suifengpiao14/pathtransfer v0.0.14
 	github.com/suifengpiao14/sqlexec v0.0.31
+	github.com/suifengpiao14/sqlplus v0.0.20
 	github.com/suifengpiao14/sshmysql v0.0.6
 	github.com/suifengpiao14/stream v0.0.70
 	github.com/suifengpiao14/torm v0.0.39
 	github.com/tidwall/gjson v1.17.1
 	github.com/tidwall/sjson v1.2.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/Masterminds/goutils v1.1.1 // indirect
 	github.com/Masterminds/semver/v3 v3.2.0 // indirect
 	github.com/Masterminds/sprig/v3 v3.2.3 // indirect
-	github.com/ThreeDotsLabs/watermill v1.2.0 // indirect
-	github.com/blastrain/vitess-sqlparser v0.0.0-20201030050434-a139afbb1aba // indirect
 	github.com/casdoor/casdoor-go-sdk v0.29.1 // indirect
 	github.com/cznic/mathutil v0.0.0-20181122101859-297441e03548 // indirect
 	github.com/d5/tengo/v2 v2.16.1 // indirect
@@ -64,15 +70,11 @@ require (
 	github.com/shopspring/decimal v1.2.0 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	github.com/suifengpiao14/apihandler v0.0.71 // indirect
-	github.com/suifengpiao14/cudevent v0.0.18 // indirect
 	github.com/suifengpiao14/ddl-executor v0.0.4 // indirect
 	github.com/suifengpiao14/funcs v0.0.18 // indirect
 	github.com/suifengpiao14/gjsonmodifier v0.2.2 // indirect
-	github.com/suifengpiao14/httpraw v0.0.7 // indirect
 	github.com/suifengpiao14/kvstruct v0.0.14 // indirect
-	github.com/suifengpiao14/lineschema v0.0.36 // indirect
 	github.com/suifengpiao14/sdkgolib v0.0.23 // indirect
-	github.com/suifengpiao14/sqlplus v0.0.20 // indirect
 	github.com/syyongx/php2go v0.9.8 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.1 // indirect
@@ -95,7 +97,6 @@ require (
 	google.golang.org/grpc v1.62.1 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	gorm.io/gorm v1.25.5 // indirect
 	moul.io/http2curl v1.0.0 // indirect
 )
@@ -0,0 +1,54 @@
+package apifunc_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+// newContainer 创建不输出日志的容器
+func newContainer() (container *apifunc.Container) {
+	return apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+}
+
+// inputSchema 入参 schema，fields 为字段行
+func inputSchema(fields ...string) (schema string) {
+	return strings.Join(append([]string{"version=http://json-schema.org/draft-07/schema#,direction=in,id=input"}, fields...), "\n")
+}
+
+// outputSchema 出参 schema，fields 为字段行
+func outputSchema(fields ...string) (schema string) {
+	return strings.Join(append([]string{"version=http://json-schema.org/draft-07/schema#,direction=out,id=out"}, fields...), "\n")
+}
+
+// newApiModel 创建依次执行 tormNames 的 POST 接口，inputFields 为入参字段行
+func newApiModel(apiId string, route string, tormNames []string, inputFields ...string) (apiModel apifunc.ApiModel) {
+	apiModel = apifunc.ApiModel{
+		ApiId:        apiId,
+		Method:       "POST",
+		Route:        route,
+		InputSchema:  inputSchema(inputFields...),
+		OutputSchema: outputSchema(),
+	}
+	if len(tormNames) > 0 {
+		dependents := make([]string, 0, len(tormNames))
+		for _, tormName := range tormNames {
+			dependents = append(dependents, fmt.Sprintf(`{"fullname":"%s","type":"torm"}`, tormName))
+		}
+		apiModel.Dependents = apifunc.DependentJson("[" + strings.Join(dependents, ",") + "]")
+	}
+	return apiModel
+}
+
+// runApi 执行 route 对应的 POST 接口并返回出参
+func runApi(t *testing.T, container *apifunc.Container, route string, input string) (out string) {
+	ctxApiFunc, err := container.GetContextApiFunc(route, "POST")
+	require.NoError(t, err)
+	b, err := apifunc.RunApiFunc(ctxApiFunc, []byte(input))
+	require.NoError(t, err)
+	return string(b)
+}
@@ -0,0 +1,248 @@
+package apifunc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/lineschema"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	HTTP_STATUS_CLIENT_CLOSED_REQUEST = 499 // 客户端主动断开，沿用nginx约定
+	HTTP_MAX_BODY_SIZE                = 10 << 20
+)
+
+// HttpStatusI 错误实现该接口时，使用其返回值作为http状态码
+type HttpStatusI interface {
+	HttpStatus() (httpStatus int)
+}
+
+// HttpHandler 将编译后的容器封装为 http.Handler，负责合并请求参数、执行api、输出结果
+type HttpHandler struct {
+	container     *Container
+	MaxBodySize   int64                                            // 请求体最大字节数，默认 HTTP_MAX_BODY_SIZE
+	PathParamsFn  func(r *http.Request) (params map[string]string) // 外部路由组件提取的路径参数
+	ErrorStatusFn func(err error) (httpStatus int)                 // 错误转http状态码，默认 ErrorHttpStatus
+	OpenAPIRoute  string                                           // 非空时以 GET 方式在该路由输出 OpenAPI 文档，路由以 .yaml/.yml 结尾或 ?format=yaml 时输出yaml
+	OpenAPIInfo   OpenAPIInfo
+}
+
+// NewHttpHandler 基于容器生成 http.Handler，容器需要先Compile
+func NewHttpHandler(container *Container) (handler *HttpHandler) {
+	return &HttpHandler{
+		container:     container,
+		MaxBodySize:   HTTP_MAX_BODY_SIZE,
+		ErrorStatusFn: ErrorHttpStatus,
+	}
+}
+
+func (h *HttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.OpenAPIRoute != "" && r.Method == http.MethodGet && strings.EqualFold(r.URL.Path, h.OpenAPIRoute) {
+		h.serveOpenAPI(w, r)
+		return
+	}
+	ctxApiFunc, err := h.container.GetContextApiFunc(r.URL.Path, r.Method)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	ctxApiFunc = ctxApiFunc.WithContext(r.Context())
+	var pathParams map[string]string
+	if h.PathParamsFn != nil {
+		pathParams = h.PathParamsFn(r)
+	}
+	input, err := h.ReadInput(r, pathParams)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	out, err := RunApiFunc(ctxApiFunc, input)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	writeResponse(w, http.StatusOK, out)
+}
+
+func (h *HttpHandler) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.container.OpenAPI(h.OpenAPIInfo)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	contentType := "application/json; charset=utf-8"
+	var b []byte
+	path := strings.ToLower(r.URL.Path)
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") || strings.EqualFold(r.URL.Query().Get("format"), "yaml") {
+		contentType = "application/yaml; charset=utf-8"
+		b, err = doc.YAML()
+	} else {
+		b, err = doc.JSON()
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// ReadInput 按 query、form、json body、path 参数的顺序合并为api入参，后者覆盖前者
+func (h *HttpHandler) ReadInput(r *http.Request, pathParams map[string]string) (input []byte, err error) {
+	input = []byte("{}")
+	input, err = setValues(input, r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	maxBodySize := h.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = HTTP_MAX_BODY_SIZE
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBodySize {
+		err = newHttpError(http.StatusRequestEntityTooLarge, errors.Errorf("request body exceeds %d bytes", maxBodySize))
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if mediaType == "multipart/form-data" {
+			err = r.ParseMultipartForm(maxBodySize)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			return nil, newHttpError(http.StatusBadRequest, err)
+		}
+		input, err = setValues(input, r.PostForm)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		body = bytes.TrimSpace(body)
+		if len(body) == 0 {
+			break
+		}
+		if !gjson.ValidBytes(body) || !gjson.ParseBytes(body).IsObject() {
+			err = newHttpError(http.StatusBadRequest, errors.Errorf("request body require json object,got:%s", string(body)))
+			return nil, err
+		}
+		input, err = jsonpatch.MergePatch(input, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range pathParams {
+		input, err = sjson.SetBytes(input, escapePathKey(key), value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+// setValues 将 url.Values 写入json顶层，单值为字符串，多值为数组
+func setValues(input []byte, values map[string][]string) (out []byte, err error) {
+	out = input
+	for key, vals := range values {
+		key = strings.TrimSuffix(key, "[]")
+		var value any = vals
+		if len(vals) == 1 {
+			value = vals[0]
+		}
+		out, err = sjson.SetBytes(out, escapePathKey(key), value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// escapePathKey 转义 sjson 路径元字符(. | # * ? @ 及开头的 : 等)，客户端提交的 key 只作为字段名
+func escapePathKey(key string) (path string) {
+	path = gjson.Escape(key)
+	if strings.HasPrefix(path, ":") {
+		path = `\` + path
+	}
+	return path
+}
+
+func (h *HttpHandler) writeError(w http.ResponseWriter, err error) {
+	statusFn := h.ErrorStatusFn
+	if statusFn == nil {
+		statusFn = ErrorHttpStatus
+	}
+	httpStatus := statusFn(err)
+	b, _ := json.Marshal(map[string]any{
+		"code":    httpStatus,
+		"message": RedactSecrets(err.Error()),
+	})
+	writeResponse(w, httpStatus, b)
+}
+
+func writeResponse(w http.ResponseWriter, httpStatus int, out []byte) {
+	contentType := "text/plain; charset=utf-8"
+	if len(out) == 0 || gjson.ValidBytes(out) {
+		contentType = "application/json; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(httpStatus)
+	w.Write(out)
+}
+
+// ErrorHttpStatus 默认的错误转http状态码规则
+func ErrorHttpStatus(err error) (httpStatus int) {
+	var httpStatusI HttpStatusI
+	switch {
+	case errors.As(err, &httpStatusI):
+		return httpStatusI.HttpStatus()
+	case errors.Is(err, ERROR_NOT_FOUND_API):
+		return http.StatusNotFound
+	case errors.Is(err, ERROR_METHOD_NOT_ALLOWED):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, lineschema.ERROR_INVALID):
+		return http.StatusBadRequest
+	case errors.Is(err, ERROR_BREAKER_OPEN):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return HTTP_STATUS_CLIENT_CLOSED_REQUEST
+	}
+	return http.StatusInternalServerError
+}
+
+type _HttpError struct {
+	httpStatus int
+	err        error
+}
+
+func newHttpError(httpStatus int, err error) (httpErr error) {
+	return &_HttpError{httpStatus: httpStatus, err: err}
+}
+
+func (e *_HttpError) Error() string {
+	return e.err.Error()
+}
+
+func (e *_HttpError) Unwrap() error {
+	return e.err
+}
+
+func (e *_HttpError) HttpStatus() (httpStatus int) {
+	return e.httpStatus
+}
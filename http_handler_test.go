@@ -0,0 +1,112 @@
+package apifunc_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/logchan/v2"
+	"github.com/tidwall/gjson"
+)
+
+func newHelloContainer(t *testing.T) (container *apifunc.Container) {
+	container = apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	container.RegisterAPIFlow("POST", "/api/hello", nil, func(ctxApiFunc *apifunc.ContextApiFunc, input []byte) (out []byte, err error) {
+		return input, nil
+	})
+	container.RegisterAPIByModel(nil, apifunc.ApiModel{
+		ApiId:  "hello",
+		Method: "POST",
+		Route:  "/api/hello",
+		InputSchema: `version=http://json-schema.org/draft-07/schema#,direction=in,id=input
+		fullname=name,required
+		fullname=page,required`,
+		OutputSchema: `version=http://json-schema.org/draft-07/schema#,direction=out,id=out`,
+	})
+	container.RegisterAPIFlow("POST", "/api/hello/{name}", nil, func(ctxApiFunc *apifunc.ContextApiFunc, input []byte) (out []byte, err error) {
+		return input, nil
+	})
+	container.RegisterAPIByModel(nil, apifunc.ApiModel{
+		ApiId:        "helloName",
+		Method:       "POST",
+		Route:        "/api/hello/{name}",
+		InputSchema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input\nfullname=name,required",
+		OutputSchema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+	})
+	err := container.Compile()
+	require.NoError(t, err)
+	return container
+}
+
+func TestHttpHandler(t *testing.T) {
+	container := newHelloContainer(t)
+	server := httptest.NewServer(apifunc.NewHttpHandler(container))
+	defer server.Close()
+
+	t.Run("json body merge query", func(t *testing.T) {
+		rsp, err := http.Post(server.URL+"/api/hello?page=2", "application/json", strings.NewReader(`{"name":"apifunc"}`))
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		b, err := io.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Contains(t, rsp.Header.Get("Content-Type"), "application/json")
+		require.Equal(t, "apifunc", gjson.GetBytes(b, "name").String())
+		require.Equal(t, "2", gjson.GetBytes(b, "page").String())
+	})
+
+	t.Run("form", func(t *testing.T) {
+		rsp, err := http.PostForm(server.URL+"/api/hello", url.Values{"name": {"form"}, "page": {"1"}})
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		b, err := io.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Equal(t, "form", gjson.GetBytes(b, "name").String())
+	})
+
+	t.Run("validate error", func(t *testing.T) {
+		rsp, err := http.Post(server.URL+"/api/hello", "application/json", strings.NewReader(`{"page":"1"}`))
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	})
+
+	t.Run("path params", func(t *testing.T) {
+		rsp, err := http.Post(server.URL+"/api/hello/pathName", "application/json", strings.NewReader(`{"page":"1"}`))
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		b, err := io.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Equal(t, "pathName", gjson.GetBytes(b, "name").String())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		rsp, err := http.Get(server.URL + "/api/world")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		rsp, err := http.Get(server.URL + "/api/hello")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	})
+}
+
+func TestHttpHandlerReadInputKeys(t *testing.T) {
+	handler := apifunc.NewHttpHandler(apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {}))
+	query := url.Values{"a.b": {"1"}, "a|b": {"2"}, "#": {"3"}, "*": {"4"}, ":0": {"5"}, "ids[]": {"6", "7"}}
+	r := httptest.NewRequest(http.MethodGet, "/api/hello?"+query.Encode(), nil)
+	input, err := handler.ReadInput(r, map[string]string{"id.x": "8"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"a.b":"1","a|b":"2","#":"3","*":"4",":0":"5","ids":["6","7"],"id.x":"8"}`, string(input))
+}
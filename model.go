@@ -3,6 +3,8 @@ package apifunc
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -34,7 +36,8 @@ type Dependent struct {
 }
 
 const (
-	Dependent_Type_Torm = "torm"
+	Dependent_Type_Torm         = "torm"
+	Dependent_Type_TransferFunc = "transferFunc"
 )
 
 type Dependents []Dependent
@@ -85,6 +88,7 @@ type ApiModel struct {
 	OutputSchema     string                    `json:"outputSchema"`
 	PathTransferLine pathtransfer.TransferLine `json:"pathTransfers"`
 	Flow             string                    `json:"flow"`
+	Policy           PolicyJson                `json:"policy"`
 }
 
 // Api 转为API
@@ -96,12 +100,22 @@ func (apiModel ApiModel) Api() (api Api) {
 	}
 	api = Api{
 		ApiName:            apiModel.ApiId,
+		Title:              strings.TrimSpace(apiModel.Title),
 		Method:             strings.TrimSpace(apiModel.Method),
 		Route:              strings.TrimSpace(apiModel.Route),
 		RequestLineschema:  strings.TrimSpace(apiModel.InputSchema),
 		ResponseLineschema: strings.TrimSpace(apiModel.OutputSchema),
 		PathTransfers:      apiModel.PathTransferLine.Transfer(),
 		Flow:               flows,
+		Script:             apiModel.Script, // 保留原文，编译错误行号与配置一致
+	}
+	api.Policy, api.modelErr = apiModel.Policy.Policy()
+	if api.modelErr != nil {
+		return api
+	}
+	api.Dependents, api.modelErr = apiModel.Dependents.Dependents()
+	if api.modelErr != nil {
+		api.modelErr = errors.WithMessagef(api.modelErr, "dependents:%s", string(apiModel.Dependents))
 	}
 	return api
 }
@@ -180,10 +194,37 @@ type TormModel struct {
 	Type             string                    `json:"type"`
 	TransferLine     pathtransfer.TransferLine `json:"transferLine"`
 	Flow             string                    `json:"flow"`
+	Policy           PolicyJson                `json:"policy"`
 }
 
 type TormModels []TormModel
 
+// Policies 解析torm执行策略，key 为小写的模板名称；同一资源的熔断配置必须一致
+func (tModels TormModels) Policies() (policies map[string]Policy, err error) {
+	policies = make(map[string]Policy)
+	breakerConfigs := make(map[string]BreakerConfig)
+	for _, t := range tModels {
+		policy, err := t.Policy.Policy()
+		if err != nil {
+			err = errors.WithMessagef(err, "torm:%s", t.TemplateID)
+			return nil, err
+		}
+		if policy.IsEmpty() {
+			continue
+		}
+		if policy.Breaker != nil {
+			sourceKey := strings.ToLower(t.SourceID)
+			if exists, ok := breakerConfigs[sourceKey]; ok && exists != *policy.Breaker {
+				err = errors.Errorf("torm:%s breaker config conflicts with other torms of source:%s", t.TemplateID, t.SourceID)
+				return nil, err
+			}
+			breakerConfigs[sourceKey] = *policy.Breaker
+		}
+		policies[strings.ToLower(t.TemplateID)] = policy
+	}
+	return policies, nil
+}
+
 func (tModels TormModels) GetByName(names ...string) (subModels TormModels) {
 	subModels = make(TormModels, 0)
 	for _, n := range names {
@@ -230,6 +271,48 @@ func (tModels TormModels) GetTransferLine() (transferLine pathtransfer.TransferL
 	return transferLine
 }
 
+var ERROR_SOURCE_NOT_FOUND_IN_ENV = errors.New("source not found in env")
+
+// CheckSources 检查torm引用的资源均已加载，错误中列出缺失资源的torm、引用该torm的api(apiModels 可为空)、资源所属环境及已加载的资源
+func (tModels TormModels) CheckSources(sourceModels SourceModels, apiModels ApiModels) (err error) {
+	loaded := make(map[string]bool)
+	sourceIds := make([]string, 0, len(sourceModels))
+	envs := make([]string, 0)
+	for _, sourceModel := range sourceModels {
+		loaded[strings.ToLower(sourceModel.SourceID)] = true
+		sourceIds = append(sourceIds, sourceModel.SourceID)
+		if sourceModel.ENV != "" && !slices.Contains(envs, sourceModel.ENV) {
+			envs = append(envs, sourceModel.ENV)
+		}
+	}
+	missing := make([]string, 0)
+	for _, tormModel := range tModels {
+		if loaded[strings.ToLower(tormModel.SourceID)] {
+			continue
+		}
+		apiIds := make([]string, 0)
+		for _, apiModel := range apiModels {
+			deps, _ := apiModel.Dependents.Dependents()
+			for _, fullname := range deps.FilterByType(Dependent_Type_Torm).Fullnames() {
+				if strings.EqualFold(fullname, tormModel.TemplateID) {
+					apiIds = append(apiIds, apiModel.ApiId)
+					break
+				}
+			}
+		}
+		msg := fmt.Sprintf("torm:%s sourceId:%s", tormModel.TemplateID, tormModel.SourceID)
+		if len(apiIds) > 0 {
+			msg = fmt.Sprintf("api:%s %s", strings.Join(apiIds, ","), msg)
+		}
+		missing = append(missing, msg)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	err = errors.WithMessagef(ERROR_SOURCE_NOT_FOUND_IN_ENV, "%s; env:%s, loaded sources:[%s]", strings.Join(missing, "; "), strings.Join(envs, ","), strings.Join(sourceIds, ","))
+	return err
+}
+
 func (tModels TormModels) Torms(sources torm.Sources) (torms torm.Torms, err error) {
 	torms = make(torm.Torms, 0)
 	groupdTormModels := tModels.GroupBySourceId()
@@ -247,7 +330,7 @@ func (tModels TormModels) Torms(sources torm.Sources) (torms torm.Torms, err err
 			flows := packethandler.Flow(strings.Split(strings.TrimSpace(tormModel.Flow), ","))
 			flows.DropEmpty()
 			if len(flows) == 0 {
-				flows = DefaultTormFlows
+				flows = defaultTormFlow(source.Type)
 			}
 			tor, err := baseTorms.GetByTplName(tormModel.TemplateID)
 			if err != nil {
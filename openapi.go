@@ -0,0 +1,326 @@
+package apifunc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/lineschema"
+	"gopkg.in/yaml.v3"
+)
+
+const OPENAPI_VERSION = "3.0.3"
+
+// OpenAPIDocument OpenAPI 3.0 文档，仅包含由 api 注册信息生成的部分
+type OpenAPIDocument struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    OpenAPIInfo                             `json:"info"`
+	Servers []OpenAPIServer                         `json:"servers,omitempty"`
+	Paths   map[string]map[string]*OpenAPIOperation `json:"paths"` // key 为路径、小写方法名
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Parameters  []OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]OpenAPIResponse `json:"responses"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"` // path、query
+	Required bool           `json:"required,omitempty"`
+	Schema   map[string]any `json:"schema"`
+}
+
+type OpenAPIRequestBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]OpenAPIMediaType `json:"content"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema  map[string]any `json:"schema"`
+	Example any            `json:"example,omitempty"`
+}
+
+// JSON 输出json格式文档
+func (doc *OpenAPIDocument) JSON() (b []byte, err error) {
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// YAML 输出yaml格式文档，字段顺序与json一致
+func (doc *OpenAPIDocument) YAML() (b []byte, err error) {
+	jsonByte, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var node yaml.Node
+	err = yaml.Unmarshal(jsonByte, &node) // json 是 yaml 的子集，经 yaml.Node 保留字段顺序
+	if err != nil {
+		return nil, err
+	}
+	resetYamlStyle(&node)
+	return yaml.Marshal(&node)
+}
+
+// resetYamlStyle 去除从json解析得到的流式风格，输出块风格yaml
+func resetYamlStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYamlStyle(child)
+	}
+}
+
+// OpenAPI 根据当前生效的api生成 OpenAPI 文档，容器需要先Compile
+func (c *Container) OpenAPI(info OpenAPIInfo, servers ...OpenAPIServer) (doc *OpenAPIDocument, err error) {
+	snap := c.current.Load()
+	if snap == nil {
+		err = errors.New("container not compiled, call Compile or Reload first")
+		return nil, err
+	}
+	return NewOpenAPIDocument(info, snap.apis, servers...)
+}
+
+// NewOpenAPIDocument 根据api集合生成 OpenAPI 文档：入参、出参 lineschema 转为 schema，标题作为摘要，协议默认值作为默认响应
+func NewOpenAPIDocument(info OpenAPIInfo, apis Apis, servers ...OpenAPIServer) (doc *OpenAPIDocument, err error) {
+	doc = &OpenAPIDocument{
+		OpenAPI: OPENAPI_VERSION,
+		Info:    info,
+		Servers: servers,
+		Paths:   make(map[string]map[string]*OpenAPIOperation),
+	}
+	for _, api := range apis {
+		path, pathParams, err := openAPIPath(api.Route)
+		if err != nil {
+			return nil, err
+		}
+		methods := openAPIMethods(api.Method)
+		for _, method := range methods {
+			operation, err := openAPIOperation(api, method, len(methods) > 1, pathParams)
+			if err != nil {
+				err = errors.WithMessagef(err, "api:%s", api.ApiName)
+				return nil, err
+			}
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = make(map[string]*OpenAPIOperation)
+			}
+			doc.Paths[path][method] = operation
+		}
+	}
+	return doc, nil
+}
+
+// openAPIPath 路由转为 OpenAPI 路径，通配符按路径参数处理
+func openAPIPath(route string) (path string, pathParams []string, err error) {
+	tokens, err := parseRoute(route)
+	if err != nil {
+		return "", nil, err
+	}
+	var w strings.Builder
+	for _, tok := range tokens {
+		switch tok.kind {
+		case routeTokenStatic:
+			w.WriteString(tok.value)
+		default:
+			name := tok.value
+			if name == "*" {
+				name = "wildcard"
+			}
+			w.WriteString("{" + name + "}")
+			pathParams = append(pathParams, name)
+		}
+	}
+	return w.String(), pathParams, nil
+}
+
+// openAPIMethods api.Method 支持逗号分隔，为空或 * 时按 post 输出，去重
+func openAPIMethods(method string) (methods []string) {
+	seen := make(map[string]bool)
+	for _, m := range strings.Split(method, ",") {
+		m = strings.ToLower(strings.TrimSpace(m))
+		if m == "" || m == ROUTE_METHOD_ANY {
+			m = "post"
+		}
+		if seen[m] {
+			continue
+		}
+		seen[m] = true
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// openAPIOperation multiMethod 为 true 时 operationId 追加请求方法，保证同一api的多个操作唯一
+func openAPIOperation(api Api, method string, multiMethod bool, pathParams []string) (operation *OpenAPIOperation, err error) {
+	operationID := api.ApiName
+	switch {
+	case operationID == "":
+		operationID = method + strings.ReplaceAll(strings.Trim(api.Route, "/"), "/", "_")
+	case multiMethod:
+		operationID += strings.ToUpper(method[:1]) + method[1:]
+	}
+	operation = &OpenAPIOperation{
+		OperationID: operationID,
+		Summary:     api.Title,
+		Responses:   make(map[string]OpenAPIResponse),
+	}
+	requestSchema, err := lineschemaToOpenAPISchema(api.RequestLineschema)
+	if err != nil {
+		err = errors.WithMessage(err, "request lineschema")
+		return nil, err
+	}
+	properties, _ := requestSchema["properties"].(map[string]any)
+	for _, name := range pathParams {
+		schema, _ := properties[name].(map[string]any)
+		if schema == nil {
+			schema = map[string]any{"type": "string"}
+		}
+		operation.Parameters = append(operation.Parameters, OpenAPIParameter{Name: name, In: "path", Required: true, Schema: schema})
+		delete(properties, name) // 路径参数由路由注入，不再出现在请求体、查询参数中
+	}
+	if requiredArr, ok := requestSchema["required"].([]any); ok && len(pathParams) > 0 {
+		required := make([]any, 0, len(requiredArr))
+		for _, name := range requiredArr {
+			if _, ok := properties[cast.ToString(name)]; ok {
+				required = append(required, name)
+			}
+		}
+		requestSchema["required"] = required
+		if len(required) == 0 {
+			delete(requestSchema, "required")
+		}
+	}
+	switch method {
+	case "get", "head", "delete":
+		required := make(map[string]bool)
+		requiredArr, _ := requestSchema["required"].([]any)
+		for _, name := range requiredArr {
+			required[cast.ToString(name)] = true
+		}
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			schema, _ := properties[name].(map[string]any)
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{Name: name, In: "query", Required: required[name], Schema: schema})
+		}
+	default:
+		operation.RequestBody = &OpenAPIRequestBody{
+			Required: len(properties) > 0,
+			Content:  map[string]OpenAPIMediaType{"application/json": {Schema: requestSchema}},
+		}
+	}
+	responseSchema, err := lineschemaToOpenAPISchema(api.ResponseLineschema)
+	if err != nil {
+		err = errors.WithMessage(err, "response lineschema")
+		return nil, err
+	}
+	operation.Responses["200"] = OpenAPIResponse{
+		Description: http.StatusText(http.StatusOK),
+		Content:     map[string]OpenAPIMediaType{"application/json": {Schema: responseSchema}},
+	}
+	if defaultResponse, ok, err := openAPIDefaultResponse(api.ResponseDefaultJson); err != nil {
+		return nil, err
+	} else if ok {
+		operation.Responses["default"] = defaultResponse
+	}
+	return operation, nil
+}
+
+// openAPIDefaultResponse 协议字段默认值(如 code、message)作为默认(错误)响应
+func openAPIDefaultResponse(responseDefaultJson string) (response OpenAPIResponse, ok bool, err error) {
+	responseDefaultJson = strings.TrimSpace(responseDefaultJson)
+	if responseDefaultJson == "" {
+		return response, false, nil
+	}
+	var example any
+	err = json.Unmarshal([]byte(responseDefaultJson), &example)
+	if err != nil {
+		err = errors.WithMessagef(err, "response default json:%s", responseDefaultJson)
+		return response, false, err
+	}
+	lschema, err := lineschema.Json2lineSchema(responseDefaultJson)
+	if err != nil {
+		return response, false, err
+	}
+	schema, err := jsonschemaToOpenAPISchema(lschema)
+	if err != nil {
+		return response, false, err
+	}
+	response = OpenAPIResponse{
+		Description: "default response",
+		Content:     map[string]OpenAPIMediaType{"application/json": {Schema: schema, Example: example}},
+	}
+	return response, true, nil
+}
+
+func lineschemaToOpenAPISchema(raw string) (schema map[string]any, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return map[string]any{"type": "object"}, nil
+	}
+	lschema, err := lineschema.ParseLineschema(raw)
+	if err != nil {
+		return nil, err
+	}
+	return jsonschemaToOpenAPISchema(lschema)
+}
+
+// jsonschemaToOpenAPISchema OpenAPI 3.0 schema 不支持 $schema、$id 及 lineschema 附加的 path 等关键字，转换后去除
+func jsonschemaToOpenAPISchema(lschema *lineschema.Lineschema) (schema map[string]any, err error) {
+	b, err := lschema.JsonSchema()
+	if err != nil {
+		return nil, err
+	}
+	schema = make(map[string]any)
+	err = json.Unmarshal(b, &schema)
+	if err != nil {
+		return nil, err
+	}
+	cleanOpenAPISchema(schema)
+	if _, ok := schema["type"]; !ok {
+		schema["type"] = "object"
+	}
+	return schema, nil
+}
+
+func cleanOpenAPISchema(schema map[string]any) {
+	delete(schema, "$schema")
+	delete(schema, "$id")
+	delete(schema, "path")
+	if properties, ok := schema["properties"].(map[string]any); ok {
+		for _, property := range properties {
+			if sub, ok := property.(map[string]any); ok {
+				cleanOpenAPISchema(sub)
+			}
+		}
+	}
+	for _, key := range []string{"items", "additionalProperties"} {
+		if sub, ok := schema[key].(map[string]any); ok {
+			cleanOpenAPISchema(sub)
+		}
+	}
+}
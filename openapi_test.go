@@ -0,0 +1,89 @@
+package apifunc_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/tidwall/gjson"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOpenAPI(t *testing.T) {
+	getUserApi := newApiModel("getUser", "/api/user/{userId}", []string{"GetUser"}, "fullname=userId,format=int,required", "fullname=fields,required")
+	getUserApi.Title, getUserApi.Method = "用户详情", "GET"
+	getUserApi.OutputSchema = outputSchema("fullname=userId", "fullname=name")
+	listUserApi := newApiModel("listUser", "/api/users", []string{"GetUser"})
+	listUserApi.Method = "post,GET"
+	container := newContainer()
+	_, err := container.Reload(apifunc.ModelSet{
+		ResponseDefaultJson: []byte(`{"code":"0","message":"ok"}`),
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "db", SourceType: apifunc.SOURCE_TYPE_FIXTURE, Config: `{"fixtures":[]}`},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "GetUser", SourceID: "db", Tpl: `{{define "GetUser"}}select * from t_user where id={{.userId}}{{end}}`},
+			{TemplateID: "InsertUser", SourceID: "db", Tpl: `{{define "InsertUser"}}insert into t_user (name) values ({{.name}}){{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{getUserApi, listUserApi, newApiModel("insertUser", "/api/user", []string{"InsertUser"}, "fullname=name,required")},
+	})
+	require.NoError(t, err)
+	doc, err := container.OpenAPI(apifunc.OpenAPIInfo{Title: "user", Version: "1.0.0"})
+	require.NoError(t, err)
+	b, err := doc.JSON()
+	require.NoError(t, err)
+	result := gjson.ParseBytes(b)
+	require.Equal(t, "3.0.3", result.Get("openapi").String())
+
+	getUser := result.Get(`paths./api/user/{userId}.get`)
+	require.Equal(t, "getUser", getUser.Get("operationId").String())
+	require.Equal(t, "用户详情", getUser.Get("summary").String())
+	require.JSONEq(t, `[
+		{"name":"userId","in":"path","required":true,"schema":{"type":"string","format":"int"}},
+		{"name":"fields","in":"query","required":true,"schema":{"type":"string"}}
+	]`, getUser.Get("parameters").Raw)
+	require.Equal(t, []string{"name", "userId"}, keys(getUser.Get(`responses.200.content.application/json.schema.properties`)))
+	require.JSONEq(t, `{"code":"0","message":"ok"}`, getUser.Get(`responses.default.content.application/json.example`).Raw)
+	require.False(t, getUser.Get(`responses.200.content.application/json.schema.$schema`).Exists())
+
+	insertUser := result.Get(`paths./api/user.post`)
+	require.False(t, insertUser.Get("summary").Exists())
+	require.True(t, insertUser.Get("requestBody.required").Bool())
+	require.JSONEq(t, `["name"]`, insertUser.Get(`requestBody.content.application/json.schema.required`).Raw)
+
+	require.Equal(t, "listUserGet", result.Get(`paths./api/users.get.operationId`).String())
+	require.Equal(t, "listUserPost", result.Get(`paths./api/users.post.operationId`).String())
+
+	yamlByte, err := doc.YAML()
+	require.NoError(t, err)
+	var fromYaml map[string]any
+	require.NoError(t, yaml.Unmarshal(yamlByte, &fromYaml))
+	require.Equal(t, "getUser", fromYaml["paths"].(map[string]any)["/api/user/{userId}"].(map[string]any)["get"].(map[string]any)["operationId"])
+
+	handler := apifunc.NewHttpHandler(container)
+	handler.OpenAPIRoute = "/openapi.json"
+	handler.OpenAPIInfo = apifunc.OpenAPIInfo{Title: "user", Version: "1.0.0"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	for query, contentType := range map[string]string{"": "application/json; charset=utf-8", "?format=yaml": "application/yaml; charset=utf-8"} {
+		resp, err := http.Get(server.URL + "/openapi.json" + query)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, contentType, resp.Header.Get("Content-Type"))
+		require.Contains(t, string(body), "/api/user/{userId}")
+	}
+}
+
+func keys(result gjson.Result) (names []string) {
+	result.ForEach(func(key, value gjson.Result) bool {
+		names = append(names, key.String())
+		return true
+	})
+	return names
+}
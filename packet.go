@@ -60,11 +60,11 @@ func (packet *_ApiFlowFuncPacketHandler) Before(ctx context.Context, input []byt
 	if err != nil {
 		return ctx, nil, err
 	}
-	return newCtx, out, nil
+	return ctx, out, nil
 }
 func (packet *_ApiFlowFuncPacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
 	err = packethandler.ERROR_EMPTY_FUNC
-	return newCtx, input, err
+	return ctx, input, err
 }
 
 func (packet *_ApiFlowFuncPacketHandler) String() string {
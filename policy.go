@@ -0,0 +1,336 @@
+package apifunc
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/lineschema"
+	"github.com/suifengpiao14/torm"
+)
+
+// Policy 执行策略，Api 与 torm 共用
+type Policy struct {
+	Timeout      time.Duration  `json:"timeout"`      // 单次执行超时时间，0 表示不限制(仍受调用方上下文约束)
+	Retry        int            `json:"retry"`        // 失败重试次数，只对幂等读操作生效
+	RetryBackoff time.Duration  `json:"retryBackoff"` // 首次重试等待时间，之后按2倍递增
+	Breaker      *BreakerConfig `json:"breaker"`      // 熔断配置，仅torm有效，按 SourceID 统计
+	Cache        *CacheConfig   `json:"cache"`        // 响应缓存，api按名称+入参缓存，torm仅缓存读语句
+}
+
+// IsEmpty 是否未配置任何策略
+func (p Policy) IsEmpty() (ok bool) {
+	return p.Timeout == 0 && p.Retry == 0 && p.Breaker == nil && p.Cache == nil
+}
+
+// Do 按策略执行fn，retryable 为 false 时不重试；每次执行派生独立的超时上下文
+func (p Policy) Do(ctx context.Context, retryable bool, fn func(ctx context.Context) (err error)) (err error) {
+	retry := p.Retry
+	if !retryable || retry < 0 {
+		retry = 0
+	}
+	backoff := p.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err = p.do(ctx, fn)
+		if err == nil || attempt >= retry || !isRetryableError(ctx, err) {
+			return err
+		}
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func (p Policy) do(ctx context.Context, fn func(ctx context.Context) (err error)) (err error) {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := withTimeout(ctx, p.Timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// withTimeout ContextApiFunc 需要保持类型，派生超时上下文后重新包装
+func withTimeout(ctx context.Context, timeout time.Duration) (newCtx context.Context, cancel context.CancelFunc) {
+	if ctxApiFunc, ok := ctx.(*ContextApiFunc); ok {
+		sub, cancel := context.WithTimeout(ctxApiFunc.Context(), timeout)
+		return ctxApiFunc.WithContext(sub), cancel
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isRetryableError 调用方已取消、熔断、参数校验错误不重试
+func isRetryableError(ctx context.Context, err error) (ok bool) {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, ERROR_BREAKER_OPEN) || errors.Is(err, lineschema.ERROR_INVALID) {
+		return false
+	}
+	return true
+}
+
+// PolicyJson 配置中的策略，时间使用 time.ParseDuration 格式，如:
+// {"timeout":"3s","retry":2,"retryBackoff":"100ms","breaker":{"failures":5,"cooldown":"30s"},"cache":{"ttl":"1m","maxSize":1000}}
+type PolicyJson string
+
+func (pj PolicyJson) Policy() (policy Policy, err error) {
+	if strings.TrimSpace(string(pj)) == "" {
+		return policy, nil
+	}
+	raw := struct {
+		Timeout      string `json:"timeout"`
+		Retry        int    `json:"retry"`
+		RetryBackoff string `json:"retryBackoff"`
+		Breaker      *struct {
+			Failures int    `json:"failures"`
+			Cooldown string `json:"cooldown"`
+		} `json:"breaker"`
+		Cache *struct {
+			TTL     string `json:"ttl"`
+			MaxSize int    `json:"maxSize"`
+		} `json:"cache"`
+	}{}
+	err = json.Unmarshal([]byte(pj), &raw)
+	if err != nil {
+		err = errors.WithMessagef(err, "policy:%s", string(pj))
+		return policy, err
+	}
+	policy.Retry = raw.Retry
+	policy.Timeout, err = parseDuration(raw.Timeout)
+	if err != nil {
+		return policy, err
+	}
+	policy.RetryBackoff, err = parseDuration(raw.RetryBackoff)
+	if err != nil {
+		return policy, err
+	}
+	if raw.Breaker != nil {
+		policy.Breaker = &BreakerConfig{Failures: raw.Breaker.Failures}
+		policy.Breaker.Cooldown, err = parseDuration(raw.Breaker.Cooldown)
+		if err != nil {
+			return policy, err
+		}
+	}
+	if raw.Cache != nil {
+		policy.Cache = &CacheConfig{MaxSize: raw.Cache.MaxSize}
+		policy.Cache.TTL, err = parseDuration(raw.Cache.TTL)
+		if err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
+
+func parseDuration(s string) (d time.Duration, err error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err = time.ParseDuration(s)
+	if err != nil {
+		err = errors.WithMessagef(err, "policy duration:%s", s)
+		return 0, err
+	}
+	return d, nil
+}
+
+var readStatementRegexp = regexp.MustCompile(`(?is)^\s*(select|show|explain|describe|desc)\b`)
+var templateActionRegexp = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+
+// isReadTemplate 去除模板动作后判断是否为只读语句，用于决定是否允许重试
+func isReadTemplate(tplText string) (ok bool) {
+	return readStatementRegexp.MatchString(templateActionRegexp.ReplaceAllString(tplText, " "))
+}
+
+// isIdempotentMethods 所有请求方法都是只读方法时，api 可以重试
+func isIdempotentMethods(methods []string) (ok bool) {
+	for _, method := range methods {
+		switch method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			return false
+		}
+	}
+	return len(methods) > 0
+}
+
+var ERROR_BREAKER_OPEN = errors.New("circuit breaker is open")
+
+const (
+	BREAKER_STATE_CLOSED    = "closed"
+	BREAKER_STATE_OPEN      = "open"
+	BREAKER_STATE_HALF_OPEN = "halfOpen"
+)
+
+// BreakerConfig 熔断配置，连续失败 Failures 次后熔断，Cooldown 后放行一个探测请求
+type BreakerConfig struct {
+	Failures int           `json:"failures"`
+	Cooldown time.Duration `json:"cooldown"`
+}
+
+// BreakerState 熔断器状态快照
+type BreakerState struct {
+	SourceID string    `json:"sourceId"`
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt"`
+}
+
+// Breaker 熔断器
+type Breaker struct {
+	mu       sync.Mutex
+	sourceID string
+	config   BreakerConfig
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+func NewBreaker(sourceID string, config BreakerConfig) (breaker *Breaker) {
+	if config.Failures <= 0 {
+		config.Failures = 5
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+	return &Breaker{
+		sourceID: sourceID,
+		config:   config,
+		state:    BREAKER_STATE_CLOSED,
+		now:      time.Now,
+	}
+}
+
+// Allow 是否放行请求，熔断期间返回 ERROR_BREAKER_OPEN
+func (b *Breaker) Allow() (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BREAKER_STATE_OPEN:
+		if b.now().Sub(b.openedAt) < b.config.Cooldown {
+			return errors.WithMessagef(ERROR_BREAKER_OPEN, "source:%s", b.sourceID)
+		}
+		b.state, b.probing = BREAKER_STATE_HALF_OPEN, true
+	case BREAKER_STATE_HALF_OPEN:
+		if b.probing { // 半开状态只放行一个探测请求
+			return errors.WithMessagef(ERROR_BREAKER_OPEN, "source:%s", b.sourceID)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Report 上报执行结果
+func (b *Breaker) Report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if errors.Is(err, context.Canceled) { // 调用方取消，不计入统计
+		return
+	}
+	if err == nil {
+		b.state, b.failures = BREAKER_STATE_CLOSED, 0
+		return
+	}
+	b.failures++
+	if b.state == BREAKER_STATE_HALF_OPEN || b.failures >= b.config.Failures {
+		b.state, b.openedAt = BREAKER_STATE_OPEN, b.now()
+	}
+}
+
+func (b *Breaker) State() (state BreakerState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerState{
+		SourceID: b.sourceID,
+		State:    b.state,
+		Failures: b.failures,
+		OpenedAt: b.openedAt,
+	}
+}
+
+// BreakerGroup 按 SourceID 管理熔断器，容器内共享，重载后状态保留
+type BreakerGroup struct {
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewBreakerGroup() (group *BreakerGroup) {
+	return &BreakerGroup{breakers: make(map[string]*Breaker)}
+}
+
+// Get 获取资源对应的熔断器，不存在时按配置创建；配置由 Configure 在发布版本时固定，执行期间不重建熔断器
+func (g *BreakerGroup) Get(sourceID string, config BreakerConfig) (breaker *Breaker) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	key := strings.ToLower(sourceID)
+	breaker, ok := g.breakers[key]
+	if ok {
+		return breaker
+	}
+	breaker = NewBreaker(sourceID, config)
+	g.breakers[key] = breaker
+	return breaker
+}
+
+// Configure 设置各资源的熔断配置(key 为小写的 SourceID)，配置变化的资源重置熔断器，其余保留状态
+func (g *BreakerGroup) Configure(configs map[string]BreakerConfig) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, config := range configs {
+		breaker, ok := g.breakers[key]
+		if ok && breaker.config == NewBreaker(key, config).config {
+			continue
+		}
+		sourceID := key
+		if ok {
+			sourceID = breaker.sourceID
+		}
+		g.breakers[key] = NewBreaker(sourceID, config)
+	}
+}
+
+// tormBreakerConfigs 按资源汇总torm的熔断配置(key 为小写的 SourceID)，同一资源下配置不一致时报错
+func tormBreakerConfigs(torms torm.Torms, tormPolicies map[string]Policy) (configs map[string]BreakerConfig, err error) {
+	configs = make(map[string]BreakerConfig)
+	owners := make(map[string]string)
+	for _, tor := range torms {
+		policy := tormPolicies[strings.ToLower(tor.TplName)]
+		if policy.Breaker == nil {
+			continue
+		}
+		key := strings.ToLower(tor.Source.Identifer)
+		config := NewBreaker(tor.Source.Identifer, *policy.Breaker).config
+		if exists, ok := configs[key]; ok && exists != config {
+			err = errors.Errorf("torm:%s breaker config conflicts with torm:%s of source:%s", tor.TplName, owners[key], tor.Source.Identifer)
+			return nil, err
+		}
+		configs[key], owners[key] = config, tor.TplName
+	}
+	return configs, nil
+}
+
+func (g *BreakerGroup) States() (states []BreakerState) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	states = make([]BreakerState, 0, len(g.breakers))
+	for _, breaker := range g.breakers {
+		states = append(states, breaker.State())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].SourceID < states[j].SourceID })
+	return states
+}
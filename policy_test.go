@@ -0,0 +1,95 @@
+package apifunc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+func TestPolicyJson(t *testing.T) {
+	policy, err := apifunc.PolicyJson(`{"timeout":"3s","retry":2,"retryBackoff":"100ms","breaker":{"failures":3,"cooldown":"1m"}}`).Policy()
+	require.NoError(t, err)
+	require.Equal(t, 3*time.Second, policy.Timeout)
+	require.Equal(t, 2, policy.Retry)
+	require.Equal(t, 100*time.Millisecond, policy.RetryBackoff)
+	require.Equal(t, apifunc.BreakerConfig{Failures: 3, Cooldown: time.Minute}, *policy.Breaker)
+
+	_, err = apifunc.PolicyJson(`{"timeout":"3x"}`).Policy()
+	require.Error(t, err)
+}
+
+func TestPolicyDo(t *testing.T) {
+	policy := apifunc.Policy{Retry: 2, Timeout: 20 * time.Millisecond}
+	attempts := 0
+	err := policy.Do(context.Background(), true, func(ctx context.Context) (err error) {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = policy.Do(context.Background(), false, func(ctx context.Context) (err error) {
+		attempts++
+		return errors.New("write failed")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts) // 非幂等操作不重试
+}
+
+func TestBreaker(t *testing.T) {
+	breaker := apifunc.NewBreaker("db", apifunc.BreakerConfig{Failures: 2, Cooldown: 20 * time.Millisecond})
+	failed := errors.New("db down")
+	for i := 0; i < 2; i++ {
+		require.NoError(t, breaker.Allow())
+		breaker.Report(failed)
+	}
+	require.Equal(t, apifunc.BREAKER_STATE_OPEN, breaker.State().State)
+	require.True(t, errors.Is(breaker.Allow(), apifunc.ERROR_BREAKER_OPEN))
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, breaker.Allow()) // 半开，放行探测请求
+	require.True(t, errors.Is(breaker.Allow(), apifunc.ERROR_BREAKER_OPEN))
+	breaker.Report(nil)
+	require.Equal(t, apifunc.BREAKER_STATE_CLOSED, breaker.State().State)
+}
+
+func TestBreakerConfigAcrossBatches(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	sourceModels := apifunc.SourceModels{{SourceID: "db", SourceType: apifunc.SOURCE_TYPE_FIXTURE, Config: `{"fixtures":[]}`}}
+	err := container.RegisterTormByModels(apifunc.TormModels{
+		{TemplateID: "GetUser", SourceID: "db", Tpl: `{{define "GetUser"}}select * from t_user;{{end}}`, Policy: `{"breaker":{"failures":3,"cooldown":"1m"}}`},
+	}, sourceModels)
+	require.NoError(t, err)
+	err = container.RegisterTormByModels(apifunc.TormModels{
+		{TemplateID: "GetOrder", SourceID: "db", Tpl: `{{define "GetOrder"}}select * from t_order;{{end}}`, Policy: `{"breaker":{"failures":5,"cooldown":"1m"}}`},
+	}, sourceModels)
+	require.ErrorContains(t, err, "torm:GetOrder breaker config conflicts with torm:GetUser of source:db")
+
+	// 重新注册同名torm时以新配置为准
+	err = container.RegisterTormByModels(apifunc.TormModels{
+		{TemplateID: "GetUser", SourceID: "db", Tpl: `{{define "GetUser"}}select * from t_user;{{end}}`},
+		{TemplateID: "GetOrder", SourceID: "db", Tpl: `{{define "GetOrder"}}select * from t_order;{{end}}`, Policy: `{"breaker":{"failures":5,"cooldown":"1m"}}`},
+	}, sourceModels)
+	require.NoError(t, err)
+}
+
+func TestBreakerGroup(t *testing.T) {
+	group := apifunc.NewBreakerGroup()
+	group.Configure(map[string]apifunc.BreakerConfig{"db": {Failures: 1, Cooldown: time.Minute}})
+	breaker := group.Get("db", apifunc.BreakerConfig{Failures: 1, Cooldown: time.Minute})
+	breaker.Report(errors.New("db down"))
+	require.Same(t, breaker, group.Get("db", apifunc.BreakerConfig{Failures: 9})) // 执行期间不重建
+	require.Equal(t, apifunc.BREAKER_STATE_OPEN, breaker.State().State)
+
+	group.Configure(map[string]apifunc.BreakerConfig{"db": {Failures: 1, Cooldown: time.Minute}})
+	require.Same(t, breaker, group.Get("db", apifunc.BreakerConfig{}))
+	group.Configure(map[string]apifunc.BreakerConfig{"db": {Failures: 2, Cooldown: time.Minute}}) // 发布新配置时重置
+	require.Equal(t, apifunc.BREAKER_STATE_CLOSED, group.Get("db", apifunc.BreakerConfig{}).State().State)
+}
@@ -0,0 +1,257 @@
+package apifunc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ROUTE_METHOD_ANY = "*" // 匹配所有请求方法
+)
+
+var ERROR_METHOD_NOT_ALLOWED = errors.New("method not allowed")
+
+// Router 基于基数树(radix tree)的路由索引，支持路径参数 /order/{orderId}、通配符 /static/{filepath...} 或 /static/*
+// 静态部分大小写不敏感(与 apiKey 保持一致)，路径参数保留原值
+type Router struct {
+	root *routeNode
+}
+
+type routeNode struct {
+	path         string       // 压缩后的静态前缀(小写)
+	children     []*routeNode // 静态子节点，首字节互不相同
+	paramName    string
+	paramChild   *routeNode
+	wildcardName string
+	wildcardNode *routeNode
+	methods      map[string]*Api // key 为大写方法名或 ROUTE_METHOD_ANY
+}
+
+// NewRouter 根据api集合生成路由索引，同一路由、方法重复注册时报错
+func NewRouter(apis Apis) (router *Router, err error) {
+	router = &Router{root: &routeNode{}}
+	for i := range apis {
+		err = router.Add(&apis[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return router, nil
+}
+
+// Add 注册api，api.Method 支持逗号分隔多个方法(如 "post,get")，为空或 * 表示任意方法
+func (r *Router) Add(api *Api) (err error) {
+	tokens, err := parseRoute(api.Route)
+	if err != nil {
+		return err
+	}
+	n := r.root
+	for _, tok := range tokens {
+		switch tok.kind {
+		case routeTokenStatic:
+			n = n.insertStatic(asciiLower(tok.value))
+		case routeTokenParam:
+			if n.paramChild == nil {
+				n.paramName, n.paramChild = tok.value, &routeNode{}
+			}
+			if n.paramName != tok.value {
+				err = errors.Errorf("route %s: path param {%s} conflicts with registered {%s}", api.Route, tok.value, n.paramName)
+				return err
+			}
+			n = n.paramChild
+		case routeTokenWildcard:
+			if n.wildcardNode == nil {
+				n.wildcardName, n.wildcardNode = tok.value, &routeNode{}
+			}
+			if n.wildcardName != tok.value {
+				err = errors.Errorf("route %s: wildcard {%s...} conflicts with registered {%s...}", api.Route, tok.value, n.wildcardName)
+				return err
+			}
+			n = n.wildcardNode
+		}
+	}
+	if n.methods == nil {
+		n.methods = make(map[string]*Api)
+	}
+	for _, method := range api.Methods() {
+		if exists, ok := n.methods[method]; ok {
+			err = errors.Errorf("duplicate route %s %s, registered by api:%s,got api:%s", method, api.Route, exists.ApiName, api.ApiName)
+			return err
+		}
+		n.methods[method] = api
+	}
+	return nil
+}
+
+// Match 查找路由，返回匹配的api及路径参数；优先匹配注册了该方法的路由(如 GET /user/list 在 /user/list 只注册了 POST 时匹配 GET /user/{id})，
+// 路由存在但均不支持该方法时返回 ERROR_METHOD_NOT_ALLOWED
+func (r *Router) Match(path string, method string) (api *Api, params map[string]string, err error) {
+	method = strings.ToUpper(strings.TrimSpace(method))
+	params = make(map[string]string)
+	lowerPath := asciiLower(path)
+	n := r.root.match(path, lowerPath, params, func(n *routeNode) bool { return n.methodApi(method) != nil })
+	if n != nil {
+		return n.methodApi(method), params, nil
+	}
+	n = r.root.match(path, lowerPath, make(map[string]string), func(n *routeNode) bool { return n.methods != nil })
+	if n == nil {
+		err = errors.WithMessagef(ERROR_NOT_FOUND_API, "route:%s,method:%s", path, method)
+		return nil, nil, err
+	}
+	err = errors.WithMessagef(ERROR_METHOD_NOT_ALLOWED, "route:%s,method:%s,allowed:%s", path, method, strings.Join(n.allowedMethods(), ","))
+	return nil, nil, err
+}
+
+// methodApi 节点上处理该方法的api，没有时使用任意方法的api
+func (n *routeNode) methodApi(method string) (api *Api) {
+	if api, ok := n.methods[method]; ok {
+		return api
+	}
+	return n.methods[ROUTE_METHOD_ANY]
+}
+
+func (n *routeNode) insertStatic(path string) (leaf *routeNode) {
+	for {
+		if path == "" {
+			return n
+		}
+		var child *routeNode
+		for _, c := range n.children {
+			if c.path[0] == path[0] {
+				child = c
+				break
+			}
+		}
+		if child == nil {
+			child = &routeNode{path: path}
+			n.children = append(n.children, child)
+			return child
+		}
+		common := commonPrefixLen(child.path, path)
+		if common < len(child.path) { // 分裂已有节点
+			split := *child
+			split.path = child.path[common:]
+			*child = routeNode{path: child.path[:common], children: []*routeNode{&split}}
+		}
+		n, path = child, path[common:]
+	}
+}
+
+// match 回溯匹配，优先级: 静态 > 路径参数 > 通配符；accept 决定叶子节点是否可用，不可用时继续尝试其它分支
+func (n *routeNode) match(path string, lowerPath string, params map[string]string, accept func(n *routeNode) bool) (leaf *routeNode) {
+	if lowerPath == "" && accept(n) {
+		return n
+	}
+	for _, c := range n.children {
+		if strings.HasPrefix(lowerPath, c.path) {
+			l := len(c.path)
+			if leaf = c.match(path[l:], lowerPath[l:], params, accept); leaf != nil {
+				return leaf
+			}
+		}
+	}
+	if n.paramChild != nil {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		if end > 0 {
+			if leaf = n.paramChild.match(path[end:], lowerPath[end:], params, accept); leaf != nil {
+				params[n.paramName] = path[:end]
+				return leaf
+			}
+		}
+	}
+	if n.wildcardNode != nil && accept(n.wildcardNode) {
+		params[n.wildcardName] = path
+		return n.wildcardNode
+	}
+	return nil
+}
+
+func (n *routeNode) allowedMethods() (methods []string) {
+	methods = make([]string, 0, len(n.methods))
+	for method := range n.methods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+const (
+	routeTokenStatic = iota
+	routeTokenParam
+	routeTokenWildcard
+)
+
+type routeToken struct {
+	kind  int
+	value string
+}
+
+func parseRoute(route string) (tokens []routeToken, err error) {
+	route = strings.TrimSpace(route)
+	tokens = make([]routeToken, 0)
+	var static strings.Builder
+	for i := 0; i < len(route); i++ {
+		switch ch := route[i]; ch {
+		case '{':
+			end := strings.IndexByte(route[i:], '}')
+			if end < 0 {
+				err = errors.Errorf("route %s: unclosed {", route)
+				return nil, err
+			}
+			name := route[i+1 : i+end]
+			i += end
+			if static.Len() > 0 {
+				tokens = append(tokens, routeToken{kind: routeTokenStatic, value: static.String()})
+				static.Reset()
+			}
+			kind := routeTokenParam
+			if strings.HasSuffix(name, "...") {
+				kind, name = routeTokenWildcard, strings.TrimSuffix(name, "...")
+			}
+			if name == "" {
+				err = errors.Errorf("route %s: empty path param name", route)
+				return nil, err
+			}
+			tokens = append(tokens, routeToken{kind: kind, value: name})
+		case '*':
+			if static.Len() > 0 {
+				tokens = append(tokens, routeToken{kind: routeTokenStatic, value: static.String()})
+				static.Reset()
+			}
+			tokens = append(tokens, routeToken{kind: routeTokenWildcard, value: "*"})
+		default:
+			static.WriteByte(ch)
+		}
+		if len(tokens) > 0 && tokens[len(tokens)-1].kind == routeTokenWildcard && i != len(route)-1 {
+			err = errors.Errorf("route %s: wildcard must be the last segment", route)
+			return nil, err
+		}
+	}
+	if static.Len() > 0 {
+		tokens = append(tokens, routeToken{kind: routeTokenStatic, value: static.String()})
+	}
+	return tokens, nil
+}
+
+func commonPrefixLen(a string, b string) (l int) {
+	for l < len(a) && l < len(b) && a[l] == b[l] {
+		l++
+	}
+	return l
+}
+
+// asciiLower 只转换ASCII字母，保证转换前后字节长度一致，方便从原路径中截取参数
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,73 @@
+package apifunc_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestRouter(t *testing.T) {
+	apis := apifunc.Apis{
+		{ApiName: "orderList", Route: "/api/order", Method: "post,get"},
+		{ApiName: "orderDetail", Route: "/api/order/{orderId}", Method: "GET"},
+		{ApiName: "orderItem", Route: "/api/order/{orderId}/item/{itemId}", Method: "GET"},
+		{ApiName: "orderExport", Route: "/api/order/export", Method: "GET"},
+		{ApiName: "static", Route: "/static/{filepath...}", Method: ""},
+		{ApiName: "userCreate", Route: "/api/user/list", Method: "POST"},
+		{ApiName: "userDetail", Route: "/api/user/{id}", Method: "GET"},
+		{ApiName: "fileUpload", Route: "/file/readme", Method: "PUT"},
+		{ApiName: "file", Route: "/file/{path...}", Method: "GET"},
+	}
+	router, err := apifunc.NewRouter(apis)
+	require.NoError(t, err)
+
+	cases := []struct {
+		path   string
+		method string
+		name   string
+		params map[string]string
+	}{
+		{"/api/order", "POST", "orderList", map[string]string{}},
+		{"/API/Order", "get", "orderList", map[string]string{}},
+		{"/api/order/export", "GET", "orderExport", map[string]string{}},
+		{"/api/order/AB12", "GET", "orderDetail", map[string]string{"orderId": "AB12"}},
+		{"/api/order/12/item/3", "GET", "orderItem", map[string]string{"orderId": "12", "itemId": "3"}},
+		{"/static/js/app.js", "DELETE", "static", map[string]string{"filepath": "js/app.js"}},
+		{"/api/user/list", "POST", "userCreate", map[string]string{}},
+		{"/api/user/list", "GET", "userDetail", map[string]string{"id": "list"}}, // 静态路由不支持该方法时回溯到路径参数
+		{"/file/readme", "GET", "file", map[string]string{"path": "readme"}},     // 回溯到通配符
+	}
+	for _, c := range cases {
+		api, params, err := router.Match(c.path, c.method)
+		require.NoError(t, err, c.path)
+		require.Equal(t, c.name, api.ApiName, c.path)
+		require.Equal(t, c.params, params, c.path)
+	}
+
+	_, _, err = router.Match("/api/order/12", "POST")
+	require.True(t, errors.Is(err, apifunc.ERROR_METHOD_NOT_ALLOWED))
+	_, _, err = router.Match("/api/user/list", "DELETE") // 所有候选路由均不支持该方法
+	require.True(t, errors.Is(err, apifunc.ERROR_METHOD_NOT_ALLOWED))
+	require.ErrorContains(t, err, "allowed:POST")
+	_, _, err = router.Match("/api/user", "GET")
+	require.True(t, errors.Is(err, apifunc.ERROR_NOT_FOUND_API))
+
+	for _, c := range cases[:3] { // 未编译时按完整路由查找，方法规则与路由一致
+		api, err := apis.GetByRoute(c.path, c.method)
+		require.NoError(t, err, c.path)
+		require.Equal(t, c.name, api.ApiName, c.path)
+	}
+	api, err := apis.GetByRoute("/static/{filepath...}", "PUT")
+	require.NoError(t, err)
+	require.Equal(t, "static", api.ApiName)
+	_, err = apis.GetByRoute("/api/order/export", "POST")
+	require.True(t, errors.Is(err, apifunc.ERROR_NOT_FOUND_API))
+
+	_, err = apifunc.NewRouter(apifunc.Apis{
+		{ApiName: "a", Route: "/api/order/{id}", Method: "GET"},
+		{ApiName: "b", Route: "/api/order/{orderId}/x", Method: "GET"},
+	})
+	require.Error(t, err)
+}
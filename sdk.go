@@ -0,0 +1,343 @@
+package apifunc
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+)
+
+// GoSDKConfig Go 客户端生成配置
+type GoSDKConfig struct {
+	Package    string // 包名，默认 client
+	ClientName string // 客户端类型名，默认 Client
+}
+
+// GenerateGoSDK 根据当前生效的api生成 Go 客户端源码，容器需要先Compile
+func (c *Container) GenerateGoSDK(config GoSDKConfig) (src []byte, err error) {
+	snap := c.current.Load()
+	if snap == nil {
+		err = errors.New("container not compiled, call Compile or Reload first")
+		return nil, err
+	}
+	return GenerateGoSDK(snap.apis, config)
+}
+
+// GenerateGoSDK 生成 Go 客户端源码：每个api一个方法，请求、响应结构体由 lineschema 生成，只依赖标准库
+func GenerateGoSDK(apis Apis, config GoSDKConfig) (src []byte, err error) {
+	if config.Package == "" {
+		config.Package = "client"
+	}
+	if config.ClientName == "" {
+		config.ClientName = "Client"
+	}
+	g := &goSDKGenerator{client: config.ClientName, typeNames: make(map[string]bool), methodNames: make(map[string]bool)}
+	sorted := make(Apis, len(apis))
+	copy(sorted, apis)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ApiName < sorted[j].ApiName })
+	for _, api := range sorted {
+		err = g.api(api)
+		if err != nil {
+			err = errors.WithMessagef(err, "api:%s", api.ApiName)
+			return nil, err
+		}
+	}
+	var w bytes.Buffer
+	fmt.Fprintf(&w, "// Code generated by apifunc. DO NOT EDIT.\n\npackage %s\n", config.Package)
+	w.WriteString(strings.ReplaceAll(goSDKRuntime, "__CLIENT__", config.ClientName))
+	w.Write(g.types.Bytes())
+	w.Write(g.methods.Bytes())
+	src, err = format.Source(w.Bytes())
+	if err != nil {
+		err = errors.WithMessage(err, "format generated source")
+		return nil, err
+	}
+	return src, nil
+}
+
+type goSDKGenerator struct {
+	client      string
+	types       bytes.Buffer
+	methods     bytes.Buffer
+	typeNames   map[string]bool
+	methodNames map[string]bool
+}
+
+func (g *goSDKGenerator) api(api Api) (err error) {
+	path, pathParams, err := openAPIPath(api.Route)
+	if err != nil {
+		return err
+	}
+	methods := openAPIMethods(api.Method)
+	name := g.uniqueName(g.methodNames, goExportedName(api.ApiName, goExportedName(methods[0]+"_"+path, "Api")))
+	requestSchema, err := lineschemaToOpenAPISchema(api.RequestLineschema)
+	if err != nil {
+		return errors.WithMessage(err, "request lineschema")
+	}
+	responseSchema, err := lineschemaToOpenAPISchema(api.ResponseLineschema)
+	if err != nil {
+		return errors.WithMessage(err, "response lineschema")
+	}
+	requestType := g.namedType(name+"Request", requestSchema)
+	responseType := g.namedType(name+"Response", responseSchema)
+	summary := api.Title
+	if summary == "" {
+		summary = strings.ToUpper(methods[0]) + " " + api.Route
+	}
+	fmt.Fprintf(&g.methods, "\n// %s %s\n", name, summary)
+	fmt.Fprintf(&g.methods, "func (c *%s) %s(ctx context.Context, req %s) (resp %s, err error) {\n", g.client, name, requestType, responseType)
+	pathParamsCode := "nil"
+	if len(pathParams) > 0 {
+		pathParamsCode = fmt.Sprintf("%#v", pathParams)
+	}
+	fmt.Fprintf(&g.methods, "\terr = c.do(ctx, %q, %q, %s, req, &resp)\n", strings.ToUpper(methods[0]), path, pathParamsCode)
+	g.methods.WriteString("\treturn resp, err\n}\n")
+	return nil
+}
+
+// namedType 生成命名类型并返回类型名，无字段的对象使用 json.RawMessage
+func (g *goSDKGenerator) namedType(name string, schema map[string]any) (typeName string) {
+	properties, _ := schema["properties"].(map[string]any)
+	name = g.uniqueName(g.typeNames, name)
+	if cast.ToString(schema["type"]) == "object" && len(properties) == 0 {
+		fmt.Fprintf(&g.types, "\ntype %s = json.RawMessage\n", name)
+		return name
+	}
+	goType := g.goType(name, schema)
+	fmt.Fprintf(&g.types, "\ntype %s %s\n", name, goType)
+	return name
+}
+
+// goType schema 转为 Go 类型；嵌套对象生成独立结构体，名称为父类型名+字段名
+func (g *goSDKGenerator) goType(name string, schema map[string]any) (goType string) {
+	switch cast.ToString(schema["type"]) {
+	case "object":
+		properties, _ := schema["properties"].(map[string]any)
+		if len(properties) == 0 {
+			return "map[string]any"
+		}
+		required := make(map[string]bool)
+		requiredArr, _ := schema["required"].([]any)
+		for _, field := range requiredArr {
+			required[cast.ToString(field)] = true
+		}
+		fields := make([]string, 0, len(properties))
+		for field := range properties {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		var w strings.Builder
+		w.WriteString("struct {\n")
+		fieldNames := make(map[string]bool)
+		for _, field := range fields {
+			fieldSchema, _ := properties[field].(map[string]any)
+			fieldName := g.uniqueName(fieldNames, goExportedName(field, "Field"))
+			fieldType := g.goType(name+fieldName, fieldSchema)
+			if strings.HasPrefix(fieldType, "struct ") {
+				nested := g.uniqueName(g.typeNames, name+fieldName)
+				fmt.Fprintf(&g.types, "\ntype %s %s\n", nested, fieldType)
+				fieldType = nested
+			}
+			tag := field
+			if isGoStringFormat(fieldSchema) { // lineschema 中 format 为数字、布尔的字段 schema 类型为字符串，以字符串传输
+				tag += ",string"
+			}
+			if !required[field] {
+				tag += ",omitempty"
+			}
+			if description := cast.ToString(fieldSchema["description"]); description != "" {
+				fmt.Fprintf(&w, "\t// %s\n", strings.ReplaceAll(description, "\n", " "))
+			}
+			fmt.Fprintf(&w, "\t%s %s `json:%q`\n", fieldName, fieldType, tag)
+		}
+		w.WriteString("}")
+		return w.String()
+	case "array":
+		items, _ := schema["items"].(map[string]any)
+		if items == nil {
+			return "[]any"
+		}
+		itemType := g.goType(name+"Item", items)
+		if strings.HasPrefix(itemType, "struct ") {
+			nested := g.uniqueName(g.typeNames, name+"Item")
+			fmt.Fprintf(&g.types, "\ntype %s %s\n", nested, itemType)
+			itemType = nested
+		}
+		return "[]" + itemType
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "string":
+		if formatType := goFormatType(schema); formatType != "" {
+			return formatType
+		}
+		return "string"
+	}
+	return "any"
+}
+
+// goFormatType lineschema 通过 format 声明字符串字段的实际类型
+func goFormatType(schema map[string]any) (goType string) {
+	switch strings.ToLower(cast.ToString(schema["format"])) {
+	case "int", "integer":
+		return "int"
+	case "float", "number":
+		return "float64"
+	case "bool", "boolean":
+		return "bool"
+	}
+	return ""
+}
+
+func isGoStringFormat(schema map[string]any) (ok bool) {
+	return cast.ToString(schema["type"]) == "string" && goFormatType(schema) != ""
+}
+
+func (g *goSDKGenerator) uniqueName(names map[string]bool, name string) (unique string) {
+	unique = name
+	for i := 2; names[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	names[unique] = true
+	return unique
+}
+
+// goExportedName 转为导出的驼峰名称，非字母数字字符作为分隔符，结果为空时使用 fallback
+func goExportedName(s string, fallback string) (name string) {
+	var w strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		w.WriteRune(r)
+	}
+	name = w.String()
+	if name == "" {
+		return fallback
+	}
+	if !unicode.IsUpper([]rune(name)[0]) { // 数字、无大小写的文字开头时不可导出
+		name = fallback + name
+	}
+	return name
+}
+
+// goSDKRuntime 生成代码中的http传输部分，__CLIENT__ 替换为配置的客户端类型名
+const goSDKRuntime = `
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type __CLIENT__ struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	Header     http.Header // 每个请求附加的请求头
+}
+
+func New__CLIENT__(baseURL string) (c *__CLIENT__) {
+	return &__CLIENT__{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// APIError 服务端返回非200状态码
+type APIError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("http status %d: %s", e.StatusCode, string(e.Body))
+}
+
+// do 路径参数填充到路由，GET、HEAD、DELETE 其余参数作为查询参数，其它方法作为json请求体
+func (c *__CLIENT__) do(ctx context.Context, method string, path string, pathParams []string, req any, resp any) (err error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	params := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if decoder.Decode(&params) != nil {
+		params = nil
+	}
+	for _, name := range pathParams {
+		path = strings.ReplaceAll(path, "{"+name+"}", url.PathEscape(fmt.Sprint(params[name])))
+		delete(params, name)
+	}
+	var body io.Reader
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodDelete:
+		query := url.Values{}
+		for key, value := range params {
+			switch v := value.(type) {
+			case map[string]any, []any:
+				raw, _ := json.Marshal(v)
+				query.Set(key, string(raw))
+			default:
+				query.Set(key, fmt.Sprint(v))
+			}
+		}
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+	default:
+		if params != nil {
+			b, err = json.Marshal(params)
+			if err != nil {
+				return err
+			}
+		}
+		body = bytes.NewReader(b)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range c.Header {
+		httpReq.Header[key] = values
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	out, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return &APIError{StatusCode: httpResp.StatusCode, Body: out}
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(out, resp)
+}
+`
@@ -0,0 +1,75 @@
+package apifunc_test
+
+import (
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestGenerateGoSDK(t *testing.T) {
+	getUserApi := newApiModel("getUser", "/api/user/{userId}", []string{"GetUser"}, "fullname=userId,format=int,required", "fullname=status,required")
+	getUserApi.Title, getUserApi.Method = "用户详情", "GET"
+	getUserApi.OutputSchema = outputSchema("fullname=id,format=int", "fullname=name", "fullname=tags[].name")
+	container := newContainer()
+	_, err := container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "db", SourceType: apifunc.SOURCE_TYPE_FIXTURE, Config: `{"fixtures":[
+				{"sql":"select * from t_user where id=1 and status='on'","result":{"id":"1","name":"a","tags":[{"name":"vip"}]}},
+				{"sql":"insert into t_user (name,age) values ('b',18)","result":"2"}
+			]}`},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "GetUser", SourceID: "db", Tpl: `{{define "GetUser"}}select * from t_user where id={{.userId}} and status='{{.status}}'{{end}}`},
+			{TemplateID: "InsertUser", SourceID: "db", Tpl: `{{define "InsertUser"}}insert into t_user (name,age) values ('{{.name}}',{{.age}}){{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{getUserApi, newApiModel("insert-user", "/api/user", []string{"InsertUser"}, "fullname=name,required", "fullname=age,format=int")},
+	})
+	require.NoError(t, err)
+	src, err := container.GenerateGoSDK(apifunc.GoSDKConfig{Package: "main", ClientName: "UserClient"})
+	require.NoError(t, err)
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	server := httptest.NewServer(apifunc.NewHttpHandler(container))
+	defer server.Close()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module sdktest\n\ngo 1.18\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "client.go"), src, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(`package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+func main() {
+	c := NewUserClient(os.Args[1])
+	user, err := c.GetUser(context.Background(), GetUserRequest{UserId: 1, Status: "on"})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(user.Id+1, user.Name, user.Tags[0].Name)
+	out, err := c.InsertUser(context.Background(), InsertUserRequest{Name: "b", Age: 18})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(out))
+	_, err = c.GetUser(context.Background(), GetUserRequest{UserId: 2, Status: "on"})
+	var apiErr *APIError
+	fmt.Println(errors.As(err, &apiErr))
+}
+`), 0o644))
+	cmd := exec.Command("go", "run", ".", server.URL)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Equal(t, "2 a vip\n"+`"2"`+"\n"+"true\n", string(out))
+}
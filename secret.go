@@ -0,0 +1,388 @@
+package apifunc
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+// SECRET_REDACTED 日志、错误信息中密钥值的替换文本
+const SECRET_REDACTED = "******"
+
+var ERROR_SECRET_NOT_FOUND = errors.New("secret not found")
+var ERROR_SECRET_RESOLVER_NOT_SET = errors.New("secret resolver not set")
+
+// secretPlaceholderRegexp 资源配置中的密钥占位符，如 ${secret:amc_2b_db.password}
+var secretPlaceholderRegexp = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+
+// SecretResolverI 密钥解析器，按名称获取密钥值，未找到时返回 ERROR_SECRET_NOT_FOUND
+type SecretResolverI interface {
+	Resolve(name string) (value string, err error)
+}
+
+// SecretResolvers 依次尝试多个解析器，返回第一个找到的值
+type SecretResolvers []SecretResolverI
+
+func (resolvers SecretResolvers) Resolve(name string) (value string, err error) {
+	for _, resolver := range resolvers {
+		value, err = resolver.Resolve(name)
+		if errors.Is(err, ERROR_SECRET_NOT_FOUND) {
+			continue
+		}
+		return value, err
+	}
+	err = errors.WithMessagef(ERROR_SECRET_NOT_FOUND, "secret:%s", name)
+	return "", err
+}
+
+// EnvSecretResolver 从环境变量读取密钥，变量名为 Prefix + 名称转大写且非字母数字替换为下划线，
+// 如 Prefix 为 APIFUNC_SECRET_ 时 amc_2b_db.password 对应 APIFUNC_SECRET_AMC_2B_DB_PASSWORD
+type EnvSecretResolver struct {
+	Prefix string
+}
+
+func NewEnvSecretResolver(prefix string) (resolver *EnvSecretResolver) {
+	return &EnvSecretResolver{Prefix: prefix}
+}
+
+// EnvName 密钥名称对应的环境变量名
+func (resolver *EnvSecretResolver) EnvName(name string) (envName string) {
+	envName = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+	return resolver.Prefix + envName
+}
+
+func (resolver *EnvSecretResolver) Resolve(name string) (value string, err error) {
+	envName := resolver.EnvName(name)
+	value, ok := os.LookupEnv(envName)
+	if !ok {
+		err = errors.WithMessagef(ERROR_SECRET_NOT_FOUND, "secret:%s,env:%s", name, envName)
+		return "", err
+	}
+	return value, nil
+}
+
+// FileSecretResolver 从加密文件读取密钥，文件内容由 EncryptSecrets 生成
+type FileSecretResolver struct {
+	secrets map[string]string
+}
+
+// NewFileSecretResolver 读取并解密密钥文件，passphrase 可来自环境变量或密钥管理服务
+func NewFileSecretResolver(filename string, passphrase []byte) (resolver *FileSecretResolver, err error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := DecryptSecrets(content, passphrase)
+	if err != nil {
+		err = errors.WithMessagef(err, "secret file:%s", filename)
+		return nil, err
+	}
+	return &FileSecretResolver{secrets: secrets}, nil
+}
+
+func (resolver *FileSecretResolver) Resolve(name string) (value string, err error) {
+	value, ok := resolver.secrets[name]
+	if !ok {
+		err = errors.WithMessagef(ERROR_SECRET_NOT_FOUND, "secret:%s", name)
+		return "", err
+	}
+	return value, nil
+}
+
+// EncryptSecrets 使用 AES-256-GCM 加密密钥集合(密钥为 passphrase 的 sha256)，输出 base64 文本，可写入文件提交到仓库
+func EncryptSecrets(secrets map[string]string, passphrase []byte) (content []byte, err error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newSecretCipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	content = make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(content, sealed)
+	return content, nil
+}
+
+// DecryptSecrets 解密 EncryptSecrets 生成的内容
+func DecryptSecrets(content []byte, passphrase []byte) (secrets map[string]string, err error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, errors.WithMessage(err, "decode secrets")
+	}
+	gcm, err := newSecretCipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("decrypt secrets: content too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decrypt secrets")
+	}
+	secrets = make(map[string]string)
+	err = json.Unmarshal(plaintext, &secrets)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decrypt secrets")
+	}
+	return secrets, nil
+}
+
+func newSecretCipher(passphrase []byte) (gcm cipher.AEAD, err error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("secret passphrase required")
+	}
+	key := sha256.Sum256(passphrase)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretNames 文本中引用的密钥名称
+func secretNames(s string) (names []string) {
+	for _, m := range secretPlaceholderRegexp.FindAllStringSubmatch(s, -1) {
+		names = append(names, strings.TrimSpace(m[1]))
+	}
+	return names
+}
+
+// resolveSecrets 替换文本中的密钥占位符，解析到的值登记脱敏
+func resolveSecrets(s string, resolver SecretResolverI) (resolved string, err error) {
+	if !secretPlaceholderRegexp.MatchString(s) {
+		return s, nil
+	}
+	resolved = secretPlaceholderRegexp.ReplaceAllStringFunc(s, func(placeholder string) string {
+		if err != nil {
+			return placeholder
+		}
+		name := strings.TrimSpace(secretPlaceholderRegexp.FindStringSubmatch(placeholder)[1])
+		if resolver == nil {
+			err = errors.WithMessagef(ERROR_SECRET_RESOLVER_NOT_SET, "secret:%s", name)
+			return placeholder
+		}
+		value, resolveErr := resolver.Resolve(name)
+		if resolveErr != nil {
+			err = errors.WithMessagef(resolveErr, "resolve secret:%s", name)
+			return placeholder
+		}
+		secretRedactor.add(value)
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// ResolveSourceSecrets 替换资源配置(Config、SSHConfig)中的密钥占位符，返回新的集合；
+// 容器注册资源时自动调用，直接使用 SourceModels(如 FillDDL)前需手动调用
+func ResolveSourceSecrets(sourceModels SourceModels, resolver SecretResolverI) (resolved SourceModels, err error) {
+	resolved = make(SourceModels, len(sourceModels))
+	for i, sourceModel := range sourceModels {
+		sourceModel.Config, err = resolveSecrets(sourceModel.Config, resolver)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,config", sourceModel.SourceID)
+			return nil, err
+		}
+		sourceModel.SSHConfig, err = resolveSecrets(sourceModel.SSHConfig, resolver)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,sshConfig", sourceModel.SourceID)
+			return nil, err
+		}
+		resolved[i] = sourceModel
+	}
+	return resolved, nil
+}
+
+// redactor 记录已解析的密钥值，日志、错误信息输出前替换为 SECRET_REDACTED；日志输出为全局设置，因此为包级别
+type redactor struct {
+	mu     sync.RWMutex
+	values []string // 按长度降序，避免短值先替换导致长值部分泄露
+}
+
+var secretRedactor = &redactor{}
+
+func (r *redactor) add(value string) {
+	if value == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.values {
+		if v == value {
+			return
+		}
+	}
+	r.values = append(r.values, value)
+	sort.SliceStable(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+func (r *redactor) empty() (ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.values) == 0
+}
+
+func (r *redactor) redact(s string) (redacted string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, SECRET_REDACTED)
+	}
+	return s
+}
+
+// RedactSecrets 将文本中已解析的密钥值替换为 SECRET_REDACTED，自定义日志输出时使用
+func RedactSecrets(s string) (redacted string) {
+	return secretRedactor.redact(s)
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError 错误信息包含密钥值时返回脱敏后的错误，保留 errors.Is 判断
+func redactError(err error) error {
+	if err == nil || secretRedactor.empty() {
+		return err
+	}
+	msg := secretRedactor.redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+// redactLogInfo 复制日志信息并替换导出字段中的密钥值，保留具体类型，外部可按类型断言(如 *sqlexec.LogInfoEXECSQL)；
+// 字符串、[]byte、错误及按值嵌套的结构体、切片、map 会脱敏，指针字段(如 context)与未导出字段保持原值
+func redactLogInfo(logInfo logchan.LogInforInterface) (redacted logchan.LogInforInterface) {
+	v := reflect.ValueOf(logInfo)
+	var cp reflect.Value
+	switch {
+	case v.Kind() == reflect.Pointer && !v.IsNil() && v.Elem().Kind() == reflect.Struct:
+		cp = reflect.New(v.Elem().Type())
+		cp.Elem().Set(redactReflectValue(v.Elem()))
+	case v.Kind() == reflect.Struct:
+		cp = redactReflectValue(v)
+	default:
+		return logInfo
+	}
+	redacted, ok := cp.Interface().(logchan.LogInforInterface)
+	if !ok {
+		return logInfo
+	}
+	return redacted
+}
+
+// redactReflectValue 返回脱敏后的副本，不修改原值
+func redactReflectValue(v reflect.Value) (redacted reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		redacted = reflect.New(v.Type()).Elem()
+		redacted.SetString(secretRedactor.redact(v.String()))
+		return redacted
+	case reflect.Struct:
+		redacted = reflect.New(v.Type()).Elem()
+		redacted.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if field := redacted.Field(i); field.CanSet() {
+				field.Set(redactReflectValue(v.Field(i)))
+			}
+		}
+		return redacted
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf([]byte(secretRedactor.redact(string(v.Bytes())))).Convert(v.Type())
+		}
+		redacted = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			redacted.Index(i).Set(redactReflectValue(v.Index(i)))
+		}
+		return redacted
+	case reflect.Array:
+		redacted = reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			redacted.Index(i).Set(redactReflectValue(v.Index(i)))
+		}
+		return redacted
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		redacted = reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			redacted.SetMapIndex(redactReflectValue(iter.Key()), redactReflectValue(iter.Value()))
+		}
+		return redacted
+	case reflect.Interface:
+		if v.IsNil() || (v.Elem().Kind() == reflect.Pointer && v.Elem().IsNil()) {
+			return v
+		}
+		var inner reflect.Value
+		if err, ok := v.Interface().(error); ok {
+			inner = reflect.ValueOf(redactError(err))
+		} else {
+			inner = redactReflectValue(v.Elem())
+		}
+		if !inner.Type().AssignableTo(v.Type()) {
+			return v
+		}
+		redacted = reflect.New(v.Type()).Elem()
+		redacted.Set(inner)
+		return redacted
+	}
+	return v
+}
+
+// redactLogFn 包装日志处理函数：存在已解析的密钥时，日志信息、错误经脱敏后再交给外部处理
+func redactLogFn(fn func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error)) func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {
+	if fn == nil {
+		return nil
+	}
+	return func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {
+		if !secretRedactor.empty() && logInfo != nil {
+			logInfo = redactLogInfo(logInfo)
+		}
+		fn(logInfo, typeName, redactError(err))
+	}
+}
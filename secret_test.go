@@ -0,0 +1,132 @@
+package apifunc_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	_ "github.com/suifengpiao14/apifunc/sqlitesource"
+	"github.com/suifengpiao14/logchan/v2"
+	"github.com/suifengpiao14/sqlexec"
+	"github.com/tidwall/gjson"
+)
+
+func TestSecretResolver(t *testing.T) {
+	t.Run("env", func(t *testing.T) {
+		resolver := apifunc.NewEnvSecretResolver("APIFUNC_SECRET_")
+		require.Equal(t, "APIFUNC_SECRET_AMC_2B_DB_PASSWORD", resolver.EnvName("amc_2b_db.password"))
+		t.Setenv("APIFUNC_SECRET_AMC_2B_DB_PASSWORD", "p@ss")
+		value, err := resolver.Resolve("amc_2b_db.password")
+		require.NoError(t, err)
+		require.Equal(t, "p@ss", value)
+		_, err = resolver.Resolve("other.password")
+		require.ErrorIs(t, err, apifunc.ERROR_SECRET_NOT_FOUND)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		content, err := apifunc.EncryptSecrets(map[string]string{"amc_2b_db.password": "file-pass"}, []byte("passphrase"))
+		require.NoError(t, err)
+		require.NotContains(t, string(content), "file-pass")
+		filename := filepath.Join(t.TempDir(), "secrets.enc")
+		require.NoError(t, os.WriteFile(filename, content, 0o600))
+
+		resolver, err := apifunc.NewFileSecretResolver(filename, []byte("passphrase"))
+		require.NoError(t, err)
+		value, err := resolver.Resolve("amc_2b_db.password")
+		require.NoError(t, err)
+		require.Equal(t, "file-pass", value)
+
+		_, err = apifunc.NewFileSecretResolver(filename, []byte("wrong"))
+		require.Error(t, err)
+
+		t.Setenv("APIFUNC_SECRET_OTHER", "env-pass")
+		resolvers := apifunc.SecretResolvers{resolver, apifunc.NewEnvSecretResolver("APIFUNC_SECRET_")}
+		value, err = resolvers.Resolve("other")
+		require.NoError(t, err)
+		require.Equal(t, "env-pass", value)
+	})
+}
+
+func TestSourceSecret(t *testing.T) {
+	const dsn = "file:secret_dsn_test?mode=memory"
+	t.Setenv("APIFUNC_SECRET_USER_DB_DSN", dsn)
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	models := apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{
+				SourceID:   "user",
+				SourceType: apifunc.SOURCE_TYPE_SQLITE,
+				Config:     `{"dsn":"${secret:user_db.dsn}"}`,
+				DDL:        "CREATE TABLE `t_user` (`id` int(11) NOT NULL AUTO_INCREMENT, `name` varchar(64) NOT NULL DEFAULT '', PRIMARY KEY (`id`)) ENGINE=InnoDB;",
+			},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "GetUser", SourceID: "user", Tpl: `{{define "GetUser"}}select * from t_user;{{end}}`},
+		},
+	}
+
+	report := container.Validate(models)
+	require.True(t, report.HasError())
+	require.Contains(t, report.String(), "secret:user_db.dsn")
+	_, err := container.Reload(models)
+	require.ErrorIs(t, err, apifunc.ERROR_SECRET_RESOLVER_NOT_SET)
+
+	container.SetSecretResolver(apifunc.NewEnvSecretResolver("APIFUNC_SECRET_"))
+	require.False(t, container.Validate(models).HasError())
+	_, err = container.Reload(models)
+	require.NoError(t, err)
+	source, err := container.GetSource("user")
+	require.NoError(t, err)
+	require.Equal(t, `{"dsn":"`+dsn+`"}`, source.Config)
+
+	t.Run("redact", func(t *testing.T) {
+		broken := models
+		broken.SourceModels = apifunc.SourceModels{models.SourceModels[0]}
+		broken.SourceModels[0].Config = `{"dsn":"${secret:user_db.dsn}",}`
+		_, err := container.Reload(broken)
+		require.Error(t, err)
+		require.NotContains(t, err.Error(), dsn)
+		require.Contains(t, err.Error(), apifunc.SECRET_REDACTED)
+		require.Equal(t, "dsn ******", apifunc.RedactSecrets("dsn "+dsn))
+	})
+}
+
+func TestRedactLogInfo(t *testing.T) {
+	const secret = `p\"<&>`
+	t.Setenv("APIFUNC_SECRET_LOG_TOKEN", secret)
+	received := make(chan logchan.LogInforInterface, 1)
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {
+		if typeName == sqlexec.LOG_INFO_EXEC_SQL {
+			received <- logInfo
+		}
+	})
+	container.SetSecretResolver(apifunc.NewEnvSecretResolver("APIFUNC_SECRET_"))
+	_, err := container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "log", SourceType: apifunc.SOURCE_TYPE_FIXTURE, Config: `{"fixtures":[],"token":"${secret:log.token}"}`},
+		},
+	})
+	require.NoError(t, err)
+
+	sent := &sqlexec.LogInfoEXECSQL{SQL: "select '" + secret + "'", Err: errors.New("token=" + secret)}
+	logchan.SendLogInfo(sent)
+	var logInfo logchan.LogInforInterface
+	select {
+	case logInfo = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("log not received")
+	}
+	sqlLogInfo, ok := logInfo.(*sqlexec.LogInfoEXECSQL) // 保留具体类型
+	require.True(t, ok)
+	require.Equal(t, "select '"+apifunc.SECRET_REDACTED+"'", sqlLogInfo.SQL)
+	require.Equal(t, "token="+apifunc.SECRET_REDACTED, sqlLogInfo.Err.Error())
+	b, err := json.Marshal(logInfo)
+	require.NoError(t, err)
+	require.Equal(t, "select '"+apifunc.SECRET_REDACTED+"'", gjson.GetBytes(b, "sql").String())
+	require.Equal(t, "select '"+secret+"'", sent.SQL) // 原日志信息不被修改
+}
@@ -0,0 +1,272 @@
+package apifunc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/pathtransfer"
+	"github.com/suifengpiao14/torm"
+)
+
+// SNAPSHOT_HISTORY_SIZE 保留的历史版本数量，用于回滚
+var SNAPSHOT_HISTORY_SIZE = 10
+
+var ERROR_NO_PREVIOUS_VERSION = errors.New("no previous version to roll back to")
+
+var ERROR_NOT_FOUND_DEPENDENT = errors.New("not found dependent")
+
+// ModelSet 一组完整的配置模型(一般来自 capiprovider)，用于整体重载容器
+type ModelSet struct {
+	ScriptLanguage      string
+	ResponseDefaultJson []byte
+	TransferFuncModels  TransferFuncModels
+	ApiModels           ApiModels
+	SourceModels        SourceModels
+	TormModels          TormModels
+}
+
+// registration 注册信息(未编译)，快照中保留一份，回滚时一并恢复
+type registration struct {
+	apis         Apis
+	torms        torm.Torms
+	tormPolicies map[string]Policy
+	project      Project
+}
+
+// snapshot 编译后的不可变容器状态，请求获取上下文时持有当时的快照，直到请求结束，新版本发布不影响进行中的请求
+type snapshot struct {
+	version      int64
+	apis         Apis
+	torms        torm.Torms
+	tormPolicies map[string]Policy
+	breakers     map[string]BreakerConfig // 各资源的熔断配置，发布时设置到容器共享的熔断器
+	project      Project
+	router       *Router
+	registered   registration
+}
+
+// build 基于当前注册信息生成新快照，不修改注册信息本身
+func (c *Container) build() (snap *snapshot, err error) {
+	snap = &snapshot{
+		apis:         make(Apis, len(c.apis)),
+		torms:        make(torm.Torms, len(c.torms)),
+		tormPolicies: c.tormPolicies,
+		registered: registration{
+			apis:         c.apis,
+			torms:        c.torms,
+			tormPolicies: c.tormPolicies,
+			project:      c.project,
+		},
+	}
+	//初始化torm
+	copy(snap.torms, c.torms)
+	for i, tor := range snap.torms {
+		snap.torms[i].PacketHandlers, err = tormPacketHandlers(tor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	snap.breakers, err = tormBreakerConfigs(snap.torms, snap.tormPolicies)
+	if err != nil {
+		return nil, err
+	}
+	//初始化project
+	snap.project = c.project
+	snap.project._ScriptEngines, err = cloneScriptEngines(c.project._ScriptEngines) // 每个快照使用独立的引擎，注册的引擎及旧快照的引擎不受影响
+	if err != nil {
+		return nil, err
+	}
+	err = snap.project.Init()
+	if err != nil {
+		return nil, err
+	}
+	copy(snap.apis, c.apis)
+	err = snap.project.compileApiScripts(snap.apis)
+	if err != nil {
+		return nil, err
+	}
+	//初始化api(依赖torm、project)
+	err = snap.resolveDependents()
+	if err != nil {
+		return nil, err
+	}
+	for i := range snap.apis {
+		err = snap.apis[i].Init()
+		if err != nil {
+			return nil, err
+		}
+	}
+	//初始化路由索引
+	snap.router, err = NewRouter(snap.apis)
+	if err != nil {
+		return nil, err
+	}
+	err = snap.validate()
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// resolveDependents 按全称解析api依赖的torm、转换函数，缺失时汇总报错；
+// api 既没有业务函数也没有脚本逻辑时，依次执行依赖的torm作为默认逻辑
+func (snap *snapshot) resolveDependents() (err error) {
+	missing := make([]string, 0)
+	for i, api := range snap.apis {
+		tors := make([]torm.Torm, 0)
+		for _, dep := range api.Dependents {
+			switch dep.Type {
+			case Dependent_Type_Torm:
+				tor, err := snap.torms.GetByTplName(dep.Fullname)
+				if err != nil {
+					missing = append(missing, fmt.Sprintf("api:%s depends on torm:%s", api.ApiName, dep.Fullname))
+					continue
+				}
+				tors = append(tors, *tor)
+			case Dependent_Type_TransferFunc:
+				namespace := pathtransfer.JoinPath(pathtransfer.Transfer_Top_Namespace_Func, dep.Fullname).String()
+				if len(snap.project.FuncTransfers.GetByNamespace(namespace)) == 0 {
+					missing = append(missing, fmt.Sprintf("api:%s depends on transferFunc:%s", api.ApiName, dep.Fullname))
+				}
+			default:
+				missing = append(missing, fmt.Sprintf("api:%s depends on unknown type %s:%s", api.ApiName, dep.Type, dep.Fullname))
+			}
+		}
+		if api.businessFlowFn == nil && len(tors) > 0 && !snap.project.hasScriptLogic(api.ApiName) {
+			snap.apis[i].businessFlowFn = ApiHandlerRunTormFn(tors...)
+		}
+	}
+	if len(missing) > 0 {
+		err = errors.WithMessage(ERROR_NOT_FOUND_DEPENDENT, strings.Join(missing, "; "))
+		return err
+	}
+	return nil
+}
+
+// validate 发布前校验流程中的处理器均已注册，避免请求时才发现
+func (snap *snapshot) validate() (err error) {
+	for _, api := range snap.apis {
+		_, err = api.PacketHandlers.GetByName(api.Flow...)
+		if err != nil {
+			err = errors.WithMessagef(err, "api:%s", api.ApiName)
+			return err
+		}
+	}
+	for _, tor := range snap.torms {
+		if len(tor.PacketHandlers) == 0 {
+			continue
+		}
+		_, err = tor.PacketHandlers.GetByName(tor.Flow...)
+		if err != nil {
+			err = errors.WithMessagef(err, "torm:%s", tor.TplName)
+			return err
+		}
+	}
+	return nil
+}
+
+// publish 发布新快照，旧快照进入历史记录，调用方需持有 c.mu
+func (c *Container) publish(snap *snapshot) {
+	c.version++
+	snap.version = c.version
+	if prev := c.current.Load(); prev != nil {
+		c.history = append(c.history, prev)
+		if len(c.history) > SNAPSHOT_HISTORY_SIZE {
+			c.history = c.history[len(c.history)-SNAPSHOT_HISTORY_SIZE:]
+		}
+	}
+	c.current.Store(snap)
+	c.breakers.Configure(snap.breakers)
+	c.purgeCache()
+	c.releaseSources()
+}
+
+// releaseSources 关闭不再被注册信息、当前版本及历史版本引用的资源(移出历史的版本、重载失败时新建的资源)，调用方需持有 c.mu
+func (c *Container) releaseSources() {
+	live := make(map[torm.ProviderI]bool)
+	addTorms := func(torms torm.Torms) {
+		for _, tor := range torms {
+			if comparableProvider(tor.Source.Provider) {
+				live[tor.Source.Provider] = true
+			}
+		}
+	}
+	addTorms(c.torms)
+	snaps := append([]*snapshot{c.current.Load()}, c.history...)
+	for _, snap := range snaps {
+		if snap != nil {
+			addTorms(snap.torms)
+			addTorms(snap.registered.torms)
+		}
+	}
+	c.sources.release(live)
+}
+
+// purgeCache 切换版本后api、torm定义可能变化，清空响应缓存
+func (c *Container) purgeCache() {
+	if c.cache != nil {
+		c.cache.Purge()
+	}
+}
+
+// Reload 使用新的模型集合重新编译容器，代码注册的api(RegisterAPI、RegisterAPIFlow)及脚本引擎保留，配置未变更的资源复用。
+// 新版本在后台完整编译、校验通过后才原子替换，失败时当前版本不受影响；进行中的请求继续使用旧版本
+func (c *Container) Reload(models ModelSet) (version int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	staging := &Container{
+		apis:           make(Apis, 0, len(c.codeApis)),
+		codeApis:       c.codeApis,
+		torms:          make(torm.Torms, 0),
+		sources:        c.sources,
+		secretResolver: c.secretResolver,
+	}
+	staging.apis = append(staging.apis, c.codeApis...)
+	staging.RegisterProject(models.ScriptLanguage, c.scriptEngines, models.TransferFuncModels)
+	err = models.TormModels.CheckSources(models.SourceModels, models.ApiModels)
+	if err != nil {
+		return c.version, err
+	}
+	err = staging.RegisterTormByModels(models.TormModels, models.SourceModels)
+	if err != nil {
+		c.releaseSources()
+		return c.version, err
+	}
+	staging.RegisterAPIByModel(models.ResponseDefaultJson, models.ApiModels...)
+	snap, err := staging.build()
+	if err != nil {
+		c.releaseSources()
+		return c.version, err
+	}
+	c.apis, c.torms, c.tormPolicies, c.project = staging.apis, staging.torms, staging.tormPolicies, staging.project
+	c.compileErr, c.dirty = nil, false
+	c.publish(snap)
+	return snap.version, nil
+}
+
+// Rollback 回滚到上一个版本(注册信息一并恢复)，返回回滚后生效的版本号
+func (c *Container) Rollback() (version int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.history) == 0 {
+		return c.Version(), ERROR_NO_PREVIOUS_VERSION
+	}
+	prev := c.history[len(c.history)-1]
+	c.history = c.history[:len(c.history)-1]
+	c.apis, c.torms, c.tormPolicies, c.project = prev.registered.apis, prev.registered.torms, prev.registered.tormPolicies, prev.registered.project
+	c.compileErr, c.dirty = nil, false
+	c.current.Store(prev)
+	c.breakers.Configure(prev.breakers)
+	c.purgeCache()
+	return prev.version, nil
+}
+
+// Version 当前生效的版本号，未编译时为0
+func (c *Container) Version() (version int64) {
+	snap := c.current.Load()
+	if snap == nil {
+		return 0
+	}
+	return snap.version
+}
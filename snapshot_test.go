@@ -0,0 +1,219 @@
+package apifunc_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/goscript"
+	"github.com/suifengpiao14/goscript/yaegi"
+	"github.com/suifengpiao14/logchan/v2"
+)
+
+func TestContainerReload(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	container.RegisterAPI(apifunc.Api{ApiName: "hello"}) // 代码注册，重载时保留
+	modelSet := func(route string) apifunc.ModelSet {
+		return apifunc.ModelSet{
+			ApiModels: apifunc.ApiModels{
+				{
+					ApiId:        "hello",
+					Method:       "POST",
+					Route:        route,
+					InputSchema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input",
+					OutputSchema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+				},
+			},
+		}
+	}
+	version, err := container.Reload(modelSet("/v1/hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), version)
+	inflight, err := container.GetContextApiFunc("/v1/hello", "POST")
+	require.NoError(t, err)
+
+	version, err = container.Reload(modelSet("/v2/hello"))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), version)
+	_, err = container.GetContextApiFunc("/v1/hello", "POST")
+	require.True(t, errors.Is(err, apifunc.ERROR_NOT_FOUND_API))
+	_, err = container.GetContextApiFunc("/v2/hello", "POST")
+	require.NoError(t, err)
+	require.Equal(t, "/v1/hello", inflight.Api().Route) // 进行中的请求不受影响
+
+	broken := modelSet("/v3/hello")
+	broken.ApiModels[0].OutputSchema = ""
+	_, err = container.Reload(broken)
+	require.Error(t, err)
+	require.Equal(t, int64(2), container.Version()) // 失败不影响当前版本
+
+	version, err = container.Rollback()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), version)
+	_, err = container.GetContextApiFunc("/v1/hello", "POST")
+	require.NoError(t, err)
+	_, err = container.Rollback()
+	require.True(t, errors.Is(err, apifunc.ERROR_NO_PREVIOUS_VERSION))
+}
+
+func TestContainerReloadScriptEngines(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	engine := yaegi.NewScriptGo()
+	engine.Use(map[string]map[string]reflect.Value{"example.com/greet/greet": {"Prefix": reflect.ValueOf("hello ")}}) // 注册的符号在每个快照中可用
+	container.RegisterProject(goscript.SCRIPT_LANGUAGE_GO, goscript.ScriptIs{engine}, nil)
+	models := func(version string) apifunc.ModelSet {
+		return apifunc.ModelSet{
+			ScriptLanguage: goscript.SCRIPT_LANGUAGE_GO,
+			TransferFuncModels: apifunc.TransferFuncModels{
+				{
+					Language:     goscript.SCRIPT_LANGUAGE_GO,
+					Script:       "func SetLimit(index int,size int)(offset int,limit int){\n\treturn index*size,size\n}",
+					TransferLine: "func.SetLimit.input.index@int:Dictionary.pagination.index\nfunc.SetLimit.input.size@int:Dictionary.pagination.size\nfunc.SetLimit.output.offset@int:Dictionary.limit.offset\nfunc.SetLimit.output.limit@int:Dictionary.limit.size",
+				},
+			},
+			ApiModels: apifunc.ApiModels{
+				{
+					ApiId:  "hello",
+					Method: "POST",
+					Route:  "/api/hello",
+					Script: `
+import (
+	"example.com/greet"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func ApiLogichello(ctx *apifunc.ContextApiFunc, input []byte) ([]byte, error) {
+	return []byte(` + "`" + `{"greeting":"` + "`" + ` + greet.Prefix + ` + "`" + version + `"}` + "`" + `), nil
+}
+`,
+					InputSchema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input",
+					OutputSchema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out\nfullname=greeting",
+				},
+			},
+		}
+	}
+	run := func() string {
+		ctxApiFunc, err := container.GetContextApiFunc("/api/hello", "POST")
+		require.NoError(t, err)
+		out, err := apifunc.RunApiFunc(ctxApiFunc, []byte(`{}`))
+		require.NoError(t, err)
+		return string(out)
+	}
+	_, err := container.Reload(models("v1"))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"greeting":"hello v1"}`, run())
+	_, err = container.Reload(models("v2")) // 代码不在注册的引擎中累积
+	require.NoError(t, err)
+	require.JSONEq(t, `{"greeting":"hello v2"}`, run())
+	_, err = container.Rollback() // 旧快照使用自己的引擎
+	require.NoError(t, err)
+	require.JSONEq(t, `{"greeting":"hello v1"}`, run())
+
+	ctxApiFunc, err := container.GetContextApiFunc("/api/hello", "POST")
+	require.NoError(t, err)
+	project := ctxApiFunc.Project()
+	snapEngine, err := project.ScriptEngines().GetByLanguage(goscript.SCRIPT_LANGUAGE_GO)
+	require.NoError(t, err)
+	require.NotSame(t, engine, snapEngine)
+}
+
+func TestContainerCompileDependents(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	container.RegisterAPIByModel(nil, apifunc.ApiModel{
+		ApiId:        "hello",
+		Method:       "POST",
+		Route:        "/api/hello",
+		Dependents:   `[{"fullname":"GetHello","type":"torm"},{"fullname":"formatHello","type":"transferFunc"}]`,
+		InputSchema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input",
+		OutputSchema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+	})
+	err := container.Compile()
+	require.True(t, errors.Is(err, apifunc.ERROR_NOT_FOUND_DEPENDENT))
+	require.Contains(t, err.Error(), "torm:GetHello")
+	require.Contains(t, err.Error(), "transferFunc:formatHello")
+}
+
+func TestContainerCompileRetry(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	apiModel := apifunc.ApiModel{
+		ApiId:       "hello",
+		Method:      "POST",
+		Route:       "/api/hello",
+		InputSchema: "version=http://json-schema.org/draft-07/schema#,direction=in,id=input",
+	}
+	container.RegisterAPIByModel(nil, apiModel)
+	err := container.Compile()
+	require.Error(t, err)
+	require.Equal(t, err, container.Compile()) // 注册信息未变更，返回记录的错误
+	require.Equal(t, int64(0), container.Version())
+	_, err = container.GetContextApiFunc("/api/hello", "POST")
+	require.ErrorContains(t, err, apifunc.ERROR_COMPILED_API.Error()) // 编译失败后不再使用未初始化的注册信息
+
+	apiModel.OutputSchema = "version=http://json-schema.org/draft-07/schema#,direction=out,id=out"
+	container.RegisterAPIByModel(nil, apiModel)
+	require.NoError(t, container.Compile())
+	require.Equal(t, int64(1), container.Version())
+	require.NoError(t, container.Compile())
+	require.Equal(t, int64(1), container.Version())
+	_, err = container.GetContextApiFunc("/api/hello", "POST")
+	require.NoError(t, err)
+
+	// 重新注册时替换模型，修正后的策略、依赖生效，之前的模型错误不再保留
+	apiModel.Policy = `{"timeout":"3x"}`
+	container.RegisterAPIByModel(nil, apiModel)
+	require.Error(t, container.Compile())
+	apiModel.Policy = `{"timeout":"3s"}`
+	container.RegisterAPIByModel(nil, apiModel)
+	require.NoError(t, container.Compile())
+	ctxApiFunc, err := container.GetContextApiFunc("/api/hello", "POST")
+	require.NoError(t, err)
+	require.Equal(t, 3*time.Second, ctxApiFunc.Api().Policy.Timeout)
+}
+
+func TestContainerCompileRetryScript(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	engine := yaegi.NewScriptGo()
+	container.RegisterProject(goscript.SCRIPT_LANGUAGE_GO, goscript.ScriptIs{engine}, nil)
+	script := func(body string) string {
+		return `
+import "github.com/suifengpiao14/apifunc"
+
+func ApiLogichello(ctx *apifunc.ContextApiFunc, input []byte) ([]byte, error) {
+	` + body + `
+}
+`
+	}
+	container.RegisterAPIByModel(nil, newScriptApiModel("hello", script("return undefinedFn(input), nil")))
+	require.Error(t, container.Compile())
+
+	container.RegisterAPIByModel(nil, newScriptApiModel("hello", script("return []byte(`{\"greeting\":\"hello\"}`), nil")))
+	require.NoError(t, container.Compile()) // 失败的代码未留在引擎中
+	ctxApiFunc, err := container.GetContextApiFunc("/api/hello", "POST")
+	require.NoError(t, err)
+	out, err := apifunc.RunApiFunc(ctxApiFunc, []byte(`{"name":"apifunc"}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"greeting":"hello"}`, string(out))
+
+	_, err = engine.GetSymbolFromScript("script.ApiLogichello", nil) // 注册的引擎未写入代码
+	require.ErrorContains(t, err, "undefined: script")
+}
+
+func TestContainerReloadMissingSource(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	_, err := container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "log", ENV: "prod", SourceType: apifunc.SOURCE_TYPE_FIXTURE},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "GetUser", SourceID: "user", Tpl: `{{define "GetUser"}}select * from t_user;{{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{
+			{ApiId: "getUser", Method: "POST", Route: "/api/user", Dependents: `[{"fullname":"GetUser","type":"torm"}]`},
+		},
+	})
+	require.ErrorIs(t, err, apifunc.ERROR_SOURCE_NOT_FOUND_IN_ENV)
+	require.Contains(t, err.Error(), "api:getUser torm:GetUser sourceId:user; env:prod, loaded sources:[log]")
+}
@@ -0,0 +1,310 @@
+package apifunc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/httpraw"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+	"github.com/tidwall/gjson"
+)
+
+// HttpSourceConfig CURL 资源配置，时间单位为秒(与 torm.CURLConfig 一致)
+type HttpSourceConfig struct {
+	BaseURL             string `json:"baseUrl"` // 服务地址，如 http://127.0.0.1:8080，配置后覆盖模板中的主机
+	Proxy               string `json:"proxy"`
+	LogLevel            string `json:"logLevel"`
+	Timeout             int    `json:"timeout"`     // 整个请求(含读取响应)超时，默认30秒
+	DialTimeout         int    `json:"dialTimeout"` // 建立连接超时，默认10秒
+	KeepAlive           int    `json:"keepAlive"`
+	MaxIdleConns        int    `json:"maxIdleConns"`
+	MaxIdleConnsPerHost int    `json:"maxIdleConnsPerHost"`
+	IdleConnTimeout     int    `json:"idleConnTimeout"`
+	MaxResponseSize     int64  `json:"maxResponseSize"` // 响应体最大字节数，默认 HTTP_MAX_BODY_SIZE
+}
+
+// HttpProvider CURL 资源提供者，torm 模板渲染出原始http请求文本，由其执行
+type HttpProvider struct {
+	config HttpSourceConfig
+	client *http.Client
+}
+
+func NewHttpProvider(config HttpSourceConfig) (provider *HttpProvider, err error) {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   secondOrDefault(config.DialTimeout, 10),
+			KeepAlive: secondOrDefault(config.KeepAlive, 300),
+		}).DialContext,
+		MaxIdleConns:        intOrDefault(config.MaxIdleConns, 200),
+		MaxIdleConnsPerHost: intOrDefault(config.MaxIdleConnsPerHost, 20),
+		IdleConnTimeout:     secondOrDefault(config.IdleConnTimeout, 90),
+	}
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			err = errors.WithMessagef(err, "proxy:%s", config.Proxy)
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = HTTP_MAX_BODY_SIZE
+	}
+	provider = &HttpProvider{
+		config: config,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   secondOrDefault(config.Timeout, 30),
+		},
+	}
+	return provider, nil
+}
+
+func secondOrDefault(second int, defaultSecond int) (d time.Duration) {
+	if second <= 0 {
+		second = defaultSecond
+	}
+	return time.Duration(second) * time.Second
+}
+
+func intOrDefault(i int, defaultI int) int {
+	if i <= 0 {
+		return defaultI
+	}
+	return i
+}
+
+func (p *HttpProvider) TypeName() string {
+	return "http_provider"
+}
+
+// Close 关闭空闲连接
+func (p *HttpProvider) Close() (err error) {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+// Do 执行原始http请求文本，非2xx状态返回错误，响应体为json时原样返回，否则编码为json字符串
+func (p *HttpProvider) Do(ctx context.Context, httpRaw string) (out []byte, err error) {
+	raw, err := httpraw.ReadRequest(httpRaw)
+	if err != nil {
+		err = errors.WithMessagef(err, "http raw:%s", httpRaw)
+		return nil, err
+	}
+	target, err := p.targetURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if raw.Body != nil {
+		b, err := io.ReadAll(raw.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, raw.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range raw.Header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Del("Content-Length") // 由 http.Client 根据body重新计算
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil { // 保留调用方取消、超时语义
+			err = errors.WithMessagef(ctx.Err(), "%s %s", req.Method, target)
+		}
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(rsp.Body, p.config.MaxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > p.config.MaxResponseSize {
+		err = errors.Errorf("%s %s: response body exceeds %d bytes", req.Method, target, p.config.MaxResponseSize)
+		return nil, err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		err = newHttpError(http.StatusBadGateway, errors.Errorf("%s %s: http status:%d,body:%s", req.Method, target, rsp.StatusCode, string(b)))
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || gjson.ValidBytes(b) {
+		return b, nil
+	}
+	return json.Marshal(string(b))
+}
+
+// targetURL 配置了 BaseURL 时替换请求地址的协议和主机(便于按环境切换服务地址)，否则使用请求行中的绝对地址或 Host 头
+func (p *HttpProvider) targetURL(raw *http.Request) (target string, err error) {
+	if p.config.BaseURL != "" {
+		base, err := url.Parse(p.config.BaseURL)
+		if err != nil {
+			err = errors.WithMessagef(err, "baseUrl:%s", p.config.BaseURL)
+			return "", err
+		}
+		return base.ResolveReference(&url.URL{Path: strings.TrimSuffix(base.Path, "/") + raw.URL.Path, RawQuery: raw.URL.RawQuery}).String(), nil
+	}
+	if raw.URL.IsAbs() {
+		return raw.URL.String(), nil
+	}
+	if raw.Host == "" {
+		err = errors.Errorf("http raw require absolute url, Host header or source baseUrl,got:%s", raw.URL.String())
+		return "", err
+	}
+	return (&url.URL{Scheme: "http", Host: raw.Host, Path: raw.URL.Path, RawQuery: raw.URL.RawQuery}).String(), nil
+}
+
+const (
+	PACKETHANDLER_NAME_CURL = "github.com/suifengpiao14/apifunc/_CurlPacketHandler"
+)
+
+// DefaultCURLTormFlows CURL 资源torm默认流程
+var DefaultCURLTormFlows = packethandler.Flow{
+	packet.PACKETHANDLER_NAME_TransferPacketHandler,
+	PACKETHANDLER_NAME_CURL,
+}
+
+type _CurlPacketHandler struct {
+	tor      torm.Torm
+	provider *HttpProvider
+}
+
+// NewCurlPacketHandler 渲染torm模板得到原始http请求并执行，输出响应体
+func NewCurlPacketHandler(tor torm.Torm, provider *HttpProvider) (packHandler packethandler.PacketHandlerI) {
+	return &_CurlPacketHandler{
+		tor:      tor,
+		provider: provider,
+	}
+}
+
+func (packet *_CurlPacketHandler) Name() string {
+	return PACKETHANDLER_NAME_CURL
+}
+
+func (packet *_CurlPacketHandler) Description() string {
+	return `渲染模板生成http请求并执行`
+}
+
+func (packet *_CurlPacketHandler) Before(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	statement, err := packet.Render(ctx, input)
+	if err != nil {
+		return ctx, nil, err
+	}
+	out, err = packet.provider.Do(ctx, statement.Statement)
+	if err != nil {
+		err = errors.WithMessagef(err, "torm:%s", packet.tor.TplName)
+		return ctx, nil, err
+	}
+	return ctx, out, nil
+}
+
+// Render 渲染得到原始http请求，试运行时不发送
+func (packet *_CurlPacketHandler) Render(ctx context.Context, input []byte) (statement ExplainStatement, err error) {
+	statement.Statement, err = renderTormText(packet.tor, input)
+	if err != nil {
+		return statement, err
+	}
+	return statement, nil
+}
+
+func (packet *_CurlPacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	return packethandler.EmptyHandlerFn(ctx, input)
+}
+
+func (packet *_CurlPacketHandler) String() string {
+	return ""
+}
+
+// renderTormText 使用入参渲染torm模板，得到原始文本(不生成SQL占位符)
+func renderTormText(tor torm.Torm, input []byte) (text string, err error) {
+	return renderTormTemplate(tor, tor.GetRootTemplate(), input)
+}
+
+// renderTormTemplate 使用指定模板集渲染torm，root 为 nil 表示模板未解析
+func renderTormTemplate(tor torm.Torm, root *template.Template, input []byte) (text string, err error) {
+	volume := torm.VolumeMap{}
+	if len(input) > 0 {
+		err = json.Unmarshal(input, &volume)
+		if err != nil {
+			err = errors.WithMessagef(err, "torm:%s input require json object,got:%s", tor.TplName, string(input))
+			return "", err
+		}
+	}
+	packet.ConvertFloatsToInt(volume)
+	if root == nil {
+		err = errors.Errorf("torm:%s template not parsed", tor.TplName)
+		return "", err
+	}
+	var w bytes.Buffer
+	err = root.ExecuteTemplate(&w, tor.TplName, volume)
+	if err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+type _CURLSourceDriver struct{}
+
+func (d *_CURLSourceDriver) Type() (sourceType string) {
+	return torm.SOURCE_TYPE_CURL
+}
+
+func (d *_CURLSourceDriver) MakeSource(sourceModel SourceModel) (source torm.Source, err error) {
+	config := HttpSourceConfig{}
+	if strings.TrimSpace(sourceModel.Config) != "" {
+		err = json.Unmarshal([]byte(sourceModel.Config), &config)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,config:%s", sourceModel.SourceID, sourceModel.Config)
+			return source, err
+		}
+	}
+	provider, err := NewHttpProvider(config)
+	if err != nil {
+		return source, err
+	}
+	source = torm.Source{
+		Identifer: sourceModel.SourceID,
+		Type:      sourceModel.SourceType,
+		Config:    sourceModel.Config,
+		Provider:  provider,
+	}
+	return source, nil
+}
+
+func (d *_CURLSourceDriver) DefaultFlow() (flow packethandler.Flow) {
+	return DefaultCURLTormFlows
+}
+
+func (d *_CURLSourceDriver) PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	provider, ok := tor.Source.Provider.(*HttpProvider)
+	if !ok {
+		err = errors.Errorf("torm:%s CURL source required *HttpProvider,got:%T", tor.TplName, tor.Source.Provider)
+		return nil, err
+	}
+	packetHandlers = packethandler.NewPacketHandlers(
+		NewTormTransferPacketHandler(tor),
+		NewCurlPacketHandler(tor, provider),
+	)
+	return packetHandlers, nil
+}
+
+func init() {
+	RegisterSourceDriver(&_CURLSourceDriver{})
+}
@@ -0,0 +1,83 @@
+package apifunc_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/tidwall/gjson"
+)
+
+// newCurlModelSet 通过 CURL 资源请求 baseURL 的用户详情接口
+func newCurlModelSet(baseURL string, tormPolicy string) (models apifunc.ModelSet) {
+	return apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "user", SourceType: "CURL", Config: fmt.Sprintf(`{"baseUrl":"%s"}`, baseURL)},
+		},
+		TormModels: apifunc.TormModels{
+			{
+				TemplateID: "GetUser",
+				SourceID:   "user",
+				Tpl:        "{{define \"GetUser\"}}GET /user?id={{.id}} HTTP/1.1\r\nHost: example.com\r\n\r\n{{end}}",
+				Policy:     apifunc.PolicyJson(tormPolicy),
+			},
+		},
+		ApiModels: apifunc.ApiModels{newApiModel("getUser", "/api/user", []string{"GetUser"}, "fullname=id,required")},
+	}
+}
+
+func TestCurlSource(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		switch id {
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"%s","name":"user-%s"}`, id, id)
+	}))
+	defer upstream.Close()
+
+	container := newContainer()
+	models := newCurlModelSet(upstream.URL, `{"timeout":"50ms"}`)
+	report := container.Validate(models)
+	require.False(t, report.HasError(), report.String())
+	_, err := container.Reload(models)
+	require.NoError(t, err)
+	server := httptest.NewServer(apifunc.NewHttpHandler(container))
+	defer server.Close()
+
+	post := func(id string) (status int, body []byte) {
+		rsp, err := http.Post(server.URL+"/api/user", "application/json", strings.NewReader(fmt.Sprintf(`{"id":"%s"}`, id)))
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		body, err = io.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		return rsp.StatusCode, body
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		status, body := post("1")
+		require.Equal(t, http.StatusOK, status, string(body))
+		require.Equal(t, "user-1", gjson.GetBytes(body, "name").String())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		status, body := post("slow")
+		require.Equal(t, http.StatusGatewayTimeout, status, string(body))
+	})
+
+	t.Run("upstream status", func(t *testing.T) {
+		status, body := post("missing")
+		require.Equal(t, http.StatusBadGateway, status, string(body))
+	})
+}
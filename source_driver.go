@@ -0,0 +1,206 @@
+package apifunc
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/pathtransfer"
+	"github.com/suifengpiao14/sqlexec"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+)
+
+// SourceDriverI 资源驱动，按资源类型(SourceModel.SourceType)初始化资源、生成torm处理器
+type SourceDriverI interface {
+	Type() (sourceType string)
+	MakeSource(sourceModel SourceModel) (source torm.Source, err error)
+	DefaultFlow() (flow packethandler.Flow)                                                // torm未配置flow时使用
+	PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) // 编译时为torm生成处理器
+}
+
+var ERROR_NOT_FOUND_SOURCE_DRIVER = errors.New("not found source driver")
+
+var sourceDrivers = struct {
+	mu      sync.RWMutex
+	drivers map[string]SourceDriverI
+}{drivers: make(map[string]SourceDriverI)}
+
+// RegisterSourceDriver 注册资源驱动，同类型后注册的覆盖先注册的
+func RegisterSourceDriver(drivers ...SourceDriverI) {
+	sourceDrivers.mu.Lock()
+	defer sourceDrivers.mu.Unlock()
+	for _, driver := range drivers {
+		sourceDrivers.drivers[strings.ToUpper(driver.Type())] = driver
+	}
+}
+
+// GetSourceDriver 获取资源类型对应的驱动
+func GetSourceDriver(sourceType string) (driver SourceDriverI, err error) {
+	sourceDrivers.mu.RLock()
+	defer sourceDrivers.mu.RUnlock()
+	driver, ok := sourceDrivers.drivers[strings.ToUpper(strings.TrimSpace(sourceType))]
+	if !ok {
+		err = errors.WithMessagef(ERROR_NOT_FOUND_SOURCE_DRIVER, "source type:%s", sourceType)
+		return nil, err
+	}
+	return driver, nil
+}
+
+func init() {
+	RegisterSourceDriver(&_SQLSourceDriver{})
+}
+
+// makeSource 使用对应驱动初始化资源
+func makeSource(sourceModel SourceModel) (source torm.Source, err error) {
+	driver, err := GetSourceDriver(sourceModel.SourceType)
+	if err != nil {
+		err = errors.WithMessagef(err, "source:%s", sourceModel.SourceID)
+		return source, err
+	}
+	return driver.MakeSource(sourceModel)
+}
+
+// sourcePool 容器创建的资源：配置(解析密钥后)未变更时重载复用同一实例，避免重复建立连接池、:memory: 库丢失数据；
+// 不再被任何版本引用时关闭
+type sourcePool struct {
+	mu      sync.Mutex
+	sources []pooledSource
+}
+
+type pooledSource struct {
+	model  SourceModel
+	source torm.Source
+}
+
+func newSourcePool() (pool *sourcePool) {
+	return &sourcePool{sources: make([]pooledSource, 0)}
+}
+
+// get 返回配置相同的已有资源，没有时新建
+func (pool *sourcePool) get(sourceModel SourceModel) (source torm.Source, err error) {
+	if pool == nil {
+		return makeSource(sourceModel)
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, pooled := range pool.sources {
+		if pooled.model == sourceModel {
+			return pooled.source, nil
+		}
+	}
+	source, err = makeSource(sourceModel)
+	if err != nil {
+		return source, err
+	}
+	pool.sources = append(pool.sources, pooledSource{model: sourceModel, source: source})
+	return source, nil
+}
+
+// release 关闭 live 之外的资源，关闭失败不影响版本发布，忽略错误
+func (pool *sourcePool) release(live map[torm.ProviderI]bool) {
+	if pool == nil {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	kept := pool.sources[:0]
+	for _, pooled := range pool.sources {
+		if !comparableProvider(pooled.source.Provider) || live[pooled.source.Provider] {
+			kept = append(kept, pooled)
+			continue
+		}
+		closeSource(pooled.source)
+	}
+	pool.sources = kept
+}
+
+// close 关闭全部资源
+func (pool *sourcePool) close() (err error) {
+	if pool == nil {
+		return nil
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for _, pooled := range pool.sources {
+		if closeErr := closeSource(pooled.source); closeErr != nil && err == nil {
+			err = errors.WithMessagef(closeErr, "close source:%s", pooled.source.Identifer)
+		}
+	}
+	pool.sources = pool.sources[:0]
+	return err
+}
+
+// comparableProvider 提供者可作为map key时才能判断是否仍被引用，否则不关闭
+func comparableProvider(provider torm.ProviderI) (ok bool) {
+	return provider != nil && reflect.TypeOf(provider).Comparable()
+}
+
+// closeSource 关闭资源提供者持有的连接：实现 io.Closer 的直接关闭，torm 内置SQL资源关闭其连接池
+func closeSource(source torm.Source) (err error) {
+	switch provider := source.Provider.(type) {
+	case io.Closer:
+		return provider.Close()
+	case *sqlexec.ExecutorSQL:
+		return provider.GetDB().Close()
+	}
+	return nil
+}
+
+// defaultTormFlow 资源类型对应的默认torm流程，未注册驱动时使用 DefaultTormFlows
+func defaultTormFlow(sourceType string) (flow packethandler.Flow) {
+	driver, err := GetSourceDriver(sourceType)
+	if err != nil {
+		return DefaultTormFlows
+	}
+	return driver.DefaultFlow()
+}
+
+type _SQLSourceDriver struct{}
+
+func (d *_SQLSourceDriver) Type() (sourceType string) {
+	return torm.SOURCE_TYPE_SQL
+}
+
+func (d *_SQLSourceDriver) MakeSource(sourceModel SourceModel) (source torm.Source, err error) {
+	return torm.MakeSource(sourceModel.SourceID, sourceModel.SourceType, sourceModel.Config, sourceModel.SSHConfig, sourceModel.DDL)
+}
+
+func (d *_SQLSourceDriver) DefaultFlow() (flow packethandler.Flow) {
+	return DefaultTormFlows
+}
+
+func (d *_SQLSourceDriver) PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	packetHandlers, err = packet.TormSQLPacketHandler(tor)
+	if err != nil {
+		return nil, err
+	}
+	dbProvider := tor.Source.Provider.(*sqlexec.ExecutorSQL) // TormSQLPacketHandler 已校验
+	db := dbProvider.GetDB()
+	database, err := sqlexec.GetDatabaseName(db)
+	if err != nil {
+		return nil, err
+	}
+	packetHandlers.AddReplace(NewTormRenderPacketHandler(tor))
+	packetHandlers.AddReplace(NewCUDEventPacketHandler(db, database, tor.Source.Identifer))
+	packetHandlers.AddReplace(NewSQLPacketHandler(db, tor.Source.Identifer))
+	return packetHandlers, nil
+}
+
+// NewTormTransferPacketHandler torm入参、出参转换处理器(与SQL资源的转换规则一致)，供非SQL资源驱动使用
+func NewTormTransferPacketHandler(tor torm.Torm) (packetHandler packethandler.PacketHandlerI) {
+	tormName := string(pathtransfer.JoinPath(pathtransfer.Transfer_Top_Namespace_Torm, tor.Name()))
+	inputPathTransfers, outputPathTransfers := tor.Transfers.GetByNamespace(tormName).SplitInOut()
+	namespaceInput := string(pathtransfer.JoinPath(tormName, pathtransfer.Transfer_Direction_input))
+	namespaceOutput := string(pathtransfer.JoinPath(tormName, pathtransfer.Transfer_Direction_output))
+	inputGopath := inputPathTransfers.Reverse().ModifyDstPath(func(path pathtransfer.Path) (newPath pathtransfer.Path) {
+		return path.TrimNamespace(namespaceInput)
+	}).GjsonPath()
+	outputGopath := outputPathTransfers.ModifySrcPath(func(path pathtransfer.Path) (newPath pathtransfer.Path) {
+		return path.TrimNamespace(namespaceOutput)
+	}).GjsonPath()
+	return packet.NewTransferPacketHandler(inputGopath, outputGopath)
+}
@@ -0,0 +1,299 @@
+package apifunc
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+)
+
+const (
+	SOURCE_TYPE_FIXTURE = "FIXTURE"
+)
+
+const (
+	FIXTURE_MATCH_EXACT      = "exact"
+	FIXTURE_MATCH_NORMALIZED = "normalized" // 忽略多余空白、关键字大小写、末尾分号
+)
+
+var ERROR_FIXTURE_NOT_MATCHED = errors.New("fixture not matched")
+
+// Fixture SQL 与预置结果的对应关系，Result 为执行结果(查询为json数组，增删改与mysql执行结果格式一致)
+type Fixture struct {
+	SQL    string          `json:"sql"`
+	Match  string          `json:"match"` // exact、normalized，默认 normalized
+	Result json.RawMessage `json:"result"`
+	Err    string          `json:"err"` // 不为空时返回该错误，用于模拟执行失败
+}
+
+// FixtureSourceConfig 预置结果资源配置，fixtures 与 files(json数组文件) 合并使用
+type FixtureSourceConfig struct {
+	Fixtures []Fixture `json:"fixtures"`
+	Files    []string  `json:"files"`
+}
+
+// FixtureStatement 执行过的语句
+type FixtureStatement struct {
+	SQL     string `json:"sql"`
+	Matched bool   `json:"matched"`
+}
+
+// FixtureProvider 预置结果资源提供者，按渲染后的SQL返回预置结果，并记录所有执行过的语句，用于离线测试api
+type FixtureProvider struct {
+	mu         sync.Mutex
+	fixtures   []Fixture
+	statements []FixtureStatement
+}
+
+func NewFixtureProvider(fixtures ...Fixture) (provider *FixtureProvider) {
+	provider = &FixtureProvider{}
+	provider.Add(fixtures...)
+	return provider
+}
+
+func (p *FixtureProvider) TypeName() string {
+	return "fixture_provider"
+}
+
+// Add 增加预置结果，后增加的优先匹配
+func (p *FixtureProvider) Add(fixtures ...Fixture) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fixtures = append(p.fixtures, fixtures...)
+}
+
+// Statements 按执行顺序返回执行过的语句
+func (p *FixtureProvider) Statements() (statements []FixtureStatement) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	statements = make([]FixtureStatement, len(p.statements))
+	copy(statements, p.statements)
+	return statements
+}
+
+// Reset 清空执行记录
+func (p *FixtureProvider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statements = nil
+}
+
+// ExecOrQueryContext 查找与sql匹配的预置结果，未匹配时返回 ERROR_FIXTURE_NOT_MATCHED
+func (p *FixtureProvider) ExecOrQueryContext(ctx context.Context, sql string) (out string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fixture, ok := p.match(sql)
+	p.statements = append(p.statements, FixtureStatement{SQL: sql, Matched: ok})
+	if !ok {
+		err = errors.WithMessagef(ERROR_FIXTURE_NOT_MATCHED, "sql:%s,normalized:%s", sql, NormalizeSQL(sql))
+		return "", err
+	}
+	if fixture.Err != "" {
+		return "", errors.New(fixture.Err)
+	}
+	return string(fixture.Result), nil
+}
+
+// BeginTx 预置结果不支持真正回滚，事务内语句照常匹配，BEGIN/COMMIT/ROLLBACK 记录在执行语句中便于断言
+func (p *FixtureProvider) BeginTx(ctx context.Context) (tx TxI, err error) {
+	p.record("BEGIN")
+	return &_FixtureTx{provider: p}, nil
+}
+
+func (p *FixtureProvider) record(sql string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.statements = append(p.statements, FixtureStatement{SQL: sql, Matched: true})
+}
+
+type _FixtureTx struct {
+	provider *FixtureProvider
+}
+
+func (tx *_FixtureTx) ExecOrQueryContext(ctx context.Context, sql string) (out string, err error) {
+	return tx.provider.ExecOrQueryContext(ctx, sql)
+}
+
+func (tx *_FixtureTx) Commit() (err error) {
+	tx.provider.record("COMMIT")
+	return nil
+}
+
+func (tx *_FixtureTx) Rollback() (err error) {
+	tx.provider.record("ROLLBACK")
+	return nil
+}
+
+func (p *FixtureProvider) match(sql string) (fixture Fixture, ok bool) {
+	normalized := NormalizeSQL(sql)
+	for i := len(p.fixtures) - 1; i >= 0; i-- {
+		fixture = p.fixtures[i]
+		switch strings.ToLower(fixture.Match) {
+		case FIXTURE_MATCH_EXACT:
+			ok = fixture.SQL == sql
+		default:
+			ok = NormalizeSQL(fixture.SQL) == normalized
+		}
+		if ok {
+			return fixture, true
+		}
+	}
+	return fixture, false
+}
+
+// NormalizeSQL 合并连续空白、去除括号逗号及比较符两侧空白、引号外转小写、去除末尾分号
+func NormalizeSQL(sql string) (normalized string) {
+	var w strings.Builder
+	w.Grow(len(sql))
+	var quote rune
+	var last rune // 最后写入的字符
+	pendingSpace := false
+	isTight := func(r rune) bool {
+		return strings.ContainsRune("(),=<>!", r)
+	}
+	for _, r := range sql {
+		if quote != 0 {
+			w.WriteRune(r)
+			last = r
+			if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			pendingSpace = true
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		}
+		if pendingSpace && w.Len() > 0 && !isTight(r) && !isTight(last) {
+			w.WriteRune(' ')
+		}
+		pendingSpace = false
+		if quote == 0 {
+			r = unicode.ToLower(r)
+		}
+		w.WriteRune(r)
+		last = r
+	}
+	normalized = strings.TrimSpace(w.String())
+	return strings.TrimSpace(strings.TrimRight(normalized, "; "))
+}
+
+const (
+	PACKETHANDLER_NAME_FIXTURE = "github.com/suifengpiao14/apifunc/_FixturePacketHandler"
+)
+
+// DefaultFixtureTormFlows 预置结果资源torm默认流程
+var DefaultFixtureTormFlows = packethandler.Flow{
+	packet.PACKETHANDLER_NAME_TransferPacketHandler,
+	packet.PACKETHANDLER_NAME_TormPackHandler,
+	PACKETHANDLER_NAME_FIXTURE,
+}
+
+type _FixturePacketHandler struct {
+	provider *FixtureProvider
+}
+
+// NewFixturePacketHandler 使用预置结果代替数据库执行SQL
+func NewFixturePacketHandler(provider *FixtureProvider) (packHandler packethandler.PacketHandlerI) {
+	return &_FixturePacketHandler{
+		provider: provider,
+	}
+}
+
+func (packet *_FixturePacketHandler) Name() string {
+	return PACKETHANDLER_NAME_FIXTURE
+}
+
+func (packet *_FixturePacketHandler) Description() string {
+	return `按SQL返回预置结果，记录执行语句`
+}
+
+func (packet *_FixturePacketHandler) Before(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	recordSQLTables(ctx, string(input))
+	data, err := packet.provider.ExecOrQueryContext(ctx, string(input))
+	if err != nil {
+		return ctx, nil, err
+	}
+	return ctx, []byte(data), nil
+}
+
+func (packet *_FixturePacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	return ctx, input, nil
+}
+
+func (packet *_FixturePacketHandler) String() string {
+	return ""
+}
+
+type _FixtureSourceDriver struct{}
+
+func (d *_FixtureSourceDriver) Type() (sourceType string) {
+	return SOURCE_TYPE_FIXTURE
+}
+
+func (d *_FixtureSourceDriver) MakeSource(sourceModel SourceModel) (source torm.Source, err error) {
+	config := FixtureSourceConfig{}
+	if strings.TrimSpace(sourceModel.Config) != "" {
+		err = json.Unmarshal([]byte(sourceModel.Config), &config)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,config:%s", sourceModel.SourceID, sourceModel.Config)
+			return source, err
+		}
+	}
+	provider := NewFixtureProvider()
+	for _, filename := range config.Files {
+		b, err := os.ReadFile(filename)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s", sourceModel.SourceID)
+			return source, err
+		}
+		fixtures := make([]Fixture, 0)
+		err = json.Unmarshal(b, &fixtures)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,file:%s", sourceModel.SourceID, filename)
+			return source, err
+		}
+		provider.Add(fixtures...)
+	}
+	provider.Add(config.Fixtures...)
+	source = torm.Source{
+		Identifer: sourceModel.SourceID,
+		Type:      sourceModel.SourceType,
+		Config:    sourceModel.Config,
+		DDL:       sourceModel.DDL,
+		Provider:  provider,
+	}
+	return source, nil
+}
+
+func (d *_FixtureSourceDriver) DefaultFlow() (flow packethandler.Flow) {
+	return DefaultFixtureTormFlows
+}
+
+func (d *_FixtureSourceDriver) PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	provider, ok := tor.Source.Provider.(*FixtureProvider)
+	if !ok {
+		err = errors.Errorf("torm:%s FIXTURE source required *FixtureProvider,got:%T", tor.TplName, tor.Source.Provider)
+		return nil, err
+	}
+	packetHandlers = packethandler.NewPacketHandlers(
+		NewTormTransferPacketHandler(tor),
+		NewTormRenderPacketHandler(tor),
+		NewFixturePacketHandler(provider),
+	)
+	return packetHandlers, nil
+}
+
+func init() {
+	RegisterSourceDriver(&_FixtureSourceDriver{})
+}
@@ -0,0 +1,59 @@
+package apifunc_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestNormalizeSQL(t *testing.T) {
+	require.Equal(t,
+		"select * from t_user where id=1 and name='A  B' limit 0,20",
+		apifunc.NormalizeSQL("SELECT *\n\tFROM t_user WHERE id = 1 AND name='A  B' LIMIT 0, 20 ;"),
+	)
+}
+
+func TestFixtureSource(t *testing.T) {
+	container := newContainer()
+	models := apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{
+				SourceID:   "user",
+				SourceType: apifunc.SOURCE_TYPE_FIXTURE,
+				Config: `{"fixtures":[
+					{"sql":"SELECT * FROM t_user WHERE id = 1;","result":[{"id":"1","name":"fixture"}]},
+					{"sql":"select * from t_user where id=2;","match":"exact","result":[{"id":"2","name":"exact"}]}
+				]}`,
+			},
+		},
+		TormModels: apifunc.TormModels{
+			{
+				TemplateID: "GetUser",
+				SourceID:   "user",
+				Tpl:        `{{define "GetUser"}}select * from t_user where id=:id;{{end}}`,
+			},
+		},
+		ApiModels: apifunc.ApiModels{newApiModel("getUser", "/api/user", []string{"GetUser"}, "fullname=id,type=integer,required")},
+	}
+	_, err := container.Reload(models)
+	require.NoError(t, err)
+	ctxApiFunc, err := container.GetContextApiFunc("/api/user", "POST")
+	require.NoError(t, err)
+
+	require.JSONEq(t, `[{"id":"1","name":"fixture"}]`, runApi(t, container, "/api/user", `{"id":1}`))
+	require.JSONEq(t, `[{"id":"2","name":"exact"}]`, runApi(t, container, "/api/user", `{"id":2}`))
+	_, err = apifunc.RunApiFunc(ctxApiFunc, []byte(`{"id":3}`))
+	require.True(t, errors.Is(err, apifunc.ERROR_FIXTURE_NOT_MATCHED))
+
+	source, err := container.GetSource("user")
+	require.NoError(t, err)
+	provider, ok := source.Provider.(*apifunc.FixtureProvider)
+	require.True(t, ok)
+	statements := provider.Statements()
+	require.Len(t, statements, 3)
+	require.Equal(t, "select * from t_user where id=1;", statements[0].SQL)
+	require.True(t, statements[0].Matched)
+	require.False(t, statements[2].Matched)
+}
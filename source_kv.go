@@ -0,0 +1,797 @@
+package apifunc
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+	"github.com/tidwall/gjson"
+)
+
+// KVI 键值存储，命令及返回值与redis一致，返回值为 nil、string、int64、[]any 之一
+type KVI interface {
+	Do(ctx context.Context, args ...string) (reply any, err error)
+}
+
+// KVSourceConfig REDIS 资源配置，embedded 为 true 时使用进程内存储(用于测试)，否则 addr 必填
+type KVSourceConfig struct {
+	Addr        string `json:"addr"`
+	Password    string `json:"password"`
+	DB          int    `json:"db"`
+	Embedded    bool   `json:"embedded"`
+	PoolSize    int    `json:"poolSize"`    // 最大空闲连接数，默认10
+	DialTimeout int    `json:"dialTimeout"` // 秒，默认5秒
+}
+
+// KVProvider 键值资源提供者
+type KVProvider struct {
+	kv KVI
+}
+
+func NewKVProvider(kv KVI) (provider *KVProvider) {
+	return &KVProvider{kv: kv}
+}
+
+func (p *KVProvider) TypeName() string {
+	return "kv_provider"
+}
+
+func (p *KVProvider) KV() (kv KVI) {
+	return p.kv
+}
+
+// Close 存储实现 io.Closer 时关闭，容器在资源不再被引用时调用
+func (p *KVProvider) Close() (err error) {
+	if closer, ok := p.kv.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Exec 逐行执行命令，单条命令返回其结果，多条命令返回结果数组
+func (p *KVProvider) Exec(ctx context.Context, commands string) (out []byte, err error) {
+	results := make([]json.RawMessage, 0)
+	for _, line := range strings.Split(commands, "\n") {
+		args, err := splitCommandArgs(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		reply, err := p.kv.Do(ctx, args...)
+		if err != nil {
+			err = errors.WithMessagef(err, "command:%s", strings.Join(args, " "))
+			return nil, err
+		}
+		result, err := kvReplyToJson(strings.ToUpper(args[0]), reply)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	switch len(results) {
+	case 0:
+		return nil, nil
+	case 1:
+		return results[0], nil
+	}
+	return json.Marshal(results)
+}
+
+// kvReplyToJson 字符串为合法json时原样输出(缓存中一般存json)，HGETALL 转换为对象
+func kvReplyToJson(command string, reply any) (out json.RawMessage, err error) {
+	switch v := reply.(type) {
+	case nil:
+		return json.RawMessage("null"), nil
+	case string:
+		if gjson.Valid(v) {
+			return json.RawMessage(v), nil
+		}
+		return json.Marshal(v)
+	case []any:
+		if command == "HGETALL" {
+			m := make(map[string]json.RawMessage, len(v)/2)
+			for i := 0; i+1 < len(v); i += 2 {
+				m[fmt.Sprint(v[i])], err = kvReplyToJson("", v[i+1])
+				if err != nil {
+					return nil, err
+				}
+			}
+			return json.Marshal(m)
+		}
+		arr := make([]json.RawMessage, 0, len(v))
+		for _, item := range v {
+			raw, err := kvReplyToJson("", item)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, raw)
+		}
+		return json.Marshal(arr)
+	}
+	return json.Marshal(reply)
+}
+
+// splitCommandArgs 按空白拆分命令参数，支持单引号、双引号包裹含空白的参数，kvArgEscape 编码的模板值整体并入当前参数
+func splitCommandArgs(line string) (args []string, err error) {
+	args = make([]string, 0)
+	var w strings.Builder
+	var quote byte
+	inArg := false
+	line = strings.TrimSpace(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == KV_ARG_MARK {
+			end := strings.IndexByte(line[i+1:], KV_ARG_MARK)
+			if end < 0 {
+				err = errors.Errorf("unclosed template value in command:%s", line)
+				return nil, err
+			}
+			value, err := hex.DecodeString(line[i+1 : i+1+end])
+			if err != nil {
+				return nil, err
+			}
+			w.Write(value)
+			inArg = true
+			i += end + 1
+			continue
+		}
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+				continue
+			}
+			w.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\r':
+			if inArg {
+				args = append(args, w.String())
+				w.Reset()
+				inArg = false
+			}
+		default:
+			w.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		err = errors.Errorf("unclosed quote in command:%s", line)
+		return nil, err
+	}
+	if inArg {
+		args = append(args, w.String())
+	}
+	return args, nil
+}
+
+var ERROR_KV_WRONG_TYPE = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+type memoryKVItem struct {
+	value    any // string 或 map[string]string
+	expireAt time.Time
+}
+
+// MemoryKV 进程内键值存储，支持 GET、SET(EX/PX/NX/XX)、DEL、EXISTS、INCR、INCRBY、DECR、EXPIRE、TTL、HGET、HSET、HGETALL、HDEL
+type MemoryKV struct {
+	mu    sync.Mutex
+	items map[string]*memoryKVItem
+	now   func() time.Time
+}
+
+func NewMemoryKV() (kv *MemoryKV) {
+	return &MemoryKV{items: make(map[string]*memoryKVItem), now: time.Now}
+}
+
+func (kv *MemoryKV) get(key string) (item *memoryKVItem) {
+	item, ok := kv.items[key]
+	if !ok {
+		return nil
+	}
+	if !item.expireAt.IsZero() && !kv.now().Before(item.expireAt) {
+		delete(kv.items, key)
+		return nil
+	}
+	return item
+}
+
+func (kv *MemoryKV) Do(ctx context.Context, args ...string) (reply any, err error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	command := strings.ToUpper(args[0])
+	wrongArgs := errors.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+	switch command {
+	case "GET":
+		if len(args) != 2 {
+			return nil, wrongArgs
+		}
+		item := kv.get(args[1])
+		if item == nil {
+			return nil, nil
+		}
+		s, ok := item.value.(string)
+		if !ok {
+			return nil, ERROR_KV_WRONG_TYPE
+		}
+		return s, nil
+	case "SET":
+		if len(args) < 3 {
+			return nil, wrongArgs
+		}
+		var ttl time.Duration
+		nx, xx := false, false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "EX", "PX":
+				if i+1 >= len(args) {
+					return nil, errors.New("ERR syntax error")
+				}
+				n, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || n <= 0 {
+					return nil, errors.New("ERR invalid expire time in 'set' command")
+				}
+				ttl = time.Duration(n) * time.Second
+				if strings.ToUpper(args[i]) == "PX" {
+					ttl = time.Duration(n) * time.Millisecond
+				}
+				i++
+			default:
+				return nil, errors.New("ERR syntax error")
+			}
+		}
+		exists := kv.get(args[1]) != nil
+		if (nx && exists) || (xx && !exists) {
+			return nil, nil
+		}
+		item := &memoryKVItem{value: args[2]}
+		if ttl > 0 {
+			item.expireAt = kv.now().Add(ttl)
+		}
+		kv.items[args[1]] = item
+		return "OK", nil
+	case "DEL", "EXISTS":
+		if len(args) < 2 {
+			return nil, wrongArgs
+		}
+		var n int64
+		for _, key := range args[1:] {
+			if kv.get(key) != nil {
+				n++
+				if command == "DEL" {
+					delete(kv.items, key)
+				}
+			}
+		}
+		return n, nil
+	case "INCR", "DECR", "INCRBY":
+		delta := int64(1)
+		switch {
+		case command == "INCRBY" && len(args) == 3:
+			delta, err = strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				return nil, errors.New("ERR value is not an integer or out of range")
+			}
+		case command != "INCRBY" && len(args) == 2:
+			if command == "DECR" {
+				delta = -1
+			}
+		default:
+			return nil, wrongArgs
+		}
+		item := kv.get(args[1])
+		if item == nil {
+			item = &memoryKVItem{value: "0"}
+			kv.items[args[1]] = item
+		}
+		s, ok := item.value.(string)
+		if !ok {
+			return nil, ERROR_KV_WRONG_TYPE
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		n += delta
+		item.value = strconv.FormatInt(n, 10)
+		return n, nil
+	case "EXPIRE":
+		if len(args) != 3 {
+			return nil, wrongArgs
+		}
+		seconds, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		item := kv.get(args[1])
+		if item == nil {
+			return int64(0), nil
+		}
+		item.expireAt = kv.now().Add(time.Duration(seconds) * time.Second)
+		return int64(1), nil
+	case "TTL":
+		if len(args) != 2 {
+			return nil, wrongArgs
+		}
+		item := kv.get(args[1])
+		switch {
+		case item == nil:
+			return int64(-2), nil
+		case item.expireAt.IsZero():
+			return int64(-1), nil
+		}
+		return int64(item.expireAt.Sub(kv.now()).Round(time.Second) / time.Second), nil
+	case "HGET", "HSET", "HGETALL", "HDEL":
+		return kv.doHash(command, args, wrongArgs)
+	}
+	return nil, errors.Errorf("ERR unknown command '%s'", args[0])
+}
+
+func (kv *MemoryKV) doHash(command string, args []string, wrongArgs error) (reply any, err error) {
+	if len(args) < 2 {
+		return nil, wrongArgs
+	}
+	var hash map[string]string
+	item := kv.get(args[1])
+	if item != nil {
+		var ok bool
+		hash, ok = item.value.(map[string]string)
+		if !ok {
+			return nil, ERROR_KV_WRONG_TYPE
+		}
+	}
+	switch command {
+	case "HGET":
+		if len(args) != 3 {
+			return nil, wrongArgs
+		}
+		value, ok := hash[args[2]]
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	case "HSET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return nil, wrongArgs
+		}
+		if item == nil {
+			hash = make(map[string]string)
+			kv.items[args[1]] = &memoryKVItem{value: hash}
+		}
+		var n int64
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := hash[args[i]]; !ok {
+				n++
+			}
+			hash[args[i]] = args[i+1]
+		}
+		return n, nil
+	case "HDEL":
+		if len(args) < 3 {
+			return nil, wrongArgs
+		}
+		var n int64
+		for _, field := range args[2:] {
+			if _, ok := hash[field]; ok {
+				delete(hash, field)
+				n++
+			}
+		}
+		return n, nil
+	}
+	if len(args) != 2 {
+		return nil, wrongArgs
+	}
+	reply2 := make([]any, 0, len(hash)*2)
+	for field, value := range hash {
+		reply2 = append(reply2, field, value)
+	}
+	return reply2, nil
+}
+
+// RedisKV 基于RESP协议的redis客户端，连接按需创建，空闲连接复用
+type RedisKV struct {
+	config KVSourceConfig
+	idle   chan net.Conn
+	closed atomic.Bool
+}
+
+func NewRedisKV(config KVSourceConfig) (kv *RedisKV) {
+	return &RedisKV{
+		config: config,
+		idle:   make(chan net.Conn, intOrDefault(config.PoolSize, 10)),
+	}
+}
+
+func (kv *RedisKV) conn(ctx context.Context) (conn net.Conn, err error) {
+	select {
+	case conn = <-kv.idle:
+		return conn, nil
+	default:
+	}
+	dialer := net.Dialer{Timeout: secondOrDefault(kv.config.DialTimeout, 5)}
+	conn, err = dialer.DialContext(ctx, "tcp", kv.config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	setup := make([][]string, 0, 2)
+	if kv.config.Password != "" {
+		setup = append(setup, []string{"AUTH", kv.config.Password})
+	}
+	if kv.config.DB > 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(kv.config.DB)})
+	}
+	r := bufio.NewReader(conn)
+	for _, args := range setup {
+		_, err = roundTripRESP(conn, r, args)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (kv *RedisKV) Do(ctx context.Context, args ...string) (reply any, err error) {
+	conn, err := kv.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+	reply, err = roundTripRESP(conn, bufio.NewReader(conn), args)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) { // 网络错误，连接不再复用
+		conn.Close()
+		if ctx.Err() != nil {
+			err = errors.WithMessage(ctx.Err(), err.Error())
+		}
+		return nil, err
+	}
+	select {
+	case kv.idle <- conn:
+	default:
+		conn.Close()
+	}
+	if kv.closed.Load() {
+		kv.drain()
+	}
+	return reply, err
+}
+
+// Close 关闭空闲连接，之后归还的连接直接关闭
+func (kv *RedisKV) Close() (err error) {
+	kv.closed.Store(true)
+	kv.drain()
+	return nil
+}
+
+func (kv *RedisKV) drain() {
+	for {
+		select {
+		case conn := <-kv.idle:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func roundTripRESP(w io.Writer, r *bufio.Reader, args []string) (reply any, err error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err = io.WriteString(w, b.String())
+	if err != nil {
+		return nil, err
+	}
+	return readRESP(r)
+}
+
+func readRESP(r *bufio.Reader) (reply any, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty resp line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]any, 0, n)
+		var firstErr error
+		for i := 0; i < n; i++ {
+			item, err := readRESP(r)
+			if err != nil {
+				var redisErr redisError
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			arr = append(arr, item)
+		}
+		return arr, firstErr
+	}
+	return nil, errors.Errorf("unknown resp reply:%s", line)
+}
+
+const (
+	PACKETHANDLER_NAME_KV = "github.com/suifengpiao14/apifunc/_KVPacketHandler"
+)
+
+// DefaultKVTormFlows 键值资源torm默认流程
+var DefaultKVTormFlows = packethandler.Flow{
+	packet.PACKETHANDLER_NAME_TransferPacketHandler,
+	PACKETHANDLER_NAME_KV,
+}
+
+// KV_ARG_MARK 包裹编码后的模板值，模板文本中不会出现
+const KV_ARG_MARK = '\x00'
+
+const kvArgFuncName = "_kvArg"
+
+// kvArgEscape 模板输出值十六进制编码并用 KV_ARG_MARK 包裹，拆分命令时整体作为一个参数(或参数的一部分)，入参无法注入新命令或参数
+func kvArgEscape(args ...any) string {
+	value := ""
+	if v := args[len(args)-1]; v != nil {
+		value = fmt.Sprint(v)
+	}
+	return string(KV_ARG_MARK) + hex.EncodeToString([]byte(value)) + string(KV_ARG_MARK)
+}
+
+// escapeKVTemplate 复制模板集，所有输出动作末尾追加 kvArgEscape，原模板不受影响
+func escapeKVTemplate(root *template.Template) (escaped *template.Template, err error) {
+	escaped, err = root.Clone()
+	if err != nil {
+		return nil, err
+	}
+	escaped.Funcs(template.FuncMap{kvArgFuncName: kvArgEscape})
+	for _, tpl := range escaped.Templates() {
+		if tpl.Tree == nil {
+			continue
+		}
+		tree := tpl.Tree.Copy()
+		escapeKVNode(tree, tree.Root)
+		_, err = escaped.AddParseTree(tpl.Name(), tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return escaped, nil
+}
+
+func escapeKVNode(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeKVNode(tree, child)
+		}
+	case *parse.ActionNode:
+		if len(n.Pipe.Decl) > 0 { // 变量声明不输出
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(kvArgFuncName).SetTree(tree).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		escapeKVNode(tree, n.List)
+		escapeKVNode(tree, n.ElseList)
+	case *parse.RangeNode:
+		escapeKVNode(tree, n.List)
+		escapeKVNode(tree, n.ElseList)
+	case *parse.WithNode:
+		escapeKVNode(tree, n.List)
+		escapeKVNode(tree, n.ElseList)
+	}
+}
+
+type _KVPacketHandler struct {
+	tor      torm.Torm
+	provider *KVProvider
+	once     sync.Once
+	root     *template.Template
+	rootErr  error
+}
+
+// NewKVPacketHandler 渲染torm模板得到命令(每行一条)并执行，模板值始终作为单个参数发送
+func NewKVPacketHandler(tor torm.Torm, provider *KVProvider) (packHandler packethandler.PacketHandlerI) {
+	return &_KVPacketHandler{
+		tor:      tor,
+		provider: provider,
+	}
+}
+
+func (packet *_KVPacketHandler) template() (root *template.Template, err error) {
+	packet.once.Do(func() {
+		root := packet.tor.GetRootTemplate()
+		if root == nil {
+			return
+		}
+		packet.root, packet.rootErr = escapeKVTemplate(root)
+	})
+	return packet.root, packet.rootErr
+}
+
+func (packet *_KVPacketHandler) Name() string {
+	return PACKETHANDLER_NAME_KV
+}
+
+func (packet *_KVPacketHandler) Description() string {
+	return `渲染模板生成键值命令并执行`
+}
+
+func (packet *_KVPacketHandler) Before(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	commands, err := packet.render(input)
+	if err != nil {
+		return ctx, nil, err
+	}
+	out, err = packet.provider.Exec(ctx, commands)
+	if err != nil {
+		err = errors.WithMessagef(err, "torm:%s", packet.tor.TplName)
+		return ctx, nil, err
+	}
+	return ctx, out, nil
+}
+
+// render 渲染得到命令，模板值为编码形式
+func (packet *_KVPacketHandler) render(input []byte) (commands string, err error) {
+	root, err := packet.template()
+	if err != nil {
+		err = errors.WithMessagef(err, "torm:%s", packet.tor.TplName)
+		return "", err
+	}
+	return renderTormTemplate(packet.tor, root, input)
+}
+
+// Render 渲染得到命令(模板值解码，含空白、引号的参数加引号)，试运行时不执行
+func (packet *_KVPacketHandler) Render(ctx context.Context, input []byte) (statement ExplainStatement, err error) {
+	commands, err := packet.render(input)
+	if err != nil {
+		return statement, err
+	}
+	lines := make([]string, 0)
+	for _, line := range strings.Split(commands, "\n") {
+		args, err := splitCommandArgs(line)
+		if err != nil {
+			return statement, err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		for i, arg := range args {
+			if arg == "" || strings.ContainsAny(arg, " \t\r\n'\"\\") {
+				args[i] = strconv.Quote(arg)
+			}
+		}
+		lines = append(lines, strings.Join(args, " "))
+	}
+	statement.Statement = strings.Join(lines, "\n")
+	return statement, nil
+}
+
+func (packet *_KVPacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	return packethandler.EmptyHandlerFn(ctx, input)
+}
+
+func (packet *_KVPacketHandler) String() string {
+	return ""
+}
+
+type _KVSourceDriver struct{}
+
+func (d *_KVSourceDriver) Type() (sourceType string) {
+	return torm.SOURCE_TYPE_REDIS
+}
+
+func (d *_KVSourceDriver) MakeSource(sourceModel SourceModel) (source torm.Source, err error) {
+	config := KVSourceConfig{}
+	if strings.TrimSpace(sourceModel.Config) != "" {
+		err = json.Unmarshal([]byte(sourceModel.Config), &config)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,config:%s", sourceModel.SourceID, sourceModel.Config)
+			return source, err
+		}
+	}
+	var kv KVI
+	switch {
+	case config.Embedded:
+		kv = NewMemoryKV()
+	case config.Addr == "":
+		err = errors.Errorf("source:%s redis addr required,set embedded:true to use in-process kv", sourceModel.SourceID)
+		return source, err
+	default:
+		kv = NewRedisKV(config)
+	}
+	source = torm.Source{
+		Identifer: sourceModel.SourceID,
+		Type:      sourceModel.SourceType,
+		Config:    sourceModel.Config,
+		Provider:  NewKVProvider(kv),
+	}
+	return source, nil
+}
+
+func (d *_KVSourceDriver) DefaultFlow() (flow packethandler.Flow) {
+	return DefaultKVTormFlows
+}
+
+func (d *_KVSourceDriver) PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	provider, ok := tor.Source.Provider.(*KVProvider)
+	if !ok {
+		err = errors.Errorf("torm:%s REDIS source required *KVProvider,got:%T", tor.TplName, tor.Source.Provider)
+		return nil, err
+	}
+	packetHandlers = packethandler.NewPacketHandlers(
+		NewTormTransferPacketHandler(tor),
+		NewKVPacketHandler(tor, provider),
+	)
+	return packetHandlers, nil
+}
+
+func init() {
+	RegisterSourceDriver(&_KVSourceDriver{})
+}
@@ -0,0 +1,139 @@
+package apifunc_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestKVSource(t *testing.T) {
+	container := newContainer()
+	apiModel := func(id string, torm string) (model apifunc.ApiModel) {
+		return newApiModel(id, "/api/"+id, []string{torm})
+	}
+	models := apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "cache", SourceType: "REDIS", Config: `{"embedded":true}`},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "SetUser", SourceID: "cache", Tpl: `{{define "SetUser"}}SET user:{{.id}} '{"id":"{{.id}}","name":"{{.name}}"}' PX 100{{end}}`},
+			{TemplateID: "GetUser", SourceID: "cache", Tpl: `{{define "GetUser"}}GET user:{{.id}}{{end}}`},
+			{TemplateID: "Visit", SourceID: "cache", Tpl: "{{define \"Visit\"}}\nINCR visit:{{.id}}\nEXPIRE visit:{{.id}} 60\nHSET visitor:{{.id}} last {{.name}}\nHGETALL visitor:{{.id}}\n{{end}}"},
+		},
+		ApiModels: apifunc.ApiModels{apiModel("setUser", "SetUser"), apiModel("getUser", "GetUser"), apiModel("visit", "Visit")},
+	}
+	_, err := container.Reload(models)
+	require.NoError(t, err)
+	run := func(route string, input string) string {
+		return runApi(t, container, route, input)
+	}
+
+	require.Equal(t, `"OK"`, run("/api/setUser", `{"id":1,"name":"kv"}`))
+	require.JSONEq(t, `{"id":"1","name":"kv"}`, run("/api/getUser", `{"id":1}`))
+	require.JSONEq(t, `[1,1,1,{"last":"a"}]`, run("/api/visit", `{"id":1,"name":"a"}`))
+	require.JSONEq(t, `[2,1,0,{"last":"b"}]`, run("/api/visit", `{"id":1,"name":"b"}`))
+	time.Sleep(150 * time.Millisecond)
+	require.Equal(t, `null`, run("/api/getUser", `{"id":1}`)) // 已过期
+
+	// 入参始终作为单个参数，不能拆出新参数或新命令
+	require.JSONEq(t, `[1,1,1,{"last":"x y\nFLUSHALL 'z'"}]`, run("/api/visit", `{"id":2,"name":"x y\nFLUSHALL 'z'"}`))
+	require.JSONEq(t, `[1,1,1,{"last":""}]`, run("/api/visit", `{"id":3,"name":""}`))
+	require.Equal(t, `"OK"`, run("/api/setUser", `{"id":2,"name":"a\\\" b"}`))
+	require.JSONEq(t, `{"id":"2","name":"a\" b"}`, run("/api/getUser", `{"id":2}`))
+}
+
+func TestKVSourceAddrRequired(t *testing.T) {
+	_, err := newContainer().Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{{SourceID: "cache", SourceType: "REDIS", Config: `{}`}},
+	})
+	require.ErrorContains(t, err, "redis addr required")
+}
+
+// serveRESP 使用 MemoryKV 模拟redis服务
+func serveRESP(t *testing.T, kv apifunc.KVI) (addr string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						r.ReadString('\n')
+						arg, _ := r.ReadString('\n')
+						args[i] = strings.TrimSuffix(arg, "\r\n")
+					}
+					reply, err := kv.Do(context.Background(), args...)
+					writeRESP(conn, reply, err)
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func writeRESP(w io.Writer, reply any, err error) {
+	if err != nil {
+		fmt.Fprintf(w, "-%s\r\n", err.Error())
+		return
+	}
+	switch v := reply.(type) {
+	case nil:
+		io.WriteString(w, "$-1\r\n")
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeRESP(w, item, nil)
+		}
+	}
+}
+
+func TestRedisKV(t *testing.T) {
+	addr := serveRESP(t, apifunc.NewMemoryKV())
+	kv := apifunc.NewRedisKV(apifunc.KVSourceConfig{Addr: addr})
+	ctx := context.Background()
+	reply, err := kv.Do(ctx, "SET", "k", "hello world")
+	require.NoError(t, err)
+	require.Equal(t, "OK", reply)
+	reply, err = kv.Do(ctx, "GET", "k")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", reply)
+	reply, err = kv.Do(ctx, "GET", "missing")
+	require.NoError(t, err)
+	require.Nil(t, reply)
+	reply, err = kv.Do(ctx, "INCR", "n")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), reply)
+	_, err = kv.Do(ctx, "INCR", "k")
+	require.ErrorContains(t, err, "not an integer")
+	_, err = kv.Do(ctx, "HSET", "h", "a", "1")
+	require.NoError(t, err)
+	reply, err = kv.Do(ctx, "HGETALL", "h")
+	require.NoError(t, err)
+	require.Equal(t, []any{"a", "1"}, reply)
+}
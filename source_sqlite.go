@@ -0,0 +1,173 @@
+package apifunc
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	SOURCE_TYPE_SQLITE = "SQLITE" // 驱动位于 sqlitesource 包(依赖cgo)，使用时导入该包注册
+)
+
+// SQLiteSourceConfig SQLite 资源配置，本地开发时代替MySQL资源
+type SQLiteSourceConfig struct {
+	DSN  string `json:"dsn"`  // 文件路径或 :memory:，默认 :memory:
+	Seed string `json:"seed"` // 新建库时在DDL之后执行的初始化数据语句
+}
+
+// SplitSQLStatements 按分号拆分多条语句，忽略引号内的分号
+func SplitSQLStatements(sqls string) (stmts []string) {
+	stmts = make([]string, 0)
+	var w strings.Builder
+	var quote rune
+	for _, r := range sqls {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == ';':
+			if stmt := strings.TrimSpace(w.String()); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			w.Reset()
+			continue
+		}
+		w.WriteRune(r)
+	}
+	if stmt := strings.TrimSpace(w.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// splitTopLevel 按不在括号、引号内的逗号拆分
+func splitTopLevel(s string) (parts []string) {
+	parts = make([]string, 0)
+	var w strings.Builder
+	var quote rune
+	depth := 0
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(w.String()))
+			w.Reset()
+			continue
+		}
+		w.WriteRune(r)
+	}
+	if part := strings.TrimSpace(w.String()); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+var (
+	createTableRegexp      = regexp.MustCompile(`(?is)^create\s+table\s+(if\s+not\s+exists\s+)?([^\s(]+)\s*\((.*)\)[^)]*$`)
+	columnTypeRegexp       = regexp.MustCompile(`(?i)^(\w+)\s*(\([^)]*\))?`)
+	columnCommentRegexp    = regexp.MustCompile(`(?i)\bcomment\s+'(?:[^'\\]|\\.|'')*'`)
+	columnOnUpdateRegexp   = regexp.MustCompile(`(?i)\bon\s+update\s+current_timestamp(\s*\(\d*\))?`)
+	columnCharsetRegexp    = regexp.MustCompile(`(?i)\b(character\s+set|charset|collate)\s+\w+`)
+	columnUnsignedRegexp   = regexp.MustCompile(`(?i)\b(unsigned|zerofill)\b`)
+	columnAutoIncRegexp    = regexp.MustCompile(`(?i)\bauto_increment\b`)
+	currentTimestampRegexp = regexp.MustCompile(`(?i)\bcurrent_timestamp\s*\(\d*\)`)
+	uniqueKeyRegexp        = regexp.MustCompile(`(?is)^unique\s+(key|index)?\s*([^\s(]+\s*)?(\(.*\))`)
+)
+
+// MysqlDDLToSQLite 将MySQL建表语句转换为SQLite可执行的语句(尽力转换)，只保留建表语句并补充 if not exists；
+// 普通索引、表选项、字段注释等SQLite不支持的部分被忽略
+func MysqlDDLToSQLite(ddl string) (stmts []string) {
+	stmts = make([]string, 0)
+	for _, stmt := range SplitSQLStatements(ddl) {
+		m := createTableRegexp.FindStringSubmatch(stmt)
+		if m == nil {
+			continue
+		}
+		tableName := m[2]
+		columns := make([]string, 0)
+		constraints := make([]string, 0)
+		hasAutoIncrement := false
+		for _, def := range splitTopLevel(m[3]) {
+			lower := strings.ToLower(def)
+			switch {
+			case strings.HasPrefix(lower, "primary key"):
+				constraints = append(constraints, def[:strings.LastIndex(def, ")")+1])
+			case strings.HasPrefix(lower, "unique"):
+				if um := uniqueKeyRegexp.FindStringSubmatch(def); um != nil {
+					constraints = append(constraints, "UNIQUE "+um[3])
+				}
+			case strings.HasPrefix(lower, "key"), strings.HasPrefix(lower, "index"), strings.HasPrefix(lower, "fulltext"),
+				strings.HasPrefix(lower, "spatial"), strings.HasPrefix(lower, "constraint"), strings.HasPrefix(lower, "foreign key"):
+				continue
+			default:
+				column, autoIncrement := mysqlColumnToSQLite(def)
+				hasAutoIncrement = hasAutoIncrement || autoIncrement
+				columns = append(columns, column)
+			}
+		}
+		if hasAutoIncrement { // 自增列已声明为主键
+			filtered := make([]string, 0, len(constraints))
+			for _, constraint := range constraints {
+				if !strings.HasPrefix(strings.ToLower(constraint), "primary key") {
+					filtered = append(filtered, constraint)
+				}
+			}
+			constraints = filtered
+		}
+		stmts = append(stmts, "CREATE TABLE IF NOT EXISTS "+tableName+" (\n  "+strings.Join(append(columns, constraints...), ",\n  ")+"\n)")
+	}
+	return stmts
+}
+
+func mysqlColumnToSQLite(def string) (column string, autoIncrement bool) {
+	name := def
+	rest := ""
+	if strings.HasPrefix(def, "`") {
+		if end := strings.Index(def[1:], "`"); end >= 0 {
+			name, rest = def[:end+2], strings.TrimSpace(def[end+2:])
+		}
+	} else if i := strings.IndexAny(def, " \t\n"); i > 0 {
+		name, rest = def[:i], strings.TrimSpace(def[i:])
+	}
+	typ := "TEXT"
+	if m := columnTypeRegexp.FindStringSubmatch(rest); m != nil {
+		typ = sqliteColumnType(m[1])
+		rest = rest[len(m[0]):]
+	}
+	autoIncrement = columnAutoIncRegexp.MatchString(rest)
+	for _, re := range []*regexp.Regexp{columnCommentRegexp, columnOnUpdateRegexp, columnCharsetRegexp, columnUnsignedRegexp, columnAutoIncRegexp} {
+		rest = re.ReplaceAllString(rest, "")
+	}
+	rest = currentTimestampRegexp.ReplaceAllString(rest, "CURRENT_TIMESTAMP")
+	rest = strings.Join(strings.Fields(rest), " ")
+	if autoIncrement {
+		return strings.TrimSpace(name + " INTEGER PRIMARY KEY AUTOINCREMENT"), true
+	}
+	return strings.TrimSpace(name + " " + typ + " " + rest), false
+}
+
+func sqliteColumnType(mysqlType string) (typ string) {
+	switch strings.ToLower(mysqlType) {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "bit", "bool", "boolean", "year":
+		return "INTEGER"
+	case "decimal", "numeric":
+		return "NUMERIC"
+	case "float", "double", "real":
+		return "REAL"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "BLOB"
+	}
+	return "TEXT"
+}
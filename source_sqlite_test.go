@@ -0,0 +1,27 @@
+package apifunc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestMysqlDDLToSQLite(t *testing.T) {
+	ddl := "CREATE TABLE `t_user` (\n" +
+		"  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'id',\n" +
+		"  `name` varchar(64) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '' COMMENT '名称,姓名',\n" +
+		"  `updated_at` datetime(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),\n" +
+		"  PRIMARY KEY (`id`),\n" +
+		"  UNIQUE KEY `uk_name` (`name`),\n" +
+		"  KEY `idx_updated_at` (`updated_at`)\n" +
+		") ENGINE=InnoDB AUTO_INCREMENT=3 DEFAULT CHARSET=utf8mb4 COMMENT='用户';\n" +
+		"SET FOREIGN_KEY_CHECKS = 1;"
+	stmts := apifunc.MysqlDDLToSQLite(ddl)
+	require.Equal(t, []string{"CREATE TABLE IF NOT EXISTS `t_user` (\n" +
+		"  `id` INTEGER PRIMARY KEY AUTOINCREMENT,\n" +
+		"  `name` TEXT NOT NULL DEFAULT '',\n" +
+		"  `updated_at` TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"  UNIQUE (`name`)\n" +
+		")"}, stmts)
+}
@@ -0,0 +1,141 @@
+// Package sqlitesource SQLite 资源驱动(依赖cgo)，导入后注册 apifunc.SOURCE_TYPE_SQLITE 类型，本地开发时代替MySQL资源：
+//
+//	import _ "github.com/suifengpiao14/apifunc/sqlitesource"
+package sqlitesource
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+)
+
+// SQLiteProvider SQLite 资源提供者
+type SQLiteProvider struct {
+	config apifunc.SQLiteSourceConfig
+	db     *sql.DB
+}
+
+// NewSQLiteProvider 打开数据库，库中没有表时执行DDL(MySQL语法自动转换)及初始化数据；已有表时仅补充缺少的表
+func NewSQLiteProvider(config apifunc.SQLiteSourceConfig, ddl string) (provider *SQLiteProvider, err error) {
+	if config.DSN == "" {
+		config.DSN = ":memory:"
+	}
+	db, err := sql.Open("sqlite3", config.DSN)
+	if err != nil {
+		err = errors.WithMessagef(err, "dsn:%s", config.DSN)
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // :memory: 每个连接是独立的库，且SQLite写操作本身串行
+	provider = &SQLiteProvider{config: config, db: db}
+	err = provider.seed(ddl)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (p *SQLiteProvider) TypeName() string {
+	return "sqlite_provider"
+}
+
+func (p *SQLiteProvider) GetDB() (db *sql.DB) {
+	return p.db
+}
+
+func (p *SQLiteProvider) Close() (err error) {
+	return p.db.Close()
+}
+
+func (p *SQLiteProvider) seed(ddl string) (err error) {
+	var tableCount int
+	err = p.db.QueryRow(`select count(*) from sqlite_master where type='table'`).Scan(&tableCount)
+	if err != nil {
+		err = errors.WithMessagef(err, "dsn:%s", p.config.DSN)
+		return err
+	}
+	for _, stmt := range apifunc.MysqlDDLToSQLite(ddl) {
+		_, err = p.db.Exec(stmt)
+		if err != nil {
+			err = errors.WithMessagef(err, "dsn:%s,ddl:%s", p.config.DSN, stmt)
+			return err
+		}
+	}
+	if tableCount > 0 || strings.TrimSpace(p.config.Seed) == "" {
+		return nil
+	}
+	for _, stmt := range apifunc.SplitSQLStatements(p.config.Seed) {
+		_, err = p.db.Exec(stmt)
+		if err != nil {
+			err = errors.WithMessagef(err, "dsn:%s,seed:%s", p.config.DSN, stmt)
+			return err
+		}
+	}
+	return nil
+}
+
+// DefaultSQLiteTormFlows SQLite 资源torm默认流程(不含依赖MySQL的 CUDEvent)
+var DefaultSQLiteTormFlows = packethandler.Flow{
+	packet.PACKETHANDLER_NAME_TransferPacketHandler,
+	packet.PACKETHANDLER_NAME_TormPackHandler,
+	packet.PACKETHANDLER_NAME_MysqlPacketHandler,
+}
+
+type _SQLiteSourceDriver struct{}
+
+func (d *_SQLiteSourceDriver) Type() (sourceType string) {
+	return apifunc.SOURCE_TYPE_SQLITE
+}
+
+func (d *_SQLiteSourceDriver) MakeSource(sourceModel apifunc.SourceModel) (source torm.Source, err error) {
+	config := apifunc.SQLiteSourceConfig{}
+	if strings.TrimSpace(sourceModel.Config) != "" {
+		err = json.Unmarshal([]byte(sourceModel.Config), &config)
+		if err != nil {
+			err = errors.WithMessagef(err, "source:%s,config:%s", sourceModel.SourceID, sourceModel.Config)
+			return source, err
+		}
+	}
+	provider, err := NewSQLiteProvider(config, sourceModel.DDL)
+	if err != nil {
+		err = errors.WithMessagef(err, "source:%s", sourceModel.SourceID)
+		return source, err
+	}
+	source = torm.Source{
+		Identifer: sourceModel.SourceID,
+		Type:      sourceModel.SourceType,
+		Config:    sourceModel.Config,
+		DDL:       sourceModel.DDL,
+		Provider:  provider,
+	}
+	return source, nil
+}
+
+func (d *_SQLiteSourceDriver) DefaultFlow() (flow packethandler.Flow) {
+	return DefaultSQLiteTormFlows
+}
+
+func (d *_SQLiteSourceDriver) PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	provider, ok := tor.Source.Provider.(*SQLiteProvider)
+	if !ok {
+		err = errors.Errorf("torm:%s SQLITE source required *SQLiteProvider,got:%T", tor.TplName, tor.Source.Provider)
+		return nil, err
+	}
+	packetHandlers = packethandler.NewPacketHandlers(
+		apifunc.NewTormTransferPacketHandler(tor),
+		apifunc.NewTormRenderPacketHandler(tor),
+		apifunc.NewSQLPacketHandler(provider.GetDB(), tor.Source.Identifer),
+	)
+	return packetHandlers, nil
+}
+
+func init() {
+	apifunc.RegisterSourceDriver(&_SQLiteSourceDriver{})
+}
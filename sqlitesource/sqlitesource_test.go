@@ -0,0 +1,91 @@
+package sqlitesource_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/apifunc/sqlitesource"
+	"github.com/suifengpiao14/logchan/v2"
+	"github.com/tidwall/gjson"
+)
+
+func TestSQLiteSource(t *testing.T) {
+	container := apifunc.NewContainer(func(logInfo logchan.LogInforInterface, typeName logchan.LogName, err error) {})
+	models := apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{
+				SourceID:   "user",
+				SourceType: apifunc.SOURCE_TYPE_SQLITE,
+				Config:     `{"dsn":":memory:","seed":"insert into t_user (name) values ('seed');"}`,
+				DDL:        "CREATE TABLE `t_user` (`id` int(11) NOT NULL AUTO_INCREMENT, `name` varchar(64) NOT NULL DEFAULT '', PRIMARY KEY (`id`)) ENGINE=InnoDB;",
+			},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "InsertUser", SourceID: "user", Tpl: `{{define "InsertUser"}}insert into t_user (name) values (:name);{{end}}`},
+			{TemplateID: "ListUser", SourceID: "user", Tpl: "{{define \"ListUser\"}}select * from `t_user` order by id limit 0,10;{{end}}"},
+		},
+		ApiModels: apifunc.ApiModels{
+			{
+				ApiId:        "insertUser",
+				Method:       "POST",
+				Route:        "/api/user/insert",
+				Dependents:   `[{"fullname":"InsertUser","type":"torm"}]`,
+				InputSchema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input\nfullname=name,required",
+				OutputSchema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+			},
+			{
+				ApiId:        "listUser",
+				Method:       "POST",
+				Route:        "/api/user/list",
+				Dependents:   `[{"fullname":"ListUser","type":"torm"}]`,
+				InputSchema:  "version=http://json-schema.org/draft-07/schema#,direction=in,id=input",
+				OutputSchema: "version=http://json-schema.org/draft-07/schema#,direction=out,id=out",
+			},
+		},
+	}
+	require.False(t, container.Validate(models).HasError())
+	_, err := container.Reload(models)
+	require.NoError(t, err)
+
+	insertApi, err := container.GetContextApiFunc("/api/user/insert", "POST")
+	require.NoError(t, err)
+	out, err := apifunc.RunApiFunc(insertApi, []byte(`{"name":"apifunc"}`))
+	require.NoError(t, err)
+	require.JSONEq(t, `["2"]`, string(out))
+
+	listApi, err := container.GetContextApiFunc("/api/user/list", "POST")
+	require.NoError(t, err)
+	out, err = apifunc.RunApiFunc(listApi, []byte(`{}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"seed", "apifunc"}, []string{gjson.GetBytes(out, "0.name").String(), gjson.GetBytes(out, "1.name").String()})
+
+	// 配置未变更的资源重载时复用，:memory: 库数据保留
+	source, err := container.GetSource("user")
+	require.NoError(t, err)
+	_, err = container.Reload(models)
+	require.NoError(t, err)
+	reloaded, err := container.GetSource("user")
+	require.NoError(t, err)
+	require.Same(t, source.Provider, reloaded.Provider)
+	listApi, err = container.GetContextApiFunc("/api/user/list", "POST")
+	require.NoError(t, err)
+	out, err = apifunc.RunApiFunc(listApi, []byte(`{}`))
+	require.NoError(t, err)
+	require.Equal(t, "apifunc", gjson.GetBytes(out, "1.name").String())
+
+	// 配置变更后旧资源随版本移出历史记录时关闭
+	db := source.Provider.(*sqlitesource.SQLiteProvider).GetDB()
+	for i := 0; i < apifunc.SNAPSHOT_HISTORY_SIZE; i++ {
+		models.SourceModels[0].Config = fmt.Sprintf(`{"dsn":":memory:","seed":"insert into t_user (name) values ('seed%d');"}`, i)
+		_, err = container.Reload(models)
+		require.NoError(t, err)
+		require.NoError(t, db.Ping(), i)
+	}
+	models.SourceModels[0].Config = `{"dsn":":memory:"}`
+	_, err = container.Reload(models)
+	require.NoError(t, err)
+	require.Error(t, db.Ping())
+	require.NoError(t, container.Close())
+}
@@ -0,0 +1,127 @@
+package apifunc
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/suifengpiao14/torm"
+)
+
+// RunTormOptions 并发执行torm的选项
+type RunTormOptions struct {
+	Concurrency int  // 最大并发数，<=0 时等于torm数量
+	CollectAll  bool // true: 所有torm执行完毕后汇总错误; false: 首个错误即返回，并取消其余torm
+}
+
+// TormError 单个torm的执行错误
+type TormError struct {
+	TplName string
+	Err     error
+}
+
+func (e *TormError) Error() string {
+	return "torm:" + e.TplName + ": " + e.Err.Error()
+}
+
+func (e *TormError) Unwrap() error {
+	return e.Err
+}
+
+// TormErrors CollectAll 模式下汇总的错误，按torm声明顺序排列
+type TormErrors []*TormError
+
+func (es TormErrors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (es TormErrors) Unwrap() []error {
+	errs := make([]error, 0, len(es))
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// ApiHandlerRunTormConcurrentFn 内置并发运行多个相互独立的torm，输出仍按torm声明顺序合并，结果与 ApiHandlerRunTormFn 一致
+func ApiHandlerRunTormConcurrentFn(options RunTormOptions, tors ...torm.Torm) (logicHandler BusinessFlowFn) {
+	logicHandler = func(ctx *ContextApiFunc, input []byte) (out []byte, err error) {
+		outputArr, err := runTormsConcurrent(ctx, options, tors, input)
+		if err != nil {
+			return nil, err
+		}
+		return mergeTormOutputs(outputArr)
+	}
+	return logicHandler
+}
+
+// runTormsConcurrent 使用固定数量的worker执行torm，失败即停模式下首个错误会取消其余torm(包括执行中的)
+func runTormsConcurrent(ctx *ContextApiFunc, options RunTormOptions, tors torm.Torms, input []byte) (outputArr [][]byte, err error) {
+	outputArr = make([][]byte, len(tors))
+	errArr := make([]error, len(tors))
+	workers := options.Concurrency
+	if workers <= 0 || workers > len(tors) {
+		workers = len(tors)
+	}
+	if transactionFromContext(ctx) != nil { // 事务共用一个连接，不能并发执行
+		workers = 1
+	}
+	sub, cancel := context.WithCancel(ctx.Context())
+	defer cancel()
+	subCtx := ctx.WithContext(sub)
+
+	var once sync.Once
+	var firstErr error
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outputArr[i], errArr[i] = runTormBySourceType(subCtx, tors[i], input)
+				if errArr[i] != nil && !options.CollectAll {
+					once.Do(func() {
+						firstErr = &TormError{TplName: tors[i].TplName, Err: errArr[i]}
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+dispatch:
+	for i := range tors {
+		if options.CollectAll {
+			jobs <- i
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-sub.Done(): // 已失败或调用方已取消，不再派发
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	tormErrs := make(TormErrors, 0)
+	for i, e := range errArr {
+		if e != nil {
+			tormErrs = append(tormErrs, &TormError{TplName: tors[i].TplName, Err: e})
+		}
+	}
+	if len(tormErrs) > 0 {
+		return nil, tormErrs
+	}
+	if err = ctx.Err(); err != nil { // 调用方取消时可能有torm未派发
+		return nil, err
+	}
+	return outputArr, nil
+}
@@ -0,0 +1,144 @@
+package apifunc_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/torm"
+)
+
+func newSleepTorm(name string, delay time.Duration, out string, err error, running *int32, maxRunning *int32) torm.Torm {
+	handler := packethandler.NewFuncPacketHandler(name, func(ctx context.Context, input []byte) (newCtx context.Context, out2 []byte, err2 error) {
+		n := atomic.AddInt32(running, 1)
+		defer atomic.AddInt32(running, -1)
+		for {
+			m := atomic.LoadInt32(maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(maxRunning, m, n) {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx, nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if err != nil {
+			return ctx, nil, err
+		}
+		return ctx, []byte(out), nil
+	}, nil)
+	return torm.Torm{
+		TplName:        name,
+		Source:         torm.Source{Type: torm.SOURCE_TYPE_SQL},
+		Flow:           packethandler.Flow{name},
+		PacketHandlers: packethandler.NewPacketHandlers(handler),
+	}
+}
+
+type fakeTxProvider struct{}
+
+func (p fakeTxProvider) TypeName() string {
+	return "fake_tx_provider"
+}
+
+func (p fakeTxProvider) BeginTx(ctx context.Context) (tx apifunc.TxI, err error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) ExecOrQueryContext(ctx context.Context, sql string) (out string, err error) {
+	return "", nil
+}
+
+func (tx fakeTx) Commit() (err error) {
+	return nil
+}
+
+func (tx fakeTx) Rollback() (err error) {
+	return nil
+}
+
+func TestApiHandlerRunTormConcurrentFn(t *testing.T) {
+	ctxApiFunc := apifunc.NewContextApiFunc(apifunc.Api{}, nil, apifunc.Project{})
+
+	t.Run("merge in declared order", func(t *testing.T) {
+		var running, maxRunning int32
+		tors := []torm.Torm{
+			newSleepTorm("a", 30*time.Millisecond, `{"a":1,"v":"a"}`, nil, &running, &maxRunning),
+			newSleepTorm("b", 10*time.Millisecond, `{"b":2,"v":"b"}`, nil, &running, &maxRunning),
+			newSleepTorm("c", 20*time.Millisecond, `{"c":3,"v":"c"}`, nil, &running, &maxRunning),
+		}
+		out, err := apifunc.ApiHandlerRunTormConcurrentFn(apifunc.RunTormOptions{Concurrency: 2}, tors...)(ctxApiFunc, []byte(`{}`))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"a":1,"b":2,"c":3,"v":"c"}`, string(out))
+		require.Equal(t, int32(2), maxRunning)
+
+		seqOut, err := apifunc.ApiHandlerRunTormFn(tors...)(ctxApiFunc, []byte(`{}`))
+		require.NoError(t, err)
+		require.JSONEq(t, string(seqOut), string(out))
+	})
+
+	t.Run("serial in transaction", func(t *testing.T) {
+		var running, maxRunning int32
+		tors := []torm.Torm{
+			newSleepTorm("a", 10*time.Millisecond, `{"a":1}`, nil, &running, &maxRunning),
+			newSleepTorm("b", 10*time.Millisecond, `{"b":2}`, nil, &running, &maxRunning),
+		}
+		for i := range tors {
+			tors[i].Source.Identifer, tors[i].Source.Provider = "db", fakeTxProvider{}
+		}
+		txCtxApiFunc := apifunc.NewContextApiFunc(apifunc.Api{}, tors, apifunc.Project{})
+		err := txCtxApiFunc.Transaction("db", func(txCtx *apifunc.ContextApiFunc) (err error) {
+			out, err := apifunc.ApiHandlerRunTormConcurrentFn(apifunc.RunTormOptions{}, tors...)(txCtx, []byte(`{}`))
+			if err != nil {
+				return err
+			}
+			require.JSONEq(t, `{"a":1,"b":2}`, string(out))
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, int32(1), maxRunning)
+	})
+
+	t.Run("fail fast cancels siblings", func(t *testing.T) {
+		var running, maxRunning int32
+		failErr := errors.New("boom")
+		tors := []torm.Torm{
+			newSleepTorm("slow", time.Second, `{}`, nil, &running, &maxRunning),
+			newSleepTorm("fail", 10*time.Millisecond, ``, failErr, &running, &maxRunning),
+			newSleepTorm("queued", time.Second, `{}`, nil, &running, &maxRunning),
+		}
+		start := time.Now()
+		_, err := apifunc.ApiHandlerRunTormConcurrentFn(apifunc.RunTormOptions{Concurrency: 2}, tors...)(ctxApiFunc, []byte(`{}`))
+		require.True(t, errors.Is(err, failErr))
+		var tormErr *apifunc.TormError
+		require.True(t, errors.As(err, &tormErr))
+		require.Equal(t, "fail", tormErr.TplName)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("collect all", func(t *testing.T) {
+		var running, maxRunning int32
+		errA, errC := errors.New("a failed"), errors.New("c failed")
+		tors := []torm.Torm{
+			newSleepTorm("a", 20*time.Millisecond, ``, errA, &running, &maxRunning),
+			newSleepTorm("b", 10*time.Millisecond, `{"b":2}`, nil, &running, &maxRunning),
+			newSleepTorm("c", 0, ``, errC, &running, &maxRunning),
+		}
+		_, err := apifunc.ApiHandlerRunTormConcurrentFn(apifunc.RunTormOptions{CollectAll: true}, tors...)(ctxApiFunc, []byte(`{}`))
+		var tormErrs apifunc.TormErrors
+		require.True(t, errors.As(err, &tormErrs))
+		require.Len(t, tormErrs, 2)
+		require.Equal(t, "a", tormErrs[0].TplName)
+		require.Equal(t, "c", tormErrs[1].TplName)
+		require.True(t, errors.Is(err, errA))
+		require.True(t, errors.Is(err, errC))
+	})
+}
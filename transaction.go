@@ -0,0 +1,430 @@
+package apifunc
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/cudevent/cudeventimpl"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/sqlexec"
+	"github.com/suifengpiao14/sqlplus"
+	"github.com/suifengpiao14/stream/packet"
+	"github.com/suifengpiao14/torm"
+)
+
+var (
+	ERROR_TRANSACTION_SOURCE_MISMATCH = errors.New("torm source mismatch transaction source")
+	ERROR_TRANSACTION_NOT_SUPPORTED   = errors.New("source not support transaction")
+)
+
+// TxI 资源事务
+type TxI interface {
+	ExecOrQueryContext(ctx context.Context, sql string) (out string, err error)
+	Commit() (err error)
+	Rollback() (err error)
+}
+
+// TxBeginnerI 支持事务的资源提供者；提供 GetDB() *sql.DB 的资源(SQL、SQLITE)无需实现，直接使用数据库事务
+type TxBeginnerI interface {
+	BeginTx(ctx context.Context) (tx TxI, err error)
+}
+
+type transactionKey struct{}
+
+// transaction 进行中的事务，事务内写过的表在提交后使缓存失效，增改删事件在提交后发布
+type transaction struct {
+	sourceId    string
+	tx          TxI
+	mu          sync.Mutex
+	writeTables []string
+	cudEvents   []*cudeventimpl.SQLRawEvent
+}
+
+func (t *transaction) addCUDEvent(event *cudeventimpl.SQLRawEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cudEvents = append(t.cudEvents, event)
+}
+
+func (t *transaction) addWriteTables(tables ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeTables = append(t.writeTables, tables...)
+}
+
+func transactionFromContext(ctx context.Context) (t *transaction) {
+	t, _ = ctx.Value(transactionKey{}).(*transaction)
+	return t
+}
+
+// checkTransactionSource 事务内只能执行同一资源的torm
+func checkTransactionSource(ctx context.Context, tor torm.Torm) (err error) {
+	t := transactionFromContext(ctx)
+	if t == nil || strings.EqualFold(t.sourceId, tor.Source.Identifer) {
+		return nil
+	}
+	err = errors.WithMessagef(ERROR_TRANSACTION_SOURCE_MISMATCH, "transaction source:%s,torm:%s,source:%s", t.sourceId, tor.TplName, tor.Source.Identifer)
+	return err
+}
+
+// Transaction 在 sourceId 对应资源上开启事务，fn 内通过 txCtx 执行的torm均在事务内；
+// fn 返回错误或 panic 时回滚，否则提交；已在同一资源事务中时直接加入，不同资源返回 ERROR_TRANSACTION_SOURCE_MISMATCH；试运行时不开启事务
+func (ctxApiFunc *ContextApiFunc) Transaction(sourceId string, fn func(txCtx *ContextApiFunc) (err error)) (err error) {
+	if current := transactionFromContext(ctxApiFunc); current != nil {
+		if !strings.EqualFold(current.sourceId, sourceId) {
+			err = errors.WithMessagef(ERROR_TRANSACTION_SOURCE_MISMATCH, "transaction source:%s,nested source:%s", current.sourceId, sourceId)
+			return err
+		}
+		return fn(ctxApiFunc)
+	}
+	source, err := ctxApiFunc.getSource(sourceId)
+	if err != nil {
+		return err
+	}
+	if explainFromContext(ctxApiFunc) != nil { // 试运行不访问资源，不开启事务
+		t := &transaction{sourceId: source.Identifer}
+		return fn(ctxApiFunc.WithContext(context.WithValue(ctxApiFunc.Context(), transactionKey{}, t)))
+	}
+	tx, err := beginTx(ctxApiFunc, source)
+	if err != nil {
+		return err
+	}
+	t := &transaction{sourceId: source.Identifer, tx: tx}
+	txCtx := ctxApiFunc.WithContext(context.WithValue(ctxApiFunc.Context(), transactionKey{}, t))
+	finished := false
+	defer func() {
+		if !finished { // fn panic
+			tx.Rollback()
+		}
+	}()
+	err = fn(txCtx)
+	finished = true
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			err = errors.WithMessagef(err, "rollback:%s", rollbackErr.Error())
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		err = errors.WithMessagef(err, "commit source:%s", sourceId)
+		return err
+	}
+	if ctxApiFunc._Cache != nil && len(t.writeTables) > 0 {
+		ctxApiFunc._Cache.InvalidateTables(t.sourceId, t.writeTables...)
+	}
+	for _, event := range t.cudEvents { // 回滚时事件随事务丢弃
+		err = cudeventimpl.PublishSQLRawEvent(event)
+		if err != nil {
+			err = errors.WithMessagef(err, "transaction committed,publish cud event source:%s,sql:%s", sourceId, event.SQL)
+			return err
+		}
+	}
+	return nil
+}
+
+func (ctxApiFunc *ContextApiFunc) getSource(sourceId string) (source torm.Source, err error) {
+	for _, tor := range ctxApiFunc._Torms {
+		if strings.EqualFold(tor.Source.Identifer, sourceId) {
+			return tor.Source, nil
+		}
+	}
+	err = errors.Errorf("not found source by sourceId:%s", sourceId)
+	return source, err
+}
+
+func beginTx(ctx context.Context, source torm.Source) (tx TxI, err error) {
+	switch provider := source.Provider.(type) {
+	case TxBeginnerI:
+		return provider.BeginTx(ctx)
+	case interface{ GetDB() *sql.DB }:
+		sqlTx, err := provider.GetDB().BeginTx(ctx, nil)
+		if err != nil {
+			err = errors.WithMessagef(err, "begin transaction source:%s", source.Identifer)
+			return nil, err
+		}
+		return newSQLTx(sqlTx), nil
+	}
+	err = errors.WithMessagef(ERROR_TRANSACTION_NOT_SUPPORTED, "source:%s,type:%s", source.Identifer, source.Type)
+	return nil, err
+}
+
+type _SQLTx struct {
+	tx *sql.Tx
+	db *sql.DB // 绑定事务的连接池，sqlexec 只接受 *sql.DB
+}
+
+func newSQLTx(tx *sql.Tx) (t *_SQLTx) {
+	db := sql.OpenDB(&_txConnector{tx: tx})
+	db.SetMaxOpenConns(1)
+	return &_SQLTx{tx: tx, db: db}
+}
+
+func (t *_SQLTx) Commit() (err error) {
+	defer t.db.Close()
+	return t.tx.Commit()
+}
+
+func (t *_SQLTx) Rollback() (err error) {
+	defer t.db.Close()
+	err = t.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
+
+// ExecOrQueryContext 事务内交由 sqlexec 执行，结果格式、日志与非事务一致；
+// 语句前加事务注释，避免 sqlexec 按SQL合并查询时与事务外的相同查询共享结果
+func (t *_SQLTx) ExecOrQueryContext(ctx context.Context, sqls string) (out string, err error) {
+	return sqlexec.ExecOrQueryContext(ctx, t.db, fmt.Sprintf("/* tx:%p */ %s", t, sqls))
+}
+
+// _txConnector 所有连接均为同一事务
+type _txConnector struct {
+	tx *sql.Tx
+}
+
+func (c *_txConnector) Connect(ctx context.Context) (conn driver.Conn, err error) {
+	return &_txConn{tx: c.tx}, nil
+}
+
+func (c *_txConnector) Driver() driver.Driver {
+	return _txDriver{}
+}
+
+type _txDriver struct{}
+
+func (_txDriver) Open(name string) (conn driver.Conn, err error) {
+	return nil, errors.New("tx driver can only be used by connector")
+}
+
+// _txConn 将语句转发给事务，不支持预处理及参数
+type _txConn struct {
+	tx *sql.Tx
+}
+
+func (c *_txConn) Prepare(query string) (stmt driver.Stmt, err error) {
+	return nil, errors.New("prepare not supported in transaction executor")
+}
+
+func (c *_txConn) Close() (err error) {
+	return nil
+}
+
+func (c *_txConn) Begin() (tx driver.Tx, err error) {
+	return nil, errors.New("nested transaction not supported")
+}
+
+func (c *_txConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, err error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.tx.ExecContext(ctx, query)
+}
+
+func (c *_txConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	sqlRows, err := c.tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := sqlRows.Columns()
+	if err != nil {
+		sqlRows.Close()
+		return nil, err
+	}
+	return &_txRows{rows: sqlRows, columns: columns}, nil
+}
+
+// _txRows 事务查询结果，支持多结果集
+type _txRows struct {
+	rows    *sql.Rows
+	columns []string
+}
+
+func (r *_txRows) Columns() []string {
+	return r.columns
+}
+
+func (r *_txRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *_txRows) Next(dest []driver.Value) (err error) {
+	if !r.rows.Next() {
+		if err = r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	values := make([]any, len(dest))
+	pointers := make([]any, len(dest))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	err = r.rows.Scan(pointers...)
+	if err != nil {
+		return err
+	}
+	for i := range dest {
+		dest[i] = values[i]
+	}
+	return nil
+}
+
+// HasNextResultSet 无法预知，由 NextResultSet 返回 io.EOF 表示没有更多结果集
+func (r *_txRows) HasNextResultSet() bool {
+	return true
+}
+
+func (r *_txRows) NextResultSet() (err error) {
+	if !r.rows.NextResultSet() {
+		if err = r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	r.columns, err = r.rows.Columns()
+	return err
+}
+
+type _SQLPacketHandler struct {
+	db       *sql.DB
+	sourceId string
+}
+
+// NewSQLPacketHandler 执行SQL，处于同一资源的事务中时使用事务执行；名称与 MysqlPacketHandler 一致，可直接替换
+func NewSQLPacketHandler(db *sql.DB, sourceId string) (packHandler packethandler.PacketHandlerI) {
+	return &_SQLPacketHandler{
+		db:       db,
+		sourceId: sourceId,
+	}
+}
+
+func (h *_SQLPacketHandler) Name() string {
+	return packet.PACKETHANDLER_NAME_MysqlPacketHandler
+}
+
+func (h *_SQLPacketHandler) Description() string {
+	return `执行sql获取数据(支持事务),并输出json格式数据,数据中字段类型全部设置为string类型`
+}
+
+func (h *_SQLPacketHandler) Before(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	sqls := string(input)
+	recordSQLTables(ctx, sqls)
+	var data string
+	if t := transactionFromContext(ctx); t != nil && strings.EqualFold(t.sourceId, h.sourceId) {
+		data, err = t.tx.ExecOrQueryContext(ctx, sqls)
+	} else {
+		data, err = sqlexec.ExecOrQueryContext(ctx, h.db, sqls)
+	}
+	if err != nil {
+		return ctx, nil, err
+	}
+	return ctx, []byte(data), nil
+}
+
+func (h *_SQLPacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	return ctx, input, nil
+}
+
+func (h *_SQLPacketHandler) String() string {
+	return ""
+}
+
+type cudEventKey struct{}
+
+type _CUDEventPacketHandler struct {
+	db       *sql.DB
+	database string
+	sourceId string
+}
+
+// NewCUDEventPacketHandler 解析SQL并发布增改删事件；处于同一资源的事务中时，更新前数据在事务内查询，事件在提交后发布、回滚后丢弃；
+// 名称与 CUDEventPackHandler 一致，可直接替换
+func NewCUDEventPacketHandler(db *sql.DB, database string, sourceId string) (packHandler packethandler.PacketHandlerI) {
+	return &_CUDEventPacketHandler{
+		db:       db,
+		database: database,
+		sourceId: sourceId,
+	}
+}
+
+func (h *_CUDEventPacketHandler) Name() string {
+	return packet.PACKETHANDLER_NAME_CUDEvent
+}
+
+func (h *_CUDEventPacketHandler) Description() string {
+	return `解析sql,发布增改删事件(支持事务)`
+}
+
+func (h *_CUDEventPacketHandler) String() string {
+	return ""
+}
+
+func (h *_CUDEventPacketHandler) transaction(ctx context.Context) (t *transaction) {
+	if t = transactionFromContext(ctx); t != nil && strings.EqualFold(t.sourceId, h.sourceId) {
+		return t
+	}
+	return nil
+}
+
+// Before 事件数据保存在上下文中，处理器可被并发使用
+func (h *_CUDEventPacketHandler) Before(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	sqls := string(input)
+	stmt, err := sqlparser.Parse(sqls)
+	if err != nil {
+		return ctx, nil, err
+	}
+	event := &cudeventimpl.SQLRawEvent{
+		SQL:      sqls,
+		DB:       h.db, // 发布时查询变更后的数据，事务内的事件在提交后发布
+		Stmt:     stmt,
+		Database: h.database,
+	}
+	if update, ok := stmt.(*sqlparser.Update); ok { // 更新前数据
+		selectSQL := sqlplus.ConvertUpdateToSelect(update)
+		if t := h.transaction(ctx); t != nil {
+			event.BeforeData, err = t.tx.ExecOrQueryContext(ctx, selectSQL)
+		} else {
+			event.BeforeData, err = sqlexec.QueryContext(ctx, h.db, selectSQL)
+		}
+		if err != nil {
+			return ctx, nil, err
+		}
+	}
+	return context.WithValue(ctx, cudEventKey{}, event), input, nil
+}
+
+func (h *_CUDEventPacketHandler) After(ctx context.Context, input []byte) (newCtx context.Context, out []byte, err error) {
+	event, ok := ctx.Value(cudEventKey{}).(*cudeventimpl.SQLRawEvent)
+	if !ok {
+		return ctx, input, nil
+	}
+	switch event.Stmt.(type) {
+	case *sqlparser.Insert:
+		event.LastInsertId = string(input)
+	case *sqlparser.Update:
+		event.RowsAffected = cast.ToInt64(string(input))
+	}
+	if t := h.transaction(ctx); t != nil {
+		t.addCUDEvent(event)
+		return ctx, input, nil
+	}
+	err = cudeventimpl.PublishSQLRawEvent(event)
+	if err != nil {
+		return ctx, nil, err
+	}
+	return ctx, input, nil
+}
@@ -0,0 +1,192 @@
+package apifunc_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+	_ "github.com/suifengpiao14/apifunc/sqlitesource"
+	"github.com/suifengpiao14/cudevent"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/sqlexec/sqlexecparser"
+	"github.com/suifengpiao14/torm"
+	"github.com/tidwall/gjson"
+)
+
+func TestTransaction(t *testing.T) {
+	container := newContainer()
+	_, err := container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{
+				SourceID:   "user",
+				SourceType: apifunc.SOURCE_TYPE_SQLITE,
+				DDL:        "CREATE TABLE `t_user` (`id` int(11) NOT NULL AUTO_INCREMENT, `name` varchar(64) NOT NULL DEFAULT '', PRIMARY KEY (`id`), UNIQUE KEY `uk_name` (`name`)) ENGINE=InnoDB;",
+			},
+			{SourceID: "log", SourceType: apifunc.SOURCE_TYPE_FIXTURE, Config: `{"fixtures":[{"sql":"insert into t_log (msg) values ('a')","result":["1"]}]}`},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "CountUser", SourceID: "user", Tpl: `{{define "CountUser"}}select count(*) as total from t_user where name=:name;{{end}}`},
+			{TemplateID: "InsertUser", SourceID: "user", Tpl: `{{define "InsertUser"}}insert into t_user (name) values (:name);{{end}}`},
+			{TemplateID: "InsertLog", SourceID: "log", Tpl: `{{define "InsertLog"}}insert into t_log (msg) values ('a'){{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{newApiModel("register", "/api/register", []string{"CountUser", "InsertUser", "InsertLog"})},
+	})
+	require.NoError(t, err)
+	ctxApiFunc, err := container.GetContextApiFunc("/api/register", "POST")
+	require.NoError(t, err)
+	count := func(name string) string {
+		out, err := ctxApiFunc.RunTorm("CountUser", []byte(`{"name":"`+name+`"}`))
+		require.NoError(t, err)
+		return string(out)
+	}
+	register := func(name string, after func(txCtx *apifunc.ContextApiFunc) error) (err error) {
+		return ctxApiFunc.Transaction("user", func(txCtx *apifunc.ContextApiFunc) (err error) {
+			input := []byte(`{"name":"` + name + `"}`)
+			out, err := txCtx.RunTorm("CountUser", input)
+			if err != nil {
+				return err
+			}
+			if string(out) != "0" {
+				return errors.Errorf("user %s exists", name)
+			}
+			_, err = txCtx.RunTorm("InsertUser", input)
+			if err != nil {
+				return err
+			}
+			return after(txCtx)
+		})
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		err := register("a", func(txCtx *apifunc.ContextApiFunc) error { return nil })
+		require.NoError(t, err)
+		require.Equal(t, "1", count("a"))
+		err = register("a", func(txCtx *apifunc.ContextApiFunc) error { return nil })
+		require.ErrorContains(t, err, "user a exists")
+	})
+
+	t.Run("rollback on error", func(t *testing.T) {
+		err := register("b", func(txCtx *apifunc.ContextApiFunc) error {
+			require.Equal(t, "1", string(mustRunTorm(t, txCtx, "CountUser", `{"name":"b"}`))) // 事务内可见
+			return errors.New("abort")
+		})
+		require.ErrorContains(t, err, "abort")
+		require.Equal(t, "0", count("b"))
+	})
+
+	t.Run("rollback on panic", func(t *testing.T) {
+		require.PanicsWithValue(t, "boom", func() {
+			register("c", func(txCtx *apifunc.ContextApiFunc) error { panic("boom") })
+		})
+		require.Equal(t, "0", count("c"))
+	})
+
+	t.Run("source mismatch", func(t *testing.T) {
+		err := register("d", func(txCtx *apifunc.ContextApiFunc) error {
+			_, err := txCtx.RunTorm("InsertLog", []byte(`{}`))
+			return err
+		})
+		require.ErrorIs(t, err, apifunc.ERROR_TRANSACTION_SOURCE_MISMATCH)
+		require.Equal(t, "0", count("d"))
+		err = register("d", func(txCtx *apifunc.ContextApiFunc) error {
+			return txCtx.Transaction("log", func(txCtx *apifunc.ContextApiFunc) error { return nil })
+		})
+		require.ErrorIs(t, err, apifunc.ERROR_TRANSACTION_SOURCE_MISMATCH)
+	})
+}
+
+func mustRunTorm(t *testing.T, ctxApiFunc *apifunc.ContextApiFunc, tormName string, input string) (out []byte) {
+	out, err := ctxApiFunc.RunTorm(tormName, []byte(input))
+	require.NoError(t, err)
+	return out
+}
+
+// mysqlFlowDriver 使用SQLite执行MySQL资源的默认流程(含 CUDEvent)
+type mysqlFlowDriver struct {
+	apifunc.SourceDriverI
+}
+
+const SOURCE_TYPE_MYSQL_FLOW = "SQLITE_MYSQL_FLOW"
+
+func (d mysqlFlowDriver) Type() (sourceType string) {
+	return SOURCE_TYPE_MYSQL_FLOW
+}
+
+func (d mysqlFlowDriver) DefaultFlow() (flow packethandler.Flow) {
+	return apifunc.DefaultTormFlows
+}
+
+func (d mysqlFlowDriver) PacketHandlers(tor torm.Torm) (packetHandlers packethandler.PacketHandlers, err error) {
+	db := tor.Source.Provider.(interface{ GetDB() *sql.DB }).GetDB()
+	return packethandler.NewPacketHandlers(
+		apifunc.NewTormTransferPacketHandler(tor),
+		apifunc.NewTormRenderPacketHandler(tor),
+		apifunc.NewCUDEventPacketHandler(db, "apifunc", tor.Source.Identifer),
+		apifunc.NewSQLPacketHandler(db, tor.Source.Identifer),
+	), nil
+}
+
+func TestTransactionCUDEvent(t *testing.T) {
+	sqliteDriver, err := apifunc.GetSourceDriver(apifunc.SOURCE_TYPE_SQLITE)
+	require.NoError(t, err)
+	apifunc.RegisterSourceDriver(mysqlFlowDriver{SourceDriverI: sqliteDriver})
+	ddl := "CREATE TABLE `t_user` (`id` int(11) NOT NULL AUTO_INCREMENT, `name` varchar(64) NOT NULL DEFAULT '', PRIMARY KEY (`id`)) ENGINE=InnoDB;"
+	require.NoError(t, sqlexecparser.RegisterTableByDDL("CREATE DATABASE `apifunc`; USE `apifunc`; "+ddl)) // 事件按库名查找主键
+	events := make(chan []byte, 10)
+	err = cudevent.Subscriber(context.Background(), func(msg *message.Message) (err error) {
+		events <- msg.Payload
+		return nil
+	})
+	require.NoError(t, err)
+	noEvent := func() {
+		select {
+		case event := <-events:
+			require.Failf(t, "unexpected event", "%s", event)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	container := newContainer()
+	_, err = container.Reload(apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "user", SourceType: SOURCE_TYPE_MYSQL_FLOW, DDL: ddl},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "InsertUser", SourceID: "user", Tpl: `{{define "InsertUser"}}insert into t_user (name) values (:name);{{end}}`},
+		},
+		ApiModels: apifunc.ApiModels{newApiModel("register", "/api/register", []string{"InsertUser"})},
+	})
+	require.NoError(t, err)
+	ctxApiFunc, err := container.GetContextApiFunc("/api/register", "POST")
+	require.NoError(t, err)
+
+	t.Run("publish after commit", func(t *testing.T) {
+		err := ctxApiFunc.Transaction("user", func(txCtx *apifunc.ContextApiFunc) (err error) {
+			mustRunTorm(t, txCtx, "InsertUser", `{"name":"a"}`)
+			noEvent()
+			return nil
+		})
+		require.NoError(t, err)
+		select {
+		case event := <-events:
+			require.Equal(t, "created", gjson.GetBytes(event, "eventType").String())
+			require.Equal(t, "a", gjson.Get(gjson.GetBytes(event, "payload.0.after").String(), "name").String())
+		case <-time.After(time.Second):
+			require.FailNow(t, "event not published")
+		}
+	})
+
+	t.Run("drop on rollback", func(t *testing.T) {
+		err := ctxApiFunc.Transaction("user", func(txCtx *apifunc.ContextApiFunc) (err error) {
+			mustRunTorm(t, txCtx, "InsertUser", `{"name":"b"}`)
+			return errors.New("abort")
+		})
+		require.ErrorContains(t, err, "abort")
+		noEvent()
+	})
+}
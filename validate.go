@@ -0,0 +1,342 @@
+package apifunc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/lineschema"
+	"github.com/suifengpiao14/packethandler"
+	"github.com/suifengpiao14/pathtransfer"
+	"github.com/suifengpiao14/sqlexec"
+	"github.com/suifengpiao14/torm"
+)
+
+const (
+	VALIDATION_SEVERITY_ERROR   = "error"
+	VALIDATION_SEVERITY_WARNING = "warning"
+)
+
+const (
+	VALIDATION_ENTITY_API           = "api"
+	VALIDATION_ENTITY_TORM          = "torm"
+	VALIDATION_ENTITY_SOURCE        = "source"
+	VALIDATION_ENTITY_TRANSFER_FUNC = "transferFunc"
+)
+
+// ValidationIssue 校验发现的问题
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	Entity   string `json:"entity"`   // api、torm、source、transferFunc
+	EntityId string `json:"entityId"` // apiId、templateId、sourceId，转换函数为序号
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+func (issue ValidationIssue) String() string {
+	return fmt.Sprintf("[%s] %s:%s %s: %s", issue.Severity, issue.Entity, issue.EntityId, issue.Field, issue.Message)
+}
+
+// ValidationReport 校验报告
+type ValidationReport struct {
+	Issues []ValidationIssue `json:"issues"`
+}
+
+func (report *ValidationReport) add(severity string, entity string, entityId string, field string, format string, args ...any) {
+	report.Issues = append(report.Issues, ValidationIssue{
+		Severity: severity,
+		Entity:   entity,
+		EntityId: entityId,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// HasError 是否存在错误级别的问题
+func (report ValidationReport) HasError() (ok bool) {
+	return len(report.Errors()) > 0
+}
+
+// Errors 错误级别的问题
+func (report ValidationReport) Errors() (issues []ValidationIssue) {
+	issues = make([]ValidationIssue, 0)
+	for _, issue := range report.Issues {
+		if issue.Severity == VALIDATION_SEVERITY_ERROR {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+func (report ValidationReport) String() string {
+	lines := make([]string, 0, len(report.Issues))
+	for _, issue := range report.Issues {
+		lines = append(lines, issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate 校验一组模型(结合代码注册的api)，收集全部问题而非遇错即停，不连接资源、不修改容器，
+// 可在CI中使用，拒绝有问题的配置变更
+func (c *Container) Validate(models ModelSet) (report ValidationReport) {
+	report.Issues = make([]ValidationIssue, 0)
+	validateSources(&report, models.SourceModels)
+	c.validateSecrets(&report, models.SourceModels)
+	tormIds := validateTorms(&report, models.TormModels, models.SourceModels)
+	funcNames := validateTransferFuncs(&report, models.TransferFuncModels)
+	c.validateApis(&report, models, tormIds, funcNames)
+	return report
+}
+
+func validateSources(report *ValidationReport, sourceModels SourceModels) {
+	sourceIds := make(map[string]bool)
+	for _, sourceModel := range sourceModels {
+		id := sourceModel.SourceID
+		if id == "" {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, id, "sourceId", "sourceId required")
+			continue
+		}
+		key := strings.ToLower(id)
+		if sourceIds[key] {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, id, "sourceId", "duplicate sourceId")
+		}
+		sourceIds[key] = true
+		switch strings.ToUpper(sourceModel.SourceType) {
+		case torm.SOURCE_TYPE_SQL:
+			_, err := sqlexec.JsonToDBConfig(sourceModel.Config)
+			if err != nil {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, id, "config", "%s", err.Error())
+			}
+			if strings.TrimSpace(sourceModel.DDL) == "" {
+				report.add(VALIDATION_SEVERITY_WARNING, VALIDATION_ENTITY_SOURCE, id, "ddl", "ddl empty, it will be read from database when compiling")
+			}
+		case SOURCE_TYPE_SQLITE:
+			config := SQLiteSourceConfig{}
+			if err := json.Unmarshal([]byte(sourceModel.Config), &config); strings.TrimSpace(sourceModel.Config) != "" && err != nil {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, id, "config", "%s", err.Error())
+			}
+			if strings.TrimSpace(sourceModel.DDL) == "" && (config.DSN == "" || config.DSN == ":memory:") {
+				report.add(VALIDATION_SEVERITY_WARNING, VALIDATION_ENTITY_SOURCE, id, "ddl", "ddl empty, in-memory database will have no table")
+			}
+		}
+		if _, err := GetSourceDriver(sourceModel.SourceType); err != nil {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, id, "sourceType", "unsupported source type:%s", sourceModel.SourceType)
+		}
+	}
+}
+
+// validateSecrets 检查资源配置引用的密钥均可解析，报告中不包含密钥值
+func (c *Container) validateSecrets(report *ValidationReport, sourceModels SourceModels) {
+	for _, sourceModel := range sourceModels {
+		fields := []struct{ name, value string }{{"config", sourceModel.Config}, {"sshConfig", sourceModel.SSHConfig}}
+		for _, field := range fields {
+			for _, name := range secretNames(field.value) {
+				if c.secretResolver == nil {
+					report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, sourceModel.SourceID, field.name, "secret:%s %s", name, ERROR_SECRET_RESOLVER_NOT_SET.Error())
+					continue
+				}
+				if _, err := c.secretResolver.Resolve(name); err != nil {
+					report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_SOURCE, sourceModel.SourceID, field.name, "secret:%s %s", name, err.Error())
+				}
+			}
+		}
+	}
+}
+
+func validateTorms(report *ValidationReport, tormModels TormModels, sourceModels SourceModels) (tormIds map[string]bool) {
+	tormIds = make(map[string]bool)
+	sourceTypes := make(map[string]string)
+	for _, sourceModel := range sourceModels {
+		sourceTypes[strings.ToLower(sourceModel.SourceID)] = sourceModel.SourceType
+	}
+	for sourceId, group := range tormModels.GroupBySourceId() {
+		sourceType, sourceLoaded := sourceTypes[strings.ToLower(sourceId)]
+		knownHandlers := make(map[string]bool) // 资源驱动默认流程中的处理器
+		for _, name := range defaultTormFlow(sourceType) {
+			knownHandlers[name] = true
+		}
+		root, rootErr := torm.NewTemplate().Parse(group.GetTpl())
+		for _, tormModel := range group {
+			id := tormModel.TemplateID
+			if id == "" {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "templateId", "templateId required")
+				continue
+			}
+			key := strings.ToLower(id)
+			if tormIds[key] {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "templateId", "duplicate templateId")
+			}
+			tormIds[key] = true
+			if !sourceLoaded {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "sourceId", "source %s not loaded for current env", sourceId)
+			}
+			_, err := torm.NewTemplate().Parse(tormModel.Tpl)
+			if err != nil {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "tpl", "%s", err.Error())
+			} else if rootErr == nil && root.Lookup(id) == nil {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "tpl", `template {{define "%s"}} not found`, id)
+			}
+			for _, name := range packethandler.ToFlow(tormModel.Flow) {
+				if !knownHandlers[strings.TrimSpace(name)] {
+					report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "flow", "unregistered packet handler:%s", name)
+				}
+			}
+			_, err = tormModel.Policy.Policy()
+			if err != nil {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, id, "policy", "%s", err.Error())
+			}
+		}
+	}
+	if _, err := tormModels.Policies(); err != nil {
+		report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TORM, "", "policy", "%s", err.Error())
+	}
+	return tormIds
+}
+
+func validateTransferFuncs(report *ValidationReport, transferFuncModels TransferFuncModels) (funcNames map[string]bool) {
+	funcNames = make(map[string]bool)
+	for i, transferFuncModel := range transferFuncModels {
+		id := fmt.Sprintf("%d", i)
+		if transferFuncModel.Script != "" && transferFuncModel.Language == "" {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TRANSFER_FUNC, id, "language", "language required")
+		}
+		transfers := transferFuncModel.TransferLine.Transfer()
+		for _, transfer := range transfers {
+			path := transfer.Src.Path
+			if !path.HasNamespace(pathtransfer.Transfer_Top_Namespace_Func) {
+				continue
+			}
+			namespace, _ := path.TrimNamespace(pathtransfer.Transfer_Top_Namespace_Func).SplitByIO()
+			funcNames[strings.ToLower(namespace)] = true
+		}
+		if transferFuncModel.Language != "" {
+			_, err := transfers.GetCallFnScript(transferFuncModel.Language)
+			if err != nil {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TRANSFER_FUNC, id, "transferLine", "%s", err.Error())
+			}
+		}
+	}
+	return funcNames
+}
+
+func (c *Container) validateApis(report *ValidationReport, models ModelSet, tormIds map[string]bool, funcNames map[string]bool) {
+	apis := make(Apis, 0, len(c.codeApis)+len(models.ApiModels))
+	apis = append(apis, c.codeApis...)
+	for _, apiModel := range models.ApiModels {
+		api := apiModel.Api()
+		api.ResponseDefaultJson = string(models.ResponseDefaultJson)
+		if api.ApiName == "" {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, "", "apiId", "apiId required,route:%s", api.Route)
+			continue
+		}
+		for _, exists := range apis {
+			if strings.EqualFold(exists.ApiName, api.ApiName) && exists.Route != "" && api.Route != "" && !strings.EqualFold(exists.Key(), api.Key()) {
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, api.ApiName, "apiId", "duplicate apiId,routes:%s %s,%s %s", exists.Method, exists.Route, api.Method, api.Route)
+			}
+		}
+		apis.AddMerge(api)
+	}
+	_, err := NewRouter(apis)
+	if err != nil {
+		report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, "", "route", "%s", err.Error())
+	}
+
+	for _, api := range apis {
+		id := api.ApiName
+		if api.modelErr != nil {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, id, "model", "%s", api.modelErr.Error())
+			api.modelErr = nil
+		}
+		validateApiSchema(report, api, "inputSchema", api.RequestLineschema, true)
+		validateApiSchema(report, api, "outputSchema", api.ResponseLineschema, false)
+		tormDependents := 0
+		for _, dep := range api.Dependents {
+			switch dep.Type {
+			case Dependent_Type_Torm:
+				tormDependents++
+				if !tormIds[strings.ToLower(dep.Fullname)] {
+					report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, id, "dependents", "torm %s not found", dep.Fullname)
+				}
+			case Dependent_Type_TransferFunc:
+				if !funcNames[strings.ToLower(dep.Fullname)] {
+					report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, id, "dependents", "transferFunc %s not found", dep.Fullname)
+				}
+			default:
+				report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, id, "dependents", "unknown dependent type %s:%s", dep.Type, dep.Fullname)
+			}
+		}
+		if api.businessFlowFn == nil && strings.TrimSpace(api.Script) == "" && tormDependents == 0 {
+			report.add(VALIDATION_SEVERITY_WARNING, VALIDATION_ENTITY_API, id, "script", "no business function, script or torm dependents")
+		}
+		err = api.Init()
+		if err != nil {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, id, "schema", "%s", err.Error())
+			continue
+		}
+		_, err = api.PacketHandlers.GetByName(api.Flow...)
+		if err != nil {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, id, "flow", "%s", err.Error())
+		}
+	}
+
+	//脚本在独立的项目中编译，不影响容器
+	staging := &Container{}
+	staging.RegisterProject(models.ScriptLanguage, nil, models.TransferFuncModels)
+	err = staging.project.Init()
+	if err == nil {
+		err = staging.project.compileApiScripts(apis)
+	}
+	var compileErrs ScriptCompileErrors
+	var compileErr *ScriptCompileError
+	switch {
+	case err == nil:
+	case errors.As(err, &compileErrs):
+		for _, e := range compileErrs {
+			report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, e.ApiId, "script", "%s:%d: %s", e.File, e.Line, e.Err.Error())
+		}
+	case errors.As(err, &compileErr):
+		report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, compileErr.ApiId, "script", "%s:%d: %s", compileErr.File, compileErr.Line, compileErr.Err.Error())
+	default:
+		report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_TRANSFER_FUNC, "", "script", "%s", err.Error())
+	}
+}
+
+// validateApiSchema 校验lineschema，以及转换路径引用的字段存在于lineschema中
+func validateApiSchema(report *ValidationReport, api Api, field string, raw string, in bool) {
+	schema, err := lineschema.ParseLineschema(raw)
+	if err != nil {
+		report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, api.ApiName, field, "%s", err.Error())
+		return
+	}
+	fullnames := make([]string, 0, len(schema.Items))
+	for _, item := range schema.Items {
+		fullnames = append(fullnames, normalizeSchemaPath(item.Fullname))
+	}
+	for _, transfer := range api.PathTransfers {
+		if transfer.IsIn() != in || (!in && !transfer.IsOut()) {
+			continue
+		}
+		localName := normalizeSchemaPath(transfer.Src.Path.TrimIONamespace())
+		if localName == "" || hasSchemaPath(fullnames, localName) {
+			continue
+		}
+		report.add(VALIDATION_SEVERITY_ERROR, VALIDATION_ENTITY_API, api.ApiName, "transferLine", "%s references field %s not found in %s", transfer.String(), localName, field)
+	}
+}
+
+func normalizeSchemaPath(path string) (normalized string) {
+	normalized = strings.ReplaceAll(path, "[]", "")
+	normalized = strings.ReplaceAll(normalized, ".#", "")
+	return strings.Trim(normalized, ".")
+}
+
+// hasSchemaPath 字段存在，或者是某个字段的上级对象
+func hasSchemaPath(fullnames []string, path string) (ok bool) {
+	for _, fullname := range fullnames {
+		if strings.EqualFold(fullname, path) || strings.HasPrefix(strings.ToLower(fullname), strings.ToLower(path)+".") {
+			return true
+		}
+	}
+	return false
+}
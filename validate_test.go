@@ -0,0 +1,41 @@
+package apifunc_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/apifunc"
+)
+
+func TestContainerValidate(t *testing.T) {
+	getUser := newApiModel("getUser", "/api/user", []string{"GetUser", "DeleteUser"})
+	getUser.PathTransferLine = "getUser.input.userId:dictionary.t_user.id"
+	getUser.Flow = "notRegistered"
+	models := apifunc.ModelSet{
+		SourceModels: apifunc.SourceModels{
+			{SourceID: "db", SourceType: "SQL", Config: `{"dsn":"root:123456@tcp(127.0.0.1:3306)/test"}`, DDL: "create table t_user (id int);"},
+			{SourceID: "mq", SourceType: "KAFKA"},
+		},
+		TormModels: apifunc.TormModels{
+			{TemplateID: "GetUser", SourceID: "db", Tpl: `{{define "GetUser"}} select * from t_user where id=:id {{end}}`},
+			{TemplateID: "ListUser", SourceID: "cache", Tpl: `{{define "ListUser"}} select * from t_user {{end}}`, Flow: "unknownHandler"},
+		},
+		ApiModels: apifunc.ApiModels{getUser},
+	}
+	report := newContainer().Validate(models)
+	require.True(t, report.HasError())
+	expected := []apifunc.ValidationIssue{
+		{Severity: apifunc.VALIDATION_SEVERITY_ERROR, Entity: apifunc.VALIDATION_ENTITY_SOURCE, EntityId: "mq", Field: "sourceType"},
+		{Severity: apifunc.VALIDATION_SEVERITY_ERROR, Entity: apifunc.VALIDATION_ENTITY_TORM, EntityId: "ListUser", Field: "sourceId"},
+		{Severity: apifunc.VALIDATION_SEVERITY_ERROR, Entity: apifunc.VALIDATION_ENTITY_TORM, EntityId: "ListUser", Field: "flow"},
+		{Severity: apifunc.VALIDATION_SEVERITY_ERROR, Entity: apifunc.VALIDATION_ENTITY_API, EntityId: "getUser", Field: "dependents"},
+		{Severity: apifunc.VALIDATION_SEVERITY_ERROR, Entity: apifunc.VALIDATION_ENTITY_API, EntityId: "getUser", Field: "transferLine"},
+		{Severity: apifunc.VALIDATION_SEVERITY_ERROR, Entity: apifunc.VALIDATION_ENTITY_API, EntityId: "getUser", Field: "flow"},
+	}
+	got := make([]apifunc.ValidationIssue, 0)
+	for _, issue := range report.Errors() {
+		issue.Message = ""
+		got = append(got, issue)
+	}
+	require.ElementsMatch(t, expected, got, report.String())
+}